@@ -0,0 +1,385 @@
+// Package client is a Go SDK for the WhisperBin JSON API.
+//
+//	c := client.New("https://secrets.example.com", client.WithAPIKey(key))
+//	created, err := c.Create(ctx, "hunter2", client.CreateOptions{TTLMinutes: 30})
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+type Option func(*Client)
+
+// WithAPIKey sets the key sent as a bearer token when creating secrets.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type CreateOptions struct {
+	// TTLMinutes of zero uses the server default.
+	TTLMinutes int
+	// Secure requires the sender to approve the recipient with a passcode.
+	Secure bool
+	// Opaque marks the secret as already encrypted with EncryptOpaque.
+	Opaque bool
+	// Views is how often the secret can be revealed; zero means once.
+	Views int
+	// Passphrase must be entered by the recipient to reveal the secret.
+	// BurnAfter destroys it after that many wrong passphrases.
+	Passphrase string
+	BurnAfter  int
+	// NotifyEmail is emailed when the secret is read, if the server has
+	// email notifications enabled.
+	NotifyEmail string
+	// Recipients and RecipientGroups restrict who may open the secret.
+	// Restricted secrets can only be opened in the browser after login.
+	Recipients      []string
+	RecipientGroups []string
+	// AllowedNetworks are IPs or CIDRs the secret can only be revealed
+	// from.
+	AllowedNetworks []string
+}
+
+type Created struct {
+	ID        string    `json:"id"`
+	Link      string    `json:"link"`
+	Passcode  string    `json:"passcode"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ManageToken authorizes Receipt and RevokeWithToken. The server only
+	// returns it once.
+	ManageToken string `json:"manage_token"`
+	ManageLink  string `json:"manage_link"`
+}
+
+// Receipt tells the sender what happened to a secret. Status is one of
+// "pending", "viewed", "expired", "revoked" or "burned".
+type Receipt struct {
+	ID             string      `json:"id"`
+	Status         string      `json:"status"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ExpiresAt      time.Time   `json:"expires_at"`
+	Views          int         `json:"views"`
+	ViewsRemaining int         `json:"views_remaining"`
+	ViewedAt       []time.Time `json:"viewed_at"`
+	RevokedAt      *time.Time  `json:"revoked_at"`
+}
+
+type SplitOptions struct {
+	// TTLMinutes of zero uses the server default.
+	TTLMinutes int
+	// Shares links are created, of which Threshold recover the secret.
+	Shares    int
+	Threshold int
+}
+
+// Shares are the links of a split secret. Each one is a separate one-time
+// secret with its own management token.
+type Shares struct {
+	Threshold int       `json:"threshold"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Shares    []Created `json:"shares"`
+}
+
+type Status struct {
+	ID          string    `json:"id"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Secure      bool      `json:"secure"`
+	Unlocked    bool      `json:"unlocked"`
+	Opaque      bool      `json:"opaque"`
+	Passphrase  bool      `json:"passphrase"`
+	Passcode    string    `json:"passcode"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+
+	ViewsRemaining int `json:"views_remaining"`
+	// Threshold is set on a share of a split secret; use Combine.
+	Threshold int `json:"threshold"`
+}
+
+// Secret is a revealed secret. For file attachments FileName is set and
+// Text holds the raw file content.
+type Secret struct {
+	Text        string `json:"secret"`
+	Opaque      bool   `json:"opaque"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Encoding    string `json:"encoding"`
+	// ViewsRemaining counts the reveals left after this one.
+	ViewsRemaining int `json:"views_remaining"`
+}
+
+func (c *Client) Create(ctx context.Context, secret string, opts CreateOptions) (*Created, error) {
+	body := struct {
+		Secret string `json:"secret"`
+		TTL    int    `json:"ttl,omitempty"`
+		Secure bool   `json:"secure,omitempty"`
+		Opaque bool   `json:"opaque,omitempty"`
+		Views  int    `json:"views,omitempty"`
+
+		Passphrase string `json:"passphrase,omitempty"`
+		BurnAfter  int    `json:"burn_after,omitempty"`
+
+		NotifyEmail string `json:"notify_email,omitempty"`
+
+		Recipients      []string `json:"recipients,omitempty"`
+		RecipientGroups []string `json:"recipient_groups,omitempty"`
+		AllowedNetworks []string `json:"allowed_networks,omitempty"`
+	}{secret, opts.TTLMinutes, opts.Secure, opts.Opaque, opts.Views, opts.Passphrase, opts.BurnAfter, opts.NotifyEmail, opts.Recipients, opts.RecipientGroups, opts.AllowedNetworks}
+
+	var created Created
+	if err := c.do(ctx, http.MethodPost, "", body, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Split divides secret into Shamir shares stored as separate one-time
+// links.
+func (c *Client) Split(ctx context.Context, secret string, opts SplitOptions) (*Shares, error) {
+	body := struct {
+		Secret    string `json:"secret"`
+		TTL       int    `json:"ttl,omitempty"`
+		Shares    int    `json:"shares"`
+		Threshold int    `json:"threshold"`
+	}{secret, opts.TTLMinutes, opts.Shares, opts.Threshold}
+
+	var shares Shares
+	if err := c.call(ctx, http.MethodPost, "/api/v1/shares", body, &shares); err != nil {
+		return nil, err
+	}
+	return &shares, nil
+}
+
+// Combine recovers a split secret from share links or IDs, consuming
+// them. With too few shares it fails with ErrNotEnoughShares and leaves
+// every share in place.
+func (c *Client) Combine(ctx context.Context, links []string) (string, error) {
+	var secret Secret
+	if err := c.call(ctx, http.MethodPost, "/api/v1/shares/combine", map[string][]string{"links": links}, &secret); err != nil {
+		return "", err
+	}
+	return secret.Text, nil
+}
+
+// Reveal consumes the secret. Secure-mode secrets that have not been
+// approved yet fail with ErrApprovalRequired; use WaitForUnlock instead.
+func (c *Client) Reveal(ctx context.Context, id string) (*Secret, error) {
+	return c.reveal(ctx, id, nil)
+}
+
+// RevealWithPassphrase consumes a passphrase-protected secret. A wrong
+// passphrase fails with ErrInvalidPassphrase and leaves the secret in
+// place, unless the sender's burn limit is reached (ErrBurned).
+func (c *Client) RevealWithPassphrase(ctx context.Context, id, passphrase string) (*Secret, error) {
+	return c.reveal(ctx, id, map[string]string{"passphrase": passphrase})
+}
+
+func (c *Client) reveal(ctx context.Context, id string, body interface{}) (*Secret, error) {
+	var secret Secret
+	if err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(id)+"/reveal", body, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Encoding == "base64" {
+		raw, err := base64.StdEncoding.DecodeString(secret.Text)
+		if err != nil {
+			return nil, err
+		}
+		secret.Text, secret.Encoding = string(raw), ""
+	}
+	return &secret, nil
+}
+
+// Status returns metadata without consuming the secret.
+func (c *Client) Status(ctx context.Context, id string) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(id), nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) Confirm(ctx context.Context, id, code string) error {
+	return c.do(ctx, http.MethodPost, "/"+url.PathEscape(id)+"/confirm", map[string]string{"code": code}, nil)
+}
+
+// Receipt returns the sender's view of a secret, which the server keeps for
+// a while after the secret has been read or has expired.
+func (c *Client) Receipt(ctx context.Context, id, manageToken string) (*Receipt, error) {
+	var receipt Receipt
+	if err := c.doManaged(ctx, http.MethodGet, "/"+url.PathEscape(id)+"/receipt", manageToken, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// Revoke deletes a secret on behalf of its sender and records the
+// revocation on the receipt. It fails with ErrNotPending once the secret
+// was read or has expired.
+func (c *Client) Revoke(ctx context.Context, id, manageToken string) error {
+	return c.doManaged(ctx, http.MethodPost, "/"+url.PathEscape(id)+"/revoke", manageToken, nil)
+}
+
+// RevokeWithToken is Revoke.
+//
+// Deprecated: use Revoke.
+func (c *Client) RevokeWithToken(ctx context.Context, id, manageToken string) error {
+	return c.Revoke(ctx, id, manageToken)
+}
+
+// WaitForUnlock waits on the server's event stream until the sender
+// approves the recipient and returns the delivered secret, which the server
+// deletes at the same time. File attachments are fetched with Reveal once
+// the stream reports the unlock.
+func (c *Client) WaitForUnlock(ctx context.Context, id string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/sse?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", decodeError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if event == "file" {
+			secret, err := c.Reveal(ctx, id)
+			if err != nil {
+				return "", err
+			}
+			return secret.Text, nil
+		}
+		data = strings.ReplaceAll(html.UnescapeString(data), `\n`, "\n")
+		if msg, isErr := strings.CutPrefix(data, "error: "); isErr {
+			if known, ok := sseErrors[msg]; ok {
+				return "", known
+			}
+			return "", fmt.Errorf("whisperbin: %s", msg)
+		}
+		return data, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("whisperbin: connection closed before the secret was delivered")
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	return c.call(ctx, method, "/api/v1/secrets"+path, body, out)
+}
+
+// call sends a JSON request to path below the server's base URL.
+func (c *Client) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return c.send(req, out)
+}
+
+// doManaged calls a sender endpoint authorized by the management token.
+func (c *Client) doManaged(ctx context.Context, method, path, token string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v1/secrets"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Management-Token", token)
+	return c.send(req, out)
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
+
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+		apiErr.Message = body.Error
+		apiErr.Code = body.Code
+	}
+	apiErr.err = errorsByCode[apiErr.Code]
+	if apiErr.err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		apiErr.err = ErrRateLimited
+	}
+	return apiErr
+}
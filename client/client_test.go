@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"whisperbin/internal"
+	"whisperbin/internal/storage"
+	"whisperbin/internal/web"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *storage.Store) {
+	t.Helper()
+	store := storage.NewStore()
+	h := web.NewHandlerWithTemplates(store, "../ui/templates/*.html")
+	server := httptest.NewServer(h.Routes())
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+func TestClient_CreateStatusRevealRevoke(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	created, err := c.Create(ctx, "from the sdk", CreateOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if created.ID == "" || created.ExpiresAt.IsZero() {
+		t.Fatalf("Unexpected create result: %+v", created)
+	}
+
+	status, err := c.Status(ctx, created.ID)
+	if err != nil || !status.Unlocked || status.Secure {
+		t.Fatalf("Unexpected status %+v, %v", status, err)
+	}
+
+	secret, err := c.Reveal(ctx, created.ID)
+	if err != nil || secret.Text != "from the sdk" {
+		t.Fatalf("Unexpected reveal %+v, %v", secret, err)
+	}
+
+	if _, err := c.Reveal(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound on second reveal, got %v", err)
+	}
+
+	revoked, _ := c.Create(ctx, "revoke me", CreateOptions{})
+	if err := c.Revoke(ctx, revoked.ID, revoked.ManageToken); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := c.Status(ctx, revoked.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after revoke, got %v", err)
+	}
+}
+
+func TestClient_SecureFlow(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	created, err := c.Create(ctx, "line1\nline2 <&>", CreateOptions{Secure: true})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if _, err := c.Reveal(ctx, created.ID); !errors.Is(err, ErrApprovalRequired) {
+		t.Errorf("Expected ErrApprovalRequired, got %v", err)
+	}
+	if err := c.Confirm(ctx, created.ID, created.Passcode); !errors.Is(err, ErrNoRecipient) {
+		t.Errorf("Expected ErrNoRecipient, got %v", err)
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		text, err := c.WaitForUnlock(ctx, created.ID)
+		if err != nil {
+			t.Errorf("WaitForUnlock failed: %v", err)
+		}
+		result <- text
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := c.Confirm(ctx, created.ID, created.Passcode)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrNoRecipient) || time.Now().After(deadline) {
+			t.Fatalf("Confirm failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case text := <-result:
+		if text != "line1\nline2 <&>" {
+			t.Errorf("Unexpected secret %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for unlock")
+	}
+}
+
+func TestClient_BlockedAfterWrongCodes(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	created, _ := c.Create(ctx, "guarded", CreateOptions{Secure: true})
+	for i := 0; i < internal.MaxCodeFailures; i++ {
+		if err := c.Confirm(ctx, created.ID, "000000"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("Attempt %d: expected ErrInvalidCode, got %v", i, err)
+		}
+	}
+	err := c.Confirm(ctx, created.ID, created.Passcode)
+	if !errors.Is(err, ErrBlocked) || errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrBlocked, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Errorf("Expected APIError with status 429, got %v", err)
+	}
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+
+	var err error
+	for i := 0; i < 20 && !errors.Is(err, ErrRateLimited); i++ {
+		_, err = c.Status(context.Background(), "missing")
+	}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestClient_ZeroKnowledgeRoundTrip(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	blob, key, err := EncryptOpaque([]byte("ssh-ed25519 AAAA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := c.Create(ctx, blob, CreateOptions{Opaque: true})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	_, id, fragment, err := ParseLink(created.Link + "#" + key)
+	if err != nil || id != created.ID || fragment != key {
+		t.Fatalf("ParseLink returned %q, %q, %v", id, fragment, err)
+	}
+
+	secret, err := c.Reveal(ctx, id)
+	if err != nil || !secret.Opaque {
+		t.Fatalf("Unexpected reveal %+v, %v", secret, err)
+	}
+	plain, err := DecryptOpaque(secret.Text, fragment)
+	if err != nil || string(plain) != "ssh-ed25519 AAAA" {
+		t.Errorf("Unexpected plaintext %q, %v", plain, err)
+	}
+}
+
+func TestClient_Passphrase(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	created, err := c.Create(ctx, "vault token", CreateOptions{Passphrase: "open sesame", BurnAfter: 2})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if status, err := c.Status(ctx, created.ID); err != nil || !status.Passphrase {
+		t.Fatalf("Expected passphrase status, got %+v, %v", status, err)
+	}
+
+	if _, err := c.Reveal(ctx, created.ID); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+	if _, err := c.RevealWithPassphrase(ctx, created.ID, "guess"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("Expected ErrInvalidPassphrase, got %v", err)
+	}
+	secret, err := c.RevealWithPassphrase(ctx, created.ID, "open sesame")
+	if err != nil || secret.Text != "vault token" {
+		t.Fatalf("Unexpected reveal %+v, %v", secret, err)
+	}
+
+	burned, _ := c.Create(ctx, "x", CreateOptions{Passphrase: "pw", BurnAfter: 1})
+	if _, err := c.RevealWithPassphrase(ctx, burned.ID, "guess"); !errors.Is(err, ErrBurned) {
+		t.Errorf("Expected ErrBurned, got %v", err)
+	}
+	if _, err := c.Status(ctx, burned.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected burned secret to be gone, got %v", err)
+	}
+}
+
+func TestClient_ReceiptAndRevoke(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	created, err := c.Create(ctx, "oops", CreateOptions{})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	baseURL, id, token, err := ParseManageLink(created.ManageLink)
+	if err != nil || id != created.ID || token != created.ManageToken || baseURL == "" {
+		t.Fatalf("ParseManageLink = %q, %q, %q, %v", baseURL, id, token, err)
+	}
+
+	if err := c.Revoke(ctx, created.ID, "guess"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for wrong token, got %v", err)
+	}
+	if err := c.Revoke(ctx, created.ID, created.ManageToken); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	receipt, err := c.Receipt(ctx, created.ID, created.ManageToken)
+	if err != nil || receipt.Status != "revoked" || receipt.RevokedAt == nil {
+		t.Fatalf("Unexpected receipt %+v, %v", receipt, err)
+	}
+	if err := c.Revoke(ctx, created.ID, created.ManageToken); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Expected ErrNotPending, got %v", err)
+	}
+}
+
+func TestClient_SplitAndCombine(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	split, err := c.Split(ctx, "root:toor", SplitOptions{Shares: 3, Threshold: 2})
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if split.Threshold != 2 || len(split.Shares) != 3 {
+		t.Fatalf("Unexpected split %+v", split)
+	}
+	if status, err := c.Status(ctx, split.Shares[0].ID); err != nil || status.Threshold != 2 {
+		t.Errorf("Expected share status with threshold, got %+v, %v", status, err)
+	}
+	if _, err := c.Reveal(ctx, split.Shares[0].ID); !errors.Is(err, ErrCombineRequired) {
+		t.Errorf("Expected ErrCombineRequired, got %v", err)
+	}
+	if _, err := c.Combine(ctx, []string{split.Shares[0].Link}); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("Expected ErrNotEnoughShares, got %v", err)
+	}
+
+	text, err := c.Combine(ctx, []string{split.Shares[2].Link, split.Shares[0].Link})
+	if err != nil || text != "root:toor" {
+		t.Fatalf("Combine = %q, %v", text, err)
+	}
+}
+
+func TestParseLink_RejectsNonLinks(t *testing.T) {
+	for _, link := range []string{"", "abc", "https://example.com/", "https://example.com/a/b"} {
+		if _, _, _, err := ParseLink(link); err == nil {
+			t.Errorf("Expected error for %q", link)
+		}
+	}
+}
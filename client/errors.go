@@ -0,0 +1,86 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound         = errors.New("whisperbin: secret not found or expired")
+	ErrApprovalRequired = errors.New("whisperbin: secret requires sender approval")
+	ErrInvalidCode      = errors.New("whisperbin: invalid passcode")
+	ErrBlocked          = errors.New("whisperbin: too many failed attempts, temporarily blocked")
+	ErrNoRecipient      = errors.New("whisperbin: no recipient waiting")
+	ErrAlreadyUnlocked  = errors.New("whisperbin: already unlocked")
+	ErrListenerBusy     = errors.New("whisperbin: another recipient is already waiting")
+	ErrNotSecure        = errors.New("whisperbin: secret is not waiting for approval")
+	ErrRateLimited      = errors.New("whisperbin: rate limited")
+	ErrQuotaExceeded    = errors.New("whisperbin: API key quota exceeded")
+	ErrUnauthorized     = errors.New("whisperbin: missing or invalid API key")
+
+	ErrPassphraseRequired = errors.New("whisperbin: secret requires a passphrase")
+	ErrInvalidPassphrase  = errors.New("whisperbin: invalid passphrase")
+	ErrBurned             = errors.New("whisperbin: secret destroyed after too many wrong passphrases")
+	ErrNotPending         = errors.New("whisperbin: secret was already read, revoked or has expired")
+	ErrBusy               = errors.New("whisperbin: server busy checking passphrases, try again later")
+
+	ErrCombineRequired = errors.New("whisperbin: secret is a share; use Combine")
+	ErrNotEnoughShares = errors.New("whisperbin: not enough shares to recover the secret")
+	ErrInvalidShares   = errors.New("whisperbin: shares do not belong to the same secret")
+
+	ErrRecipientRestricted = errors.New("whisperbin: secret is addressed to specific people; open it in the browser")
+	ErrNetworkDenied       = errors.New("whisperbin: secret is not available from this network")
+)
+
+// APIError is returned for every non-success response. It wraps one of the
+// sentinel errors above when the server's error code is known, so callers
+// can use errors.Is.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	err        error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("whisperbin: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+var errorsByCode = map[string]error{
+	"not_found":         ErrNotFound,
+	"approval_required": ErrApprovalRequired,
+	"invalid_code":      ErrInvalidCode,
+	"blocked":           ErrBlocked,
+	"no_recipient":      ErrNoRecipient,
+	"already_unlocked":  ErrAlreadyUnlocked,
+	"rate_limited":      ErrRateLimited,
+	"quota_exceeded":    ErrQuotaExceeded,
+	"unauthorized":      ErrUnauthorized,
+
+	"passphrase_required": ErrPassphraseRequired,
+	"invalid_passphrase":  ErrInvalidPassphrase,
+	"burned":              ErrBurned,
+	"not_pending":         ErrNotPending,
+	"busy":                ErrBusy,
+
+	"combine_required":  ErrCombineRequired,
+	"not_enough_shares": ErrNotEnoughShares,
+	"invalid_shares":    ErrInvalidShares,
+
+	"recipient_restricted": ErrRecipientRestricted,
+	"network_denied":       ErrNetworkDenied,
+}
+
+// sseErrors maps the plain-text errors sent on /sse to sentinel errors.
+var sseErrors = map[string]error{
+	"not found or expired":                ErrNotFound,
+	"listener already connected":          ErrListenerBusy,
+	"not secure mode":                     ErrNotSecure,
+	"login required":                      ErrRecipientRestricted,
+	"secret is addressed to someone else": ErrRecipientRestricted,
+	"not available from this network":     ErrNetworkDenied,
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParseLink splits a secret link into the server URL, the secret ID and the
+// zero-knowledge key carried in the fragment, if any.
+func ParseLink(link string) (baseURL, id, key string, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", "", err
+	}
+	id = strings.Trim(u.Path, "/")
+	if u.Scheme == "" || u.Host == "" || id == "" || strings.Contains(id, "/") {
+		return "", "", "", fmt.Errorf("whisperbin: not a secret link: %q", link)
+	}
+	return u.Scheme + "://" + u.Host, id, u.Fragment, nil
+}
+
+// ParseManageLink splits a sender's management link of the form
+// https://host/manage/{id}/{token}.
+func ParseManageLink(link string) (baseURL, id, token string, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Scheme == "" || u.Host == "" || len(parts) != 3 || parts[0] != "manage" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("whisperbin: not a management link: %q", link)
+	}
+	return u.Scheme + "://" + u.Host, parts[1], parts[2], nil
+}
+
+// EncryptOpaque encrypts plaintext for zero-knowledge mode the same way the
+// browser does: blob is base64url(nonce || AES-GCM ciphertext) and key must
+// be appended to the link as its fragment.
+func EncryptOpaque(plaintext []byte) (blob, key string, err error) {
+	rawKey := make([]byte, 32)
+	if _, err := rand.Read(rawKey); err != nil {
+		return "", "", err
+	}
+	aead, err := newGCM(rawKey)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), base64.RawURLEncoding.EncodeToString(rawKey), nil
+}
+
+// DecryptOpaque reverses EncryptOpaque using the key from the link fragment.
+func DecryptOpaque(blob, key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("whisperbin: link is missing its decryption key (#fragment)")
+	}
+	rawKey, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("whisperbin: invalid key in link: %w", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("whisperbin: ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
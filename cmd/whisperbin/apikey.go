@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"whisperbin/internal/apikey"
+)
+
+// runAPIKey generates a new API key. The token is printed once; only the
+// JSON entry with its hash belongs in API_KEYS_FILE.
+func runAPIKey(args []string) error {
+	fs := flag.NewFlagSet("apikey", flag.ExitOnError)
+	name := fs.String("name", "", "name of the key owner (required)")
+	maxTTL := fs.Int("max-ttl", 0, "maximum TTL in minutes (0 = instance default)")
+	maxSize := fs.Int("max-size", 0, "maximum secret size in bytes (0 = instance default)")
+	quota := fs.Int("quota", 0, "secrets per quota window (0 = unlimited)")
+	window := fs.String("quota-window", "", "quota window, e.g. 1h or 24h (default 1h)")
+	fs.Parse(args)
+
+	if *name == "" {
+		fs.Usage()
+		return fmt.Errorf("-name is required")
+	}
+
+	token, hash, err := apikey.Generate()
+	if err != nil {
+		return err
+	}
+	entry, err := json.MarshalIndent(apikey.Key{
+		Name:           *name,
+		Hash:           hash,
+		MaxTTLMinutes:  *maxTTL,
+		MaxSecretBytes: *maxSize,
+		Quota:          *quota,
+		QuotaWindow:    *window,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "API key (shown only once):\n%s\n\nAdd this entry to API_KEYS_FILE:\n", token)
+	fmt.Println(string(entry))
+	return nil
+}
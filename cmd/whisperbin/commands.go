@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"whisperbin/client"
+)
+
+const defaultServerURL = "http://localhost:8080"
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  whisperbin [serve]                 start the server
+  whisperbin send [flags] [file]     create a secret from file or stdin
+  whisperbin get [flags] <link>      reveal a secret
+  whisperbin confirm <link> <code>   approve a secure-mode recipient
+  whisperbin status [-revoke] <manage link>
+                                     show whether a secret was read, or revoke it
+  whisperbin split -shares N -threshold M [file]
+                                     split a secret into N links, any M of which recover it
+  whisperbin combine <link>...       recover a split secret from its share links
+  whisperbin apikey -name <name>     generate an API key
+  whisperbin rekey [-report]         re-encrypt stored secrets under the current master key
+  whisperbin keygen [-wrap] [-in file]
+                                     generate a master key, or seal a key file with a passphrase
+`)
+}
+
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	server := fs.String("server", envOr("WHISPERBIN_URL", defaultServerURL), "server URL")
+	apiKey := fs.String("api-key", os.Getenv("WHISPERBIN_API_KEY"), "API key for creating secrets")
+	ttl := fs.Int("ttl", 0, "time to live in minutes (default: server default)")
+	secure := fs.Bool("secure", false, "require manual approval with a passcode")
+	views := fs.Int("views", 1, "number of times the secret can be revealed")
+	passphrase := fs.String("passphrase", os.Getenv("WHISPERBIN_PASSPHRASE"), "passphrase the recipient must enter")
+	burnAfter := fs.Int("burn-after", 0, "destroy the secret after this many wrong passphrases")
+	notifyEmail := fs.String("notify", "", "email address to notify when the secret is read")
+	networks := fs.String("networks", "", "comma-separated IPs or CIDRs the secret can only be revealed from")
+	zk := fs.Bool("zero-knowledge", false, "encrypt locally; the key is only kept in the link fragment")
+	fs.Parse(args)
+
+	data, err := readSecret(fs)
+	if err != nil {
+		return err
+	}
+
+	text := string(data)
+	opts := client.CreateOptions{
+		TTLMinutes: *ttl,
+		Secure:     *secure,
+		Views:      *views,
+		Passphrase: *passphrase,
+		BurnAfter:  *burnAfter,
+
+		NotifyEmail: *notifyEmail,
+	}
+	if *networks != "" {
+		opts.AllowedNetworks = strings.Split(*networks, ",")
+	}
+	var key string
+	if *zk {
+		text, key, err = client.EncryptOpaque([]byte(strings.TrimSpace(text)))
+		if err != nil {
+			return err
+		}
+		opts.Opaque = true
+	}
+
+	created, err := client.New(*server, client.WithAPIKey(*apiKey)).Create(context.Background(), text, opts)
+	if err != nil {
+		return err
+	}
+
+	link := created.Link
+	if key != "" {
+		link += "#" + key
+	}
+	fmt.Println(link)
+	fmt.Fprintf(os.Stderr, "Manage: %s\n", created.ManageLink)
+	if created.Passcode != "" {
+		fmt.Printf("Passcode: %s\n", created.Passcode)
+		fmt.Fprintf(os.Stderr, "Approve the recipient with: whisperbin confirm %s <code from recipient>\n", created.Link)
+	}
+	return nil
+}
+
+func runGet(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	output := fs.String("o", "", "write the secret to this file instead of stdout")
+	passphrase := fs.String("passphrase", os.Getenv("WHISPERBIN_PASSPHRASE"), "passphrase of a protected secret")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: whisperbin get <link>")
+	}
+
+	baseURL, id, key, err := client.ParseLink(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := client.New(baseURL)
+	status, err := c.Status(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var text string
+	if status.Unlocked {
+		reveal := c.Reveal
+		if status.Passphrase {
+			if *passphrase == "" {
+				return errors.New("secret is passphrase-protected; use -passphrase or WHISPERBIN_PASSPHRASE")
+			}
+			reveal = func(ctx context.Context, id string) (*client.Secret, error) {
+				return c.RevealWithPassphrase(ctx, id, *passphrase)
+			}
+		}
+		secret, err := reveal(ctx, id)
+		if err != nil {
+			return err
+		}
+		text = secret.Text
+		if secret.ViewsRemaining > 0 {
+			fmt.Fprintf(os.Stderr, "%d views remaining\n", secret.ViewsRemaining)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Your passcode: %s\nWaiting for sender to unlock…\n", status.Passcode)
+		if text, err = c.WaitForUnlock(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	if status.Opaque {
+		plain, err := client.DecryptOpaque(text, key)
+		if err != nil {
+			return err
+		}
+		text = string(plain)
+	}
+
+	if *output != "" {
+		return os.WriteFile(*output, []byte(text), 0o600)
+	}
+	if status.FileName != "" {
+		fmt.Fprintf(os.Stderr, "Received file %s (%s)\n", status.FileName, status.ContentType)
+		_, err = io.WriteString(os.Stdout, text)
+		return err
+	}
+	fmt.Println(text)
+	return nil
+}
+
+func runConfirm(args []string) error {
+	fs := flag.NewFlagSet("confirm", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("usage: whisperbin confirm <link> <code>")
+	}
+
+	baseURL, id, _, err := client.ParseLink(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := client.New(baseURL).Confirm(context.Background(), id, fs.Arg(1)); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Secret unlocked. Recipient can now view the secret.")
+	return nil
+}
+
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	revoke := fs.Bool("revoke", false, "revoke the secret if it has not been read")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: whisperbin status [-revoke] <manage link>")
+	}
+
+	baseURL, id, token, err := client.ParseManageLink(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c := client.New(baseURL)
+	ctx := context.Background()
+	if *revoke {
+		if err := c.Revoke(ctx, id, token); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Secret revoked.")
+	}
+
+	receipt, err := c.Receipt(ctx, id, token)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Status:  %s\n", receipt.Status)
+	fmt.Printf("Expires: %s\n", receipt.ExpiresAt.Local().Format(time.RFC1123))
+	for _, at := range receipt.ViewedAt {
+		fmt.Printf("Viewed:  %s\n", at.Local().Format(time.RFC1123))
+	}
+	if receipt.RevokedAt != nil {
+		fmt.Printf("Revoked: %s\n", receipt.RevokedAt.Local().Format(time.RFC1123))
+	}
+	return nil
+}
+
+func runSplit(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	server := fs.String("server", envOr("WHISPERBIN_URL", defaultServerURL), "server URL")
+	apiKey := fs.String("api-key", os.Getenv("WHISPERBIN_API_KEY"), "API key for creating secrets")
+	ttl := fs.Int("ttl", 0, "time to live in minutes (default: server default)")
+	shares := fs.Int("shares", 0, "number of share links to create")
+	threshold := fs.Int("threshold", 0, "number of shares needed to recover the secret")
+	fs.Parse(args)
+
+	data, err := readSecret(fs)
+	if err != nil {
+		return err
+	}
+	split, err := client.New(*server, client.WithAPIKey(*apiKey)).Split(context.Background(), string(data), client.SplitOptions{
+		TTLMinutes: *ttl,
+		Shares:     *shares,
+		Threshold:  *threshold,
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, share := range split.Shares {
+		fmt.Println(share.Link)
+		fmt.Fprintf(os.Stderr, "Manage share %d: %s\n", i+1, share.ManageLink)
+	}
+	fmt.Fprintf(os.Stderr, "Any %d of these links recover the secret with: whisperbin combine <link>...\n", split.Threshold)
+	return nil
+}
+
+func runCombine(args []string) error {
+	fs := flag.NewFlagSet("combine", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return errors.New("usage: whisperbin combine <link> <link>...")
+	}
+
+	baseURL, _, _, err := client.ParseLink(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	text, err := client.New(baseURL).Combine(context.Background(), fs.Args())
+	if err != nil {
+		return err
+	}
+	fmt.Println(text)
+	return nil
+}
+
+// readSecret reads the secret from the file named by the only argument,
+// or from stdin.
+func readSecret(fs *flag.FlagSet) ([]byte, error) {
+	var in io.Reader = os.Stdin
+	if fs.NArg() > 1 {
+		return nil, fmt.Errorf("%s takes at most one file", fs.Name())
+	}
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, errors.New("secret is empty")
+	}
+	return data, nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
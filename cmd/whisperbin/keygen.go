@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"whisperbin/internal/storage"
+)
+
+// runKeygen prints a new master key in the KEY_FILE format, or with -wrap
+// seals a key file under a passphrase for WRAPPED_KEY_FILE.
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	wrap := fs.Bool("wrap", false, "seal the key file with a passphrase")
+	in := fs.String("in", "", "existing key file to seal with -wrap (default: a new key)")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	fs.Parse(args)
+
+	if *in != "" && !*wrap {
+		return errors.New("-in only makes sense with -wrap")
+	}
+
+	var keyFile []byte
+	if *in != "" {
+		data, err := os.ReadFile(*in)
+		if err != nil {
+			return err
+		}
+		keyFile = data
+	} else {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		id := storage.KeyID(key)
+		keyFile = fmt.Appendf(nil, "# whisperbin master key %s\n%s\n", id, base64.StdEncoding.EncodeToString(key))
+		clear(key)
+	}
+	defer clear(keyFile)
+
+	data := keyFile
+	if *wrap {
+		passphrase, err := keyPassphrase(true)
+		if err != nil {
+			return err
+		}
+		if data, err = storage.SealKeyFile(keyFile, passphrase); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+
+	if *out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	// O_EXCL: never overwrite a key that may still be needed.
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// keyPassphrase reads the passphrase of WRAPPED_KEY_FILE from
+// KEY_PASSPHRASE_FILE, or prompts for it on the terminal. With confirm it
+// is asked for twice.
+func keyPassphrase(confirm bool) (string, error) {
+	if path := os.Getenv("KEY_PASSPHRASE_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		defer clear(data)
+		passphrase := strings.TrimRight(string(data), "\r\n")
+		if passphrase == "" {
+			return "", errors.New("KEY_PASSPHRASE_FILE is empty")
+		}
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no terminal to ask for the key passphrase; set KEY_PASSPHRASE_FILE")
+	}
+	fmt.Fprint(os.Stderr, "Key passphrase: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	defer clear(first)
+	if len(first) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		defer clear(second)
+		if !bytes.Equal(first, second) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(first), nil
+}
@@ -1,17 +1,75 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"runtime"
 	"time"
 
 	"whisperbin/internal"
+	"whisperbin/internal/notify"
+	"whisperbin/internal/secmem"
 	"whisperbin/internal/storage"
+	"whisperbin/internal/vault"
 	"whisperbin/internal/web"
 )
 
 func main() {
-	store := storage.NewStore()
+	// Keys and revealed secrets must not end up in a core dump. Only
+	// Linux supports this, so other platforms skip it silently.
+	if err := secmem.DisableCoreDumps(); err != nil && runtime.GOOS == "linux" {
+		log.Printf("Warning: could not disable core dumps: %v", err)
+	}
+	if os.Getenv("LOCK_MEMORY") == "true" {
+		if err := secmem.Enable(); err != nil {
+			log.Fatalf("Could not lock memory: %v", err)
+		}
+	}
+
+	if len(os.Args) < 2 || os.Args[1] == "serve" {
+		serve()
+		return
+	}
+
+	commands := map[string]func([]string) error{
+		"send":    runSend,
+		"get":     runGet,
+		"confirm": runConfirm,
+		"status":  runStatus,
+		"split":   runSplit,
+		"combine": runCombine,
+		"apikey":  runAPIKey,
+		"rekey":   runRekey,
+		"keygen":  runKeygen,
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "whisperbin %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func serve() {
+	keys, err := newKeyProvider()
+	if err != nil {
+		log.Fatalf("Could not load the encryption key: %v", err)
+	}
+	backend, err := newBackend()
+	if err != nil {
+		log.Fatalf("Could not open storage: %v", err)
+	}
+	store := storage.NewStoreWithKeys(backend, keys)
+	if err := subscribeNotifiers(context.Background(), store); err != nil {
+		log.Fatalf("Could not configure notifications: %v", err)
+	}
 	handler := web.NewHandler(store)
 
 	go func() {
@@ -32,3 +90,113 @@ func main() {
 	log.Println("Server running at http://localhost:8080")
 	log.Fatal(srv.ListenAndServe())
 }
+
+func newBackend() (storage.Backend, error) {
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		if !persistentKey() {
+			return nil, errors.New("REDIS_URL requires a master key shared by all replicas (SECRET_KEY, KEY_FILE, WRAPPED_KEY_FILE or VAULT_ADDR)")
+		}
+		return storage.NewRedisBackend(redisURL)
+	}
+	if path := os.Getenv("DATA_FILE"); path != "" {
+		if !persistentKey() {
+			return nil, errors.New("DATA_FILE requires a persistent master key (SECRET_KEY, KEY_FILE, WRAPPED_KEY_FILE or VAULT_ADDR), otherwise stored secrets cannot be decrypted after a restart")
+		}
+		return storage.NewFileBackend(path)
+	}
+	return storage.NewMemoryBackend(), nil
+}
+
+var keySources = []string{"SECRET_KEY", "KEY_FILE", "WRAPPED_KEY_FILE", "VAULT_ADDR"}
+
+func persistentKey() bool {
+	for _, name := range keySources {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// newKeyProvider loads the master key from the one configured source, or
+// generates a random key that only lives as long as the process.
+func newKeyProvider() (storage.KeyProvider, error) {
+	var set []string
+	for _, name := range keySources {
+		if os.Getenv(name) != "" {
+			set = append(set, name)
+		}
+	}
+	if len(set) > 1 {
+		return nil, fmt.Errorf("only one of %v may be set", set)
+	}
+
+	switch {
+	case os.Getenv("VAULT_ADDR") != "":
+		transit, err := vault.NewTransit(vault.Config{
+			Addr:      os.Getenv("VAULT_ADDR"),
+			Token:     os.Getenv("VAULT_TOKEN"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Mount:     os.Getenv("VAULT_TRANSIT_MOUNT"),
+			Key:       envOr("VAULT_TRANSIT_KEY", "whisperbin"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Fail at startup rather than on the first secret.
+		if _, err := transit.CurrentKeyID(); err != nil {
+			return nil, err
+		}
+		return transit, nil
+	case os.Getenv("KEY_FILE") != "":
+		return storage.LoadKeyFile(os.Getenv("KEY_FILE"))
+	case os.Getenv("WRAPPED_KEY_FILE") != "":
+		passphrase, err := keyPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		return storage.LoadWrappedKeyFile(os.Getenv("WRAPPED_KEY_FILE"), passphrase)
+	case os.Getenv("SECRET_KEY") != "":
+		return storage.ParseKeyring(os.Getenv("SECRET_KEY"), os.Getenv("SECRET_KEYS_PREVIOUS"))
+	}
+	if os.Getenv("SECRET_KEYS_PREVIOUS") != "" {
+		return nil, errors.New("SECRET_KEYS_PREVIOUS requires SECRET_KEY")
+	}
+	return storage.RandomKeyring()
+}
+
+// subscribeNotifiers attaches the optional outbound notifiers to the
+// store's event bus and starts their delivery loops.
+func subscribeNotifiers(ctx context.Context, store *storage.Store) error {
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		secret := os.Getenv("WEBHOOK_SECRET")
+		if secret == "" {
+			return errors.New("WEBHOOK_URL requires WEBHOOK_SECRET to sign deliveries")
+		}
+		hook := notify.NewWebhook(url, []byte(secret))
+		store.Events().Subscribe(hook.Notify)
+		go hook.Run(ctx)
+	}
+	if url := os.Getenv("CHAT_WEBHOOK_URL"); url != "" {
+		chat := notify.NewChatWebhook(url)
+		store.Events().Subscribe(chat.Notify)
+		go chat.Run(ctx)
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			return errors.New("SMTP_ADDR requires SMTP_FROM")
+		}
+		var opts []notify.MailerOption
+		if user := os.Getenv("SMTP_USERNAME"); user != "" {
+			opts = append(opts, notify.WithAuth(user, os.Getenv("SMTP_PASSWORD")))
+		}
+		if os.Getenv("NOTIFY_ON_EXPIRY") == "true" {
+			opts = append(opts, notify.WithExpiryNotices())
+		}
+		mailer := notify.NewMailer(addr, from, store.NotifyEmail, opts...)
+		store.Events().Subscribe(mailer.Notify)
+		go mailer.Run(ctx)
+	}
+	return nil
+}
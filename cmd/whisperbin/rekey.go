@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+// runRekey re-encrypts stored secrets under the current master key, or
+// with -report only lists which keys they are still encrypted under. It
+// opens the same storage as the server. DATA_FILE has a single writer, so
+// rekeying it fails while the server runs; -report only reads a snapshot
+// of it and works either way.
+func runRekey(args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	report := fs.Bool("report", false, "only report secrets on retired keys; change nothing")
+	fs.Parse(args)
+
+	if os.Getenv("REDIS_URL") == "" && os.Getenv("DATA_FILE") == "" {
+		return errors.New("needs REDIS_URL or DATA_FILE; in-memory secrets are lost on restart anyway")
+	}
+	keys, err := newKeyProvider()
+	if err != nil {
+		return err
+	}
+	var backend storage.Backend
+	if path := os.Getenv("DATA_FILE"); *report && path != "" && os.Getenv("REDIS_URL") == "" {
+		backend, err = storage.LoadFileSnapshot(path)
+	} else {
+		backend, err = newBackend()
+	}
+	if errors.Is(err, storage.ErrFileLocked) {
+		return errors.New("DATA_FILE is in use; stop the server before rekeying, or use -report")
+	}
+	if err != nil {
+		return err
+	}
+	if c, ok := backend.(io.Closer); ok {
+		defer c.Close()
+	}
+	store := storage.NewStoreWithKeys(backend, keys)
+
+	if !*report {
+		n, err := store.Rekey()
+		fmt.Fprintf(os.Stderr, "Resealed %d secrets under the current key.\n", n)
+		if err != nil {
+			return err
+		}
+	}
+
+	usage, err := store.KeyUsage()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY ID\tSTATUS\tSECRETS\tPASSPHRASE\tLAST EXPIRY")
+	for _, u := range usage {
+		id, status, expiry := u.KeyID, "retired", "-"
+		if id == "" {
+			id = "(none)"
+		}
+		if u.Current {
+			status = "current"
+		}
+		if !u.LastExpiry.IsZero() {
+			expiry = u.LastExpiry.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", id, status, u.Secrets, u.Passphrase, expiry)
+	}
+	return w.Flush()
+}
@@ -2,4 +2,9 @@ module whisperbin
 
 go 1.24.3
 
-require golang.org/x/time v0.12.0
+require (
+	golang.org/x/crypto v0.40.0
+	golang.org/x/sys v0.34.0
+	golang.org/x/term v0.33.0
+	golang.org/x/time v0.12.0
+)
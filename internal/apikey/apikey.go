@@ -0,0 +1,117 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	tokenPrefix        = "wb_"
+	defaultQuotaWindow = time.Hour
+)
+
+// Key describes one API key. Only the SHA-256 hash of the token is kept;
+// zero limits fall back to the instance defaults.
+type Key struct {
+	Name           string `json:"name"`
+	Hash           string `json:"hash"`
+	MaxTTLMinutes  int    `json:"max_ttl_minutes,omitempty"`
+	MaxSecretBytes int    `json:"max_secret_bytes,omitempty"`
+	Quota          int    `json:"quota,omitempty"`
+	QuotaWindow    string `json:"quota_window,omitempty"`
+}
+
+type Keyring struct {
+	keys map[string]*Key
+	// limiters is only written by Parse; rate.Limiter is safe for
+	// concurrent use.
+	limiters map[string]*rate.Limiter
+}
+
+// Parse reads a JSON array of keys.
+func Parse(data []byte) (*Keyring, error) {
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("apikey: %w", err)
+	}
+
+	k := &Keyring{
+		keys:     make(map[string]*Key, len(keys)),
+		limiters: make(map[string]*rate.Limiter),
+	}
+	for _, key := range keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("apikey: key without name")
+		}
+		if raw, err := hex.DecodeString(key.Hash); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("apikey: key %q: hash must be hex-encoded SHA-256", key.Name)
+		}
+		if _, dup := k.keys[key.Hash]; dup {
+			return nil, fmt.Errorf("apikey: key %q: duplicate hash", key.Name)
+		}
+		if key.Quota > 0 {
+			window := defaultQuotaWindow
+			if key.QuotaWindow != "" {
+				d, err := time.ParseDuration(key.QuotaWindow)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("apikey: key %q: invalid quota_window %q", key.Name, key.QuotaWindow)
+				}
+				window = d
+			}
+			k.limiters[key.Hash] = rate.NewLimiter(rate.Every(window/time.Duration(key.Quota)), key.Quota)
+		}
+		k.keys[key.Hash] = key
+	}
+	return k, nil
+}
+
+func LoadFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Lookup returns the key matching token. Tokens are compared by their hash,
+// so lookups do not leak timing information about stored tokens.
+func (k *Keyring) Lookup(token string) (*Key, bool) {
+	key, ok := k.keys[Hash(token)]
+	return key, ok
+}
+
+// Allow consumes one creation from the key's quota.
+func (k *Keyring) Allow(key *Key) bool {
+	limiter := k.limiters[key.Hash]
+	if limiter == nil {
+		return true
+	}
+	return limiter.Allow()
+}
+
+func (k *Keyring) Len() int {
+	return len(k.keys)
+}
+
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate returns a new random token and the hash to store for it.
+func Generate() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
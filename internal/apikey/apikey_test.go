@@ -0,0 +1,61 @@
+package apikey
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeyring_LookupByHash(t *testing.T) {
+	token, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring, err := Parse([]byte(fmt.Sprintf(`[{"name": "ci", "hash": %q, "max_ttl_minutes": 60}]`, hash)))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	key, ok := ring.Lookup(token)
+	if !ok || key.Name != "ci" || key.MaxTTLMinutes != 60 {
+		t.Fatalf("Expected key ci, got %+v, %t", key, ok)
+	}
+	if _, ok := ring.Lookup(hash); ok {
+		t.Error("The stored hash must not be usable as a token")
+	}
+	if _, ok := ring.Lookup("wb_unknown"); ok {
+		t.Error("Expected unknown token to be rejected")
+	}
+}
+
+func TestKeyring_Quota(t *testing.T) {
+	token, hash, _ := Generate()
+	ring, err := Parse([]byte(fmt.Sprintf(`[{"name": "ci", "hash": %q, "quota": 2, "quota_window": "24h"}]`, hash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := ring.Lookup(token)
+	if !ring.Allow(key) || !ring.Allow(key) {
+		t.Fatal("Expected two creations within quota")
+	}
+	if ring.Allow(key) {
+		t.Error("Expected third creation to exceed quota")
+	}
+}
+
+func TestParse_RejectsInvalidKeys(t *testing.T) {
+	_, hash, _ := Generate()
+	cases := []string{
+		`not json`,
+		`[{"hash": "` + hash + `"}]`,
+		`[{"name": "plain", "hash": "wb_not_a_hash"}]`,
+		`[{"name": "a", "hash": "` + hash + `"}, {"name": "b", "hash": "` + hash + `"}]`,
+		`[{"name": "a", "hash": "` + hash + `", "quota": 1, "quota_window": "soon"}]`,
+	}
+	for _, c := range cases {
+		if _, err := Parse([]byte(c)); err == nil {
+			t.Errorf("Expected error for %s", c)
+		}
+	}
+}
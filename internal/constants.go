@@ -7,11 +7,28 @@ const (
 	MinTTLMinutes     = 1
 	MaxTTLMinutes     = 1440
 
+	MaxViews = 100
+
+	MaxPassphraseBytes = 1024
+
+	MaxRequestNoteBytes = 500
+
+	MaxShares = 16
+
+	MaxEmailBytes = 254
+	MaxRecipients = 20
+	MaxCIDRs      = 20
+
+	MaxSecretBytes      = 10240
+	DefaultMaxFileBytes = 1 << 20
+
 	MaxCodeFailures = 5
 	BlockDuration   = time.Minute
 
 	CleanupInterval = 5 * time.Minute
 
+	ReceiptRetention = 7 * 24 * time.Hour
+
 	RateLimiterRate  = 5
 	RateLimiterBurst = 10
 )
@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"whisperbin/internal/storage"
+)
+
+// NewChatWebhook posts to a Slack or Mattermost incoming webhook whenever
+// a secure-mode recipient is waiting. The message names the secret by its
+// Ref only, since anyone in the channel could use its ID to reveal it; the
+// sender enters the recipient's passcode on the secret's management page,
+// which shows the same Ref. Incoming webhooks authenticate by their URL,
+// so deliveries are not signed.
+func NewChatWebhook(url string, opts ...WebhookOption) *Webhook {
+	w := NewWebhook(url, nil, opts...)
+	w.format = func(e storage.Event) []byte {
+		if e.Type != storage.EventWaiting {
+			return nil
+		}
+		body, _ := json.Marshal(struct {
+			Text string `json:"text"`
+		}{fmt.Sprintf(":lock: A recipient is waiting for secret `%s`. Open its management link and enter the passcode they see to unlock it.", e.Ref())})
+		return body
+	}
+	return w
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+func TestChatWebhook_PostsRefWhenRecipientWaits(t *testing.T) {
+	srv, ch := newReceiver(t, func(int) int { return http.StatusOK })
+	store := storage.NewStore()
+	chat := NewChatWebhook(srv.URL)
+	store.Events().Subscribe(chat.Notify)
+	runWebhook(t, chat)
+
+	id, _, err := store.Save("secure value", 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _, _ := store.Save("plain value", 5, false)
+	store.Take(plain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	go store.WaitForUnlock(ctx, id)
+
+	got := waitFor(t, ch)
+	var msg struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(got.body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Text, storage.Ref(id)) {
+		t.Errorf("Expected the secret's ref in message, got %q", msg.Text)
+	}
+	if strings.Contains(msg.Text, id) {
+		t.Errorf("Message leaks the secret's ID: %q", msg.Text)
+	}
+	if strings.Contains(msg.Text, "secure value") {
+		t.Errorf("Message leaks the secret: %q", msg.Text)
+	}
+	if got.signature != "" {
+		t.Error("Expected chat deliveries to be unsigned")
+	}
+
+	select {
+	case r := <-ch:
+		t.Errorf("Expected only the waiting event to be posted, got %s", r.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+// smtpTimeout bounds connecting to the relay and delivering one message,
+// so a stalled relay cannot hold up the queue for good.
+const smtpTimeout = 30 * time.Second
+
+// AddressFunc decrypts the notification address stored on a secret.
+// Store.NotifyEmail satisfies it.
+type AddressFunc func(*storage.Secret) (string, error)
+
+// message is a queued notice. Its recipient is still encrypted on secret
+// and only resolved by Run, since that may call out to Vault.
+type message struct {
+	secret  *storage.Secret
+	subject string
+	body    string
+}
+
+// Mailer emails the sender through an SMTP relay when their secret is
+// read for the last time, and optionally when it expires unread. Like
+// Webhook, Notify only queues; Run decrypts the address and sends.
+type Mailer struct {
+	addr     string
+	from     string
+	auth     smtp.Auth
+	address  AddressFunc
+	onExpiry bool
+	timeout  time.Duration
+	queue    chan message
+	dropped  atomic.Int64
+}
+
+type MailerOption func(*Mailer)
+
+// WithAuth logs in to the relay with PLAIN auth, which net/smtp only
+// allows over TLS or to localhost.
+func WithAuth(username, password string) MailerOption {
+	return func(m *Mailer) {
+		host, _, _ := net.SplitHostPort(m.addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+}
+
+// WithExpiryNotices also notifies senders whose secret expired unread.
+func WithExpiryNotices() MailerOption {
+	return func(m *Mailer) { m.onExpiry = true }
+}
+
+func WithMailQueueSize(n int) MailerOption {
+	return func(m *Mailer) { m.queue = make(chan message, n) }
+}
+
+// NewMailer sends from the given address through the relay at addr
+// (host:port).
+func NewMailer(addr, from string, address AddressFunc, opts ...MailerOption) *Mailer {
+	m := &Mailer{
+		addr:    addr,
+		from:    from,
+		address: address,
+		timeout: smtpTimeout,
+		queue:   make(chan message, defaultQueueSize),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Notify is a storage.Listener.
+func (m *Mailer) Notify(e storage.Event) {
+	if e.Secret == nil || e.Secret.NotifyEmail == "" {
+		return
+	}
+
+	var msg message
+	when := e.Time.UTC().Format(time.RFC1123)
+	switch {
+	case e.Type == storage.EventRevealed && e.Secret.Views == 0:
+		msg.subject = "Your secret was read"
+		msg.body = fmt.Sprintf("A secret you shared with Whisperbin was read on %s and has been deleted.", when)
+	case e.Type == storage.EventExpired && m.onExpiry && e.Viewed > 0:
+		msg.subject = "Your secret expired"
+		msg.body = fmt.Sprintf("A secret you shared with Whisperbin was read %s and expired on %s with views left. It has been deleted.", times(e.Viewed), when)
+	case e.Type == storage.EventExpired && m.onExpiry:
+		msg.subject = "Your secret expired unread"
+		msg.body = fmt.Sprintf("A secret you shared with Whisperbin expired on %s without being read and has been deleted.", when)
+	default:
+		return
+	}
+
+	msg.secret = e.Secret
+	msg.body += "\r\n\r\nReference: " + e.Ref() + "\r\n"
+
+	select {
+	case m.queue <- msg:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+func times(n int) string {
+	if n == 1 {
+		return "once"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+// Dropped counts messages discarded because the queue was full.
+func (m *Mailer) Dropped() int64 {
+	return m.dropped.Load()
+}
+
+// Run sends queued messages until ctx is cancelled.
+func (m *Mailer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.queue:
+			m.send(msg)
+		}
+	}
+}
+
+func (m *Mailer) send(msg message) {
+	to, err := m.address(msg.secret)
+	if err != nil {
+		log.Printf("email: could not read the address for %q: %v", msg.subject, err)
+		return
+	}
+	if to == "" {
+		return
+	}
+	if err := m.sendMail(to, m.format(to, msg)); err != nil {
+		log.Printf("email: could not send %q: %v", msg.subject, err)
+	}
+}
+
+// sendMail is smtp.SendMail with a dial timeout and a deadline on the
+// whole exchange.
+func (m *Mailer) sendMail(to string, data []byte) error {
+	if strings.ContainsAny(m.from+to, "\r\n") {
+		return errors.New("smtp: address contains a line break")
+	}
+	conn, err := (&net.Dialer{Timeout: m.timeout}).Dial("tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(m.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *Mailer) format(to string, msg message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.body)
+	return []byte(b.String())
+}
@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+type mail struct {
+	from, to, data string
+}
+
+// fakeSMTP accepts just enough SMTP for net/smtp and reports
+// every message it receives.
+func fakeSMTP(t *testing.T) (string, chan mail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan mail, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func serveSMTP(conn net.Conn, ch chan mail) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake ESMTP")
+
+	var m mail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 fake")
+		case "MAIL":
+			m = mail{from: arg}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			m.to = arg
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.data = string(data)
+			ch <- m
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func waitForMail(t *testing.T, ch chan mail) mail {
+	t.Helper()
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for email")
+		return mail{}
+	}
+}
+
+func expectNoMail(t *testing.T, ch chan mail) {
+	t.Helper()
+	select {
+	case m := <-ch:
+		t.Errorf("Unexpected email: %q", m.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func newMailerStore(t *testing.T, opts ...MailerOption) (*storage.Store, chan mail) {
+	t.Helper()
+	addr, ch := fakeSMTP(t)
+	store := storage.NewStore()
+	mailer := NewMailer(addr, "whisperbin@example.com", store.NotifyEmail, opts...)
+	store.Events().Subscribe(mailer.Notify)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go mailer.Run(ctx)
+	return store, ch
+}
+
+func TestMailer_NotifiesWhenLastViewIsRead(t *testing.T) {
+	store, ch := newMailerStore(t)
+
+	res, err := store.SaveWithOptions("do not mail me", storage.SaveOptions{TTLMinutes: 5, Views: 2, NotifyEmail: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Take(res.ID)
+	expectNoMail(t, ch)
+
+	store.Take(res.ID)
+	m := waitForMail(t, ch)
+	if m.to != "TO:<alice@example.com>" || m.from != "FROM:<whisperbin@example.com>" {
+		t.Errorf("Unexpected envelope: %+v", m)
+	}
+	if !strings.Contains(m.data, "Subject: Your secret was read") {
+		t.Errorf("Unexpected message: %q", m.data)
+	}
+	if strings.Contains(m.data, "do not mail me") || strings.Contains(m.data, res.ID) {
+		t.Errorf("Email leaks the secret or its ID: %q", m.data)
+	}
+
+	plain, _, _ := store.Save("no email", 5, false)
+	store.Take(plain)
+	expectNoMail(t, ch)
+}
+
+func TestMailer_ExpiryNoticesAreOptional(t *testing.T) {
+	store, ch := newMailerStore(t)
+	store.SaveWithOptions("x", storage.SaveOptions{TTLMinutes: 0, NotifyEmail: "alice@example.com"})
+	time.Sleep(10 * time.Millisecond)
+	store.CleanupExpired()
+	expectNoMail(t, ch)
+
+	store, ch = newMailerStore(t, WithExpiryNotices())
+	store.SaveWithOptions("x", storage.SaveOptions{TTLMinutes: 0, NotifyEmail: "bob@example.com"})
+	time.Sleep(10 * time.Millisecond)
+	store.CleanupExpired()
+	m := waitForMail(t, ch)
+	if m.to != "TO:<bob@example.com>" || !strings.Contains(m.data, "Subject: Your secret expired unread") {
+		t.Errorf("Unexpected expiry email: %+v", m)
+	}
+}
+
+func TestMailer_ExpiryNoticeMentionsEarlierViews(t *testing.T) {
+	addr, ch := fakeSMTP(t)
+	store := storage.NewStore()
+	mailer := NewMailer(addr, "whisperbin@example.com", store.NotifyEmail, WithExpiryNotices())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mailer.Run(ctx)
+
+	res, _ := store.SaveWithOptions("x", storage.SaveOptions{TTLMinutes: 5, Views: 3, NotifyEmail: "alice@example.com"})
+	sec, _ := store.Get(res.ID)
+	mailer.Notify(storage.Event{Type: storage.EventExpired, ID: res.ID, Secret: sec, Viewed: 2})
+
+	m := waitForMail(t, ch)
+	if strings.Contains(m.data, "unread") || !strings.Contains(m.data, "read 2 times") {
+		t.Errorf("Expected the notice to say the secret was read twice: %q", m.data)
+	}
+}
+
+func TestMailer_GivesUpOnStalledRelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Accept the connection but never send a greeting.
+			defer conn.Close()
+		}
+	}()
+
+	mailer := NewMailer(ln.Addr().String(), "whisperbin@example.com", nil)
+	mailer.timeout = 50 * time.Millisecond
+	done := make(chan error, 1)
+	go func() { done <- mailer.sendMail("alice@example.com", []byte("hi")) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error from a relay that never answers")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the delivery to time out")
+	}
+}
+
+func TestMailer_QueueIsBounded(t *testing.T) {
+	store := storage.NewStore()
+	var lookups atomic.Int64
+	address := func(sec *storage.Secret) (string, error) {
+		lookups.Add(1)
+		return store.NotifyEmail(sec)
+	}
+	mailer := NewMailer("127.0.0.1:0", "whisperbin@example.com", address, WithMailQueueSize(1))
+	store.Events().Subscribe(mailer.Notify)
+
+	for i := 0; i < 3; i++ {
+		res, _ := store.SaveWithOptions("x", storage.SaveOptions{TTLMinutes: 5, NotifyEmail: "alice@example.com"})
+		store.Take(res.ID)
+	}
+	if got := mailer.Dropped(); got != 2 {
+		t.Errorf("Expected 2 dropped messages, got %d", got)
+	}
+	if got := lookups.Load(); got != 0 {
+		t.Errorf("Expected Notify to leave the address to Run, got %d lookups", got)
+	}
+}
@@ -0,0 +1,187 @@
+// Package notify forwards storage lifecycle events to external systems.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+const (
+	defaultQueueSize = 256
+	defaultAttempts  = 5
+	defaultBackoff   = time.Second
+)
+
+// WebhookPayload is the JSON body of a webhook request. Secret is the
+// event's Ref, never the link ID.
+type WebhookPayload struct {
+	Event  storage.EventType `json:"event"`
+	Secret string            `json:"secret"`
+	Time   time.Time         `json:"time"`
+	IP     string            `json:"ip,omitempty"`
+}
+
+// Webhook POSTs events as JSON signed with HMAC-SHA256. Notify only
+// enqueues; Run delivers with retries. When the queue is full, new events
+// are dropped instead of slowing down the request that caused them.
+type Webhook struct {
+	url      string
+	secret   []byte
+	client   *http.Client
+	format   func(storage.Event) []byte
+	queue    chan []byte
+	attempts int
+	backoff  time.Duration
+	dropped  atomic.Int64
+}
+
+type WebhookOption func(*Webhook)
+
+func WithHTTPClient(hc *http.Client) WebhookOption {
+	return func(w *Webhook) { w.client = hc }
+}
+
+// WithRetries sets how often delivery is attempted and the delay before
+// the first retry, which doubles with every further attempt.
+func WithRetries(attempts int, backoff time.Duration) WebhookOption {
+	return func(w *Webhook) { w.attempts, w.backoff = attempts, backoff }
+}
+
+func WithQueueSize(n int) WebhookOption {
+	return func(w *Webhook) { w.queue = make(chan []byte, n) }
+}
+
+func NewWebhook(url string, secret []byte, opts ...WebhookOption) *Webhook {
+	w := &Webhook{
+		url:      url,
+		secret:   secret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		format:   formatPayload,
+		queue:    make(chan []byte, defaultQueueSize),
+		attempts: defaultAttempts,
+		backoff:  defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+func formatPayload(e storage.Event) []byte {
+	body, _ := json.Marshal(WebhookPayload{Event: e.Type, Secret: e.Ref(), Time: e.Time.UTC(), IP: e.IP})
+	return body
+}
+
+// Notify is a storage.Listener.
+func (w *Webhook) Notify(e storage.Event) {
+	body := w.format(e)
+	if body == nil {
+		return
+	}
+	select {
+	case w.queue <- body:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Dropped counts events discarded because the queue was full.
+func (w *Webhook) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-w.queue:
+			if err := w.deliver(ctx, body); err != nil {
+				log.Printf("webhook: giving up on delivery to %s: %v", w.host(), err)
+			}
+		}
+	}
+}
+
+func (w *Webhook) deliver(ctx context.Context, body []byte) error {
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one attempt and reports whether a failure is worth retrying.
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Whisperbin-Timestamp", timestamp)
+		req.Header.Set("X-Whisperbin-Signature", Sign(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("status %s", resp.Status)
+}
+
+// host names the receiver in logs without the path, which for incoming
+// chat webhooks is itself a credential.
+func (w *Webhook) host() string {
+	if u, err := url.Parse(w.url); err == nil {
+		return u.Host
+	}
+	return "webhook"
+}
+
+// Sign returns the X-Whisperbin-Signature value for a request body: the
+// hex HMAC-SHA256 of "timestamp.body" under the shared secret. Receivers
+// should recompute it and reject stale timestamps.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
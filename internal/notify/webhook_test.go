@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+type received struct {
+	body      []byte
+	timestamp string
+	signature string
+}
+
+func newReceiver(t *testing.T, status func(n int) int) (*httptest.Server, chan received) {
+	t.Helper()
+	ch := make(chan received, 16)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ch <- received{body, r.Header.Get("X-Whisperbin-Timestamp"), r.Header.Get("X-Whisperbin-Signature")}
+		w.WriteHeader(status(int(calls.Add(1))))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+func runWebhook(t *testing.T, w *Webhook) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx)
+}
+
+func waitFor(t *testing.T, ch chan received) received {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for webhook delivery")
+		return received{}
+	}
+}
+
+func TestWebhook_SignedPayloadWithoutID(t *testing.T) {
+	srv, ch := newReceiver(t, func(int) int { return http.StatusNoContent })
+	secret := []byte("shared-secret")
+	hook := NewWebhook(srv.URL, secret)
+	runWebhook(t, hook)
+
+	id := "k3J9x-secret-link-id"
+	hook.Notify(storage.Event{Type: storage.EventRevealed, ID: id, IP: "192.0.2.1", Time: time.Now()})
+	got := waitFor(t, ch)
+
+	if want := Sign(secret, got.timestamp, got.body); got.signature != want {
+		t.Errorf("Signature mismatch: got %q, want %q", got.signature, want)
+	}
+	if Sign([]byte("other"), got.timestamp, got.body) == got.signature {
+		t.Error("Signature does not depend on the secret")
+	}
+	if strings.Contains(string(got.body), id) {
+		t.Errorf("Payload leaks the secret ID: %s", got.body)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	e := storage.Event{ID: id}
+	if payload.Event != storage.EventRevealed || payload.Secret != e.Ref() || payload.IP != "192.0.2.1" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+}
+
+func TestWebhook_RetriesServerErrors(t *testing.T) {
+	srv, ch := newReceiver(t, func(n int) int {
+		if n < 3 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	hook := NewWebhook(srv.URL, []byte("s"), WithRetries(5, time.Millisecond))
+	runWebhook(t, hook)
+
+	hook.Notify(storage.Event{Type: storage.EventExpired, ID: "a"})
+	first := waitFor(t, ch)
+	waitFor(t, ch)
+	third := waitFor(t, ch)
+	if string(first.body) != string(third.body) {
+		t.Error("Expected retries to resend the same payload")
+	}
+
+	hook.Notify(storage.Event{Type: storage.EventCreated, ID: "b"})
+	if r := waitFor(t, ch); !strings.Contains(string(r.body), `"created"`) {
+		t.Errorf("Expected next event after successful retry, got %s", r.body)
+	}
+}
+
+func TestWebhook_DoesNotRetryClientErrors(t *testing.T) {
+	srv, ch := newReceiver(t, func(int) int { return http.StatusBadRequest })
+	hook := NewWebhook(srv.URL, []byte("s"), WithRetries(5, time.Millisecond))
+	runWebhook(t, hook)
+
+	hook.Notify(storage.Event{Type: storage.EventCreated, ID: "a"})
+	waitFor(t, ch)
+	select {
+	case <-ch:
+		t.Error("Expected a 400 response not to be retried")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhook_QueueIsBounded(t *testing.T) {
+	hook := NewWebhook("http://127.0.0.1:0", []byte("s"), WithQueueSize(2))
+
+	for i := 0; i < 5; i++ {
+		hook.Notify(storage.Event{Type: storage.EventCreated, ID: "a"})
+	}
+	if got := hook.Dropped(); got != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", got)
+	}
+}
@@ -0,0 +1,127 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"whisperbin/internal/oidc"
+	"whisperbin/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://whisperbin.test/auth/callback"
+
+func discover(t *testing.T, fake *oidctest.Provider) *oidc.Provider {
+	t.Helper()
+	p, err := oidc.Discover(context.Background(), fake.Config(redirectURL))
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	return p
+}
+
+// authorize follows the login redirect up to the callback and returns the
+// code and state it carries.
+func authorize(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect to callback, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestCodeFlowWithPKCE(t *testing.T) {
+	fake := oidctest.NewProvider()
+	defer fake.Close()
+	fake.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", Groups: []string{"ops"}})
+	p := discover(t, fake)
+
+	verifier, _ := oidc.RandomString()
+	code, state := authorize(t, p.AuthCodeURL("state-1", "nonce-1", verifier))
+	if state != "state-1" {
+		t.Errorf("Expected state to round-trip, got %q", state)
+	}
+
+	claims, err := p.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Subject != "alice" || claims.Email != "alice@example.com" || len(claims.Groups) != 1 || claims.Groups[0] != "ops" {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce-1"); err == nil {
+		t.Error("Expected a code to be redeemable only once")
+	}
+}
+
+func TestExchange_RejectsWrongVerifier(t *testing.T) {
+	fake := oidctest.NewProvider()
+	defer fake.Close()
+	p := discover(t, fake)
+
+	verifier, _ := oidc.RandomString()
+	code, _ := authorize(t, p.AuthCodeURL("s", "n", verifier))
+	if _, err := p.Exchange(context.Background(), code, "intercepted-without-verifier", "n"); err == nil {
+		t.Error("Expected exchange without the PKCE verifier to fail")
+	}
+}
+
+func TestVerify_RejectsBadTokens(t *testing.T) {
+	fake := oidctest.NewProvider()
+	defer fake.Close()
+	p := discover(t, fake)
+	user := oidctest.User{Subject: "alice"}
+
+	with := func(key string, value any) string {
+		claims := fake.Claims(user, "n")
+		claims[key] = value
+		return fake.Sign(claims)
+	}
+	valid := fake.Sign(fake.Claims(user, "n"))
+	if _, err := p.Verify(context.Background(), valid, "n"); err != nil {
+		t.Fatalf("Expected valid token to verify: %v", err)
+	}
+
+	parts := strings.Split(valid, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2]
+
+	for name, token := range map[string]string{
+		"wrong nonce":    fake.Sign(fake.Claims(user, "other")),
+		"wrong audience": with("aud", "someone-else"),
+		"wrong issuer":   with("iss", "https://evil.example"),
+		"expired":        with("exp", time.Now().Add(-time.Hour).Unix()),
+		"future iat":     with("iat", time.Now().Add(time.Hour).Unix()),
+		"no subject":     with("sub", ""),
+		"tampered":       tampered,
+		"alg none":       noneHeader + "." + parts[1] + ".",
+		"malformed":      "not-a-jwt",
+	} {
+		if _, err := p.Verify(context.Background(), token, "n"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestDiscover_RejectsIssuerMismatch(t *testing.T) {
+	fake := oidctest.NewProvider()
+	defer fake.Close()
+	cfg := fake.Config(redirectURL)
+	cfg.Issuer += "/"
+	if _, err := oidc.Discover(context.Background(), cfg); err == nil {
+		t.Error("Expected discovery to reject a different issuer")
+	}
+}
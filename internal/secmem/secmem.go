@@ -0,0 +1,79 @@
+// Package secmem holds key material and plaintext in buffers that are
+// wiped when they are freed. With Enable on Linux, buffers are mapped
+// outside the garbage-collected heap, locked into RAM so they are never
+// written to swap, and left out of core dumps.
+package secmem
+
+import "sync"
+
+var (
+	mu      sync.Mutex
+	enabled bool
+	// mapped holds the mapping of every locked buffer by its first byte.
+	mapped = make(map[*byte][]byte)
+)
+
+// Enable makes Alloc return locked buffers from now on. It fails if the
+// platform or RLIMIT_MEMLOCK does not allow locking memory.
+func Enable() error {
+	if err := probe(); err != nil {
+		return err
+	}
+	mu.Lock()
+	enabled = true
+	mu.Unlock()
+	return nil
+}
+
+// Locked reports whether Enable succeeded.
+func Locked() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Alloc returns a zeroed buffer of n bytes that must be released with
+// Free. Once RLIMIT_MEMLOCK is used up it falls back to the heap.
+func Alloc(n int) []byte {
+	if n > 0 && Locked() {
+		if b, err := lock(n); err == nil {
+			mu.Lock()
+			mapped[&b[0]] = b
+			mu.Unlock()
+			return b[:n:n]
+		}
+	}
+	return make([]byte, n)
+}
+
+// Clone copies b into a buffer from Alloc.
+func Clone(b []byte) []byte {
+	out := Alloc(len(b))
+	copy(out, b)
+	return out
+}
+
+// Free wipes b, which may be any buffer, and releases it if Alloc locked
+// it. b must start where the buffer from Alloc started, and must not be
+// used afterwards.
+func Free(b []byte) {
+	b = b[:cap(b)]
+	if len(b) == 0 {
+		return
+	}
+	clear(b)
+	mu.Lock()
+	m, ok := mapped[&b[0]]
+	delete(mapped, &b[0])
+	mu.Unlock()
+	if ok {
+		unlock(m)
+	}
+}
+
+// Wipe frees every buffer in bufs.
+func Wipe(bufs ...[]byte) {
+	for _, b := range bufs {
+		Free(b)
+	}
+}
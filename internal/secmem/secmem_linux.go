@@ -0,0 +1,49 @@
+package secmem
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock maps a private region of at least n bytes and locks it.
+func lock(n int) ([]byte, error) {
+	pageSize := os.Getpagesize()
+	size := (n + pageSize - 1) / pageSize * pageSize
+	b, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(b); err != nil {
+		unix.Munmap(b)
+		return nil, err
+	}
+	// Not fatal: core dumps are also switched off by DisableCoreDumps.
+	unix.Madvise(b, unix.MADV_DONTDUMP)
+	return b, nil
+}
+
+func unlock(b []byte) {
+	unix.Munlock(b)
+	unix.Munmap(b)
+}
+
+func probe() error {
+	b, err := lock(1)
+	if err != nil {
+		return fmt.Errorf("mlock: %w (raise RLIMIT_MEMLOCK or grant CAP_IPC_LOCK)", err)
+	}
+	unlock(b)
+	return nil
+}
+
+// DisableCoreDumps keeps a crash from writing memory, secrets included, to
+// disk, and stops other processes of the same user from attaching to this
+// one with ptrace.
+func DisableCoreDumps() error {
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{}); err != nil {
+		return err
+	}
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package secmem
+
+import "errors"
+
+var errUnsupported = errors.New("locking memory is only supported on Linux")
+
+func lock(int) ([]byte, error) {
+	return nil, errUnsupported
+}
+
+func unlock([]byte) {}
+
+func probe() error {
+	return errUnsupported
+}
+
+// DisableCoreDumps is only implemented on Linux.
+func DisableCoreDumps() error {
+	return errUnsupported
+}
@@ -0,0 +1,63 @@
+package secmem
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFree_ZeroesBuffer(t *testing.T) {
+	b := Alloc(32)
+	copy(b, "correct horse battery staple")
+	// Free wipes the whole buffer, even through a shorter slice of it.
+	Free(b[:4])
+	if !bytes.Equal(b, make([]byte, 32)) {
+		t.Errorf("Expected the buffer to be zeroed, got %q", b)
+	}
+
+	a, c := []byte("first"), []byte("second")
+	Wipe(a, c, nil)
+	if !bytes.Equal(a, make([]byte, 5)) || !bytes.Equal(c, make([]byte, 6)) {
+		t.Errorf("Expected Wipe to zero every buffer, got %q and %q", a, c)
+	}
+}
+
+func TestAlloc_LockedBuffers(t *testing.T) {
+	if err := Enable(); err != nil {
+		t.Skipf("Cannot lock memory here: %v", err)
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		enabled = false
+		mu.Unlock()
+	})
+
+	b := Alloc(100)
+	if len(b) != 100 || cap(b) != 100 {
+		t.Fatalf("Expected a 100-byte buffer, got len %d cap %d", len(b), cap(b))
+	}
+	mu.Lock()
+	_, ok := mapped[&b[0]]
+	mu.Unlock()
+	if !ok {
+		t.Fatal("Expected the buffer to be mapped outside the heap")
+	}
+
+	// A slice that does not start at the mapping is only wiped.
+	copy(b, bytes.Repeat([]byte("k"), 100))
+	Free(b[50:])
+	if !bytes.Equal(b[50:], make([]byte, 50)) || b[0] != 'k' {
+		t.Errorf("Expected only the tail to be wiped, got %q", b)
+	}
+
+	clone := Clone(b[:10])
+	if string(clone) != "kkkkkkkkkk" {
+		t.Errorf("Expected Clone to copy the buffer, got %q", clone)
+	}
+	Wipe(clone, b)
+	mu.Lock()
+	left := len(mapped)
+	mu.Unlock()
+	if left != 0 {
+		t.Errorf("Expected every mapping to be released, %d left", left)
+	}
+}
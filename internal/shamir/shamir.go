@@ -0,0 +1,120 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8).
+//
+// A share is the secret's length plus one byte: one polynomial value per
+// secret byte, followed by the share's x-coordinate.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	ErrInvalidParams = errors.New("shamir: need 2 <= threshold <= shares <= 255 and a non-empty secret")
+	ErrInvalidShares = errors.New("shamir: shares are malformed, duplicated or of different lengths")
+)
+
+// Split divides secret into n shares of which any threshold recover it.
+// Fewer than threshold shares reveal nothing about the secret.
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 || threshold < 2 || threshold > n || n > 255 {
+		return nil, ErrInvalidParams
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	coeffs := make([]byte, threshold-1)
+	for idx, b := range secret {
+		if _, err := rand.Read(coeffs); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i][idx] = evaluate(b, coeffs, byte(i+1))
+		}
+	}
+	wipe(coeffs)
+	return shares, nil
+}
+
+// Combine interpolates the secret from shares. It cannot tell whether
+// enough shares were given: with fewer than the threshold it returns
+// unrelated bytes, so callers need their own integrity check.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, ErrInvalidShares
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, ErrInvalidShares
+		}
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidShares
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, size-1)
+	for i, xi := range xs {
+		// Lagrange basis polynomial for share i, evaluated at zero.
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = mul(basis, div(xj, xi^xj))
+			}
+		}
+		for idx := range secret {
+			secret[idx] ^= mul(shares[i][idx], basis)
+		}
+	}
+	return secret, nil
+}
+
+// evaluate computes the polynomial with constant term intercept and the
+// given higher coefficients at x, using Horner's method.
+func evaluate(intercept byte, coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return mul(y, x) ^ intercept
+}
+
+// mul multiplies in GF(2^8) with the AES polynomial. It does not branch
+// on or index by its operands, so it runs in constant time.
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := a >> 7
+		a = (a << 1) ^ (0x1b & -carry)
+		b >>= 1
+	}
+	return p
+}
+
+// div returns a/b; b must not be zero. The inverse is b^254.
+func div(a, b byte) byte {
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = mul(mul(inv, inv), b)
+	}
+	return mul(a, mul(inv, inv))
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
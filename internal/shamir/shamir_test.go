@@ -0,0 +1,98 @@
+package shamir
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitCombine_AnyThresholdSubset(t *testing.T) {
+	secret := []byte("root:correct horse battery staple")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(shares))
+	}
+
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				got, err := Combine([][]byte{shares[c], shares[a], shares[b]})
+				if err != nil {
+					t.Fatalf("Combine(%d,%d,%d) failed: %v", a, b, c, err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Errorf("Combine(%d,%d,%d) = %q", a, b, c, got)
+				}
+			}
+		}
+	}
+
+	got, err := Combine(shares)
+	if err != nil || !bytes.Equal(got, secret) {
+		t.Errorf("Expected all shares to recover the secret, got %q, %v", got, err)
+	}
+}
+
+func TestCombine_FewerThanThresholdDoesNotRecover(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			got, err := Combine([][]byte{shares[a], shares[b]})
+			if err != nil {
+				t.Fatalf("Combine of two shares failed: %v", err)
+			}
+			if bytes.Equal(got, secret) {
+				t.Errorf("Two shares (%d,%d) of a 3-of-5 split recovered the secret", a, b)
+			}
+		}
+	}
+}
+
+func TestSplit_InvalidParams(t *testing.T) {
+	for _, tc := range []struct{ n, threshold int }{
+		{3, 1}, {2, 3}, {256, 2}, {0, 0},
+	} {
+		if _, err := Split([]byte("x"), tc.n, tc.threshold); !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("Split(n=%d, threshold=%d): expected ErrInvalidParams, got %v", tc.n, tc.threshold, err)
+		}
+	}
+	if _, err := Split(nil, 3, 2); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Expected empty secret to be rejected, got %v", err)
+	}
+}
+
+func TestCombine_RejectsMalformedShares(t *testing.T) {
+	shares, _ := Split([]byte("secret"), 3, 2)
+
+	for name, input := range map[string][][]byte{
+		"single":    {shares[0]},
+		"duplicate": {shares[0], shares[0]},
+		"length":    {shares[0], shares[1][1:]},
+		"zero x":    {shares[0], append(bytes.Clone(shares[1][:len(shares[1])-1]), 0)},
+	} {
+		if _, err := Combine(input); !errors.Is(err, ErrInvalidShares) {
+			t.Errorf("%s: expected ErrInvalidShares, got %v", name, err)
+		}
+	}
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		if mul(byte(a), 1) != byte(a) {
+			t.Fatalf("%d * 1 != %d", a, a)
+		}
+		for b := 1; b < 256; b++ {
+			if got := mul(div(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("(%d / %d) * %d = %d", a, b, b, got)
+			}
+		}
+	}
+}
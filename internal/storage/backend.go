@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound          = errors.New("not found or expired")
+	ErrBlocked           = errors.New("too many failed attempts, temporarily blocked")
+	ErrInvalidCode       = errors.New("invalid code")
+	ErrNoRecipient       = errors.New("no recipient waiting")
+	ErrAlreadyUnlocked   = errors.New("already unlocked")
+	ErrNotSecure         = errors.New("not secure mode")
+	ErrListenerConnected = errors.New("listener already connected")
+
+	ErrPassphraseRequired = errors.New("passphrase required")
+	ErrInvalidPassphrase  = errors.New("invalid passphrase")
+	ErrBurned             = errors.New("too many failed attempts, secret destroyed")
+	ErrInvalidToken       = errors.New("invalid management token")
+	ErrNotPending         = errors.New("secret was already read, revoked or has expired")
+	ErrNotEnoughShares    = errors.New("not enough shares to recover the secret")
+	ErrFileLocked         = errors.New("data file is in use by another process")
+	ErrBusy               = errors.New("too many passphrase operations in progress, try again later")
+	ErrInvalidShares      = errors.New("shares do not belong to the same secret")
+)
+
+// Backend persists encrypted secrets and tracks the secure-mode handshake.
+// Secrets handed to a backend are already encrypted; a backend never sees
+// plaintext or the encryption key.
+type Backend interface {
+	// Save stores sec under id. A secret that is not Unlocked is in secure
+	// mode and must be confirmed before WaitForUnlock returns it.
+	Save(id string, sec *Secret) error
+	Get(id string) (*Secret, error)
+	// Take atomically consumes one view of a secret and returns it with
+	// Views set to the reveals still left. The secret is deleted with its
+	// last view, so concurrent reveals can never exceed the view count.
+	Take(id string) (*Secret, error)
+	Delete(id string) error
+	Confirm(id, inputCode, ip string) error
+	// WaitForUnlock blocks until id is unlocked. Once the single listener
+	// slot is claimed it calls onWait, if set, with the secret, but only
+	// for the first listener the secret has had.
+	WaitForUnlock(ctx context.Context, id string, onWait func(*Secret)) (*Secret, error)
+	IsWaiting(id string) (bool, error)
+	// Fulfill replaces an Awaiting secret with the submitted one and wakes
+	// a WaitForUnlock on it, like Confirm does. It fails with ErrNotFound
+	// if id is gone or was already fulfilled.
+	Fulfill(id string, sec *Secret) error
+	// IDs lists the secrets that have not expired, drop records included.
+	IDs() ([]string, error)
+	// Reseal replaces the encrypted fields of id with those of sec and
+	// leaves views and the secure-mode state alone. It fails with
+	// ErrNotFound if id is gone, so a secret read meanwhile stays gone.
+	Reseal(id string, sec *Secret) error
+	// RecordFailure counts a failed passphrase attempt from ip, blocking
+	// ip like a wrong passcode does, and returns the number of failures
+	// for id across all addresses.
+	RecordFailure(id, ip string) (int, error)
+	Blocked(id, ip string) (bool, error)
+	ResetFailures(id, ip string) error
+	// SaveReceipt stores the sender's receipt for id. Receipts are kept
+	// after Take and Delete and only dropped once RetainUntil has passed.
+	SaveReceipt(id string, rec *Receipt) error
+	GetReceipt(id string) (*Receipt, error)
+	// MarkViewed appends a reveal time to the receipt of id, if it has one.
+	MarkViewed(id string, at time.Time) error
+	// CleanupExpired drops expired secrets and receipts and returns the
+	// secrets that expired unread since the last call, keyed by ID. Each
+	// secret is reported once, even with several replicas cleaning up; the
+	// record may be nil if it was already gone.
+	CleanupExpired() map[string]*Secret
+}
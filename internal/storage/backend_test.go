@@ -0,0 +1,525 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"whisperbin/internal"
+)
+
+// testBackendConformance is the behaviour every Backend implementation must
+// provide. Each subtest gets a fresh backend from newBackend.
+func testBackendConformance(t *testing.T, newBackend func(t *testing.T) Backend) {
+	t.Run("SaveAndGet", func(t *testing.T) {
+		b := newBackend(t)
+		if err := b.Save("plain", plainSecret(time.Minute)); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		got, err := b.Get("plain")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got.CipherText != "cipher" || string(got.Nonce) != "nonce" || !got.Unlocked {
+			t.Errorf("Unexpected secret: %+v", got)
+		}
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		b := newBackend(t)
+		if _, err := b.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("gone", plainSecret(time.Minute))
+		if err := b.Delete("gone"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := b.Get("gone"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+		}
+		if err := b.Delete("gone"); err != nil {
+			t.Errorf("Deleting a missing secret should not fail: %v", err)
+		}
+	})
+
+	t.Run("TakeOnce", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("once", plainSecret(time.Minute))
+		got, err := b.Take("once")
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if got.CipherText != "cipher" {
+			t.Errorf("Unexpected secret: %+v", got)
+		}
+		if _, err := b.Take("once"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected second Take to fail, got %v", err)
+		}
+		if _, err := b.Get("once"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after Take, got %v", err)
+		}
+	})
+
+	t.Run("TakeIsExclusive", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("race", plainSecret(time.Minute))
+
+		const readers = 8
+		wins := make(chan bool, readers)
+		for i := 0; i < readers; i++ {
+			go func() {
+				_, err := b.Take("race")
+				wins <- err == nil
+			}()
+		}
+		won := 0
+		for i := 0; i < readers; i++ {
+			if <-wins {
+				won++
+			}
+		}
+		if won != 1 {
+			t.Errorf("Expected exactly one Take to succeed, got %d", won)
+		}
+	})
+
+	t.Run("TakeCountsViews", func(t *testing.T) {
+		b := newBackend(t)
+		sec := plainSecret(time.Minute)
+		sec.Views = 3
+		b.Save("multi", sec)
+		before, _ := b.Get("multi")
+
+		for want := 2; want >= 0; want-- {
+			got, err := b.Take("multi")
+			if err != nil {
+				t.Fatalf("Take with %d views left failed: %v", want+1, err)
+			}
+			if got.Views != want || got.CipherText != "cipher" {
+				t.Errorf("Expected %d views left, got %d", want, got.Views)
+			}
+			if want > 0 {
+				if left, err := b.Get("multi"); err != nil || left.Views != want {
+					t.Errorf("Expected Get to report %d views, got %+v, %v", want, left, err)
+				}
+			}
+		}
+		if _, err := b.Take("multi"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound after last view, got %v", err)
+		}
+		if before.Views != 3 {
+			t.Errorf("Expected Take to leave a secret returned by Get unchanged, got %d views", before.Views)
+		}
+	})
+
+	t.Run("TakeViewsAreExclusive", func(t *testing.T) {
+		b := newBackend(t)
+		sec := plainSecret(time.Minute)
+		sec.Views = 3
+		b.Save("race", sec)
+
+		const readers = 8
+		wins := make(chan bool, readers)
+		for i := 0; i < readers; i++ {
+			go func() {
+				_, err := b.Take("race")
+				wins <- err == nil
+			}()
+		}
+		won := 0
+		for i := 0; i < readers; i++ {
+			if <-wins {
+				won++
+			}
+		}
+		if won != 3 {
+			t.Errorf("Expected exactly three Takes to succeed, got %d", won)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("old", plainSecret(-time.Second))
+		b.Save("fresh", plainSecret(time.Minute))
+		if _, err := b.Get("old"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected expired secret to be hidden, got %v", err)
+		}
+		if _, err := b.IsWaiting("old"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected IsWaiting to fail for expired secret, got %v", err)
+		}
+		b.CleanupExpired()
+		if _, err := b.Get("fresh"); err != nil {
+			t.Errorf("CleanupExpired removed a live secret: %v", err)
+		}
+	})
+
+	t.Run("IsWaiting", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("plain", plainSecret(time.Minute))
+		b.Save("secure", secureSecret(time.Minute))
+		if waiting, err := b.IsWaiting("plain"); err != nil || waiting {
+			t.Errorf("Expected plain secret not waiting, got %t, %v", waiting, err)
+		}
+		if waiting, err := b.IsWaiting("secure"); err != nil || !waiting {
+			t.Errorf("Expected secure secret waiting, got %t, %v", waiting, err)
+		}
+	})
+
+	t.Run("ConfirmWakesWaiter", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("secure", secureSecret(time.Minute))
+
+		result := make(chan *Secret, 1)
+		go func() {
+			sec, err := b.WaitForUnlock(context.Background(), "secure", nil)
+			if err != nil {
+				t.Errorf("WaitForUnlock failed: %v", err)
+			}
+			result <- sec
+		}()
+
+		confirmWhenListening(t, b, "secure", "123456")
+
+		select {
+		case sec := <-result:
+			if sec == nil || !sec.Unlocked || sec.CipherText != "cipher" {
+				t.Errorf("Unexpected unlocked secret: %+v", sec)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for unlock")
+		}
+
+		if waiting, err := b.IsWaiting("secure"); err != nil || waiting {
+			t.Errorf("Expected not waiting after unlock, got %t, %v", waiting, err)
+		}
+		if err := b.Confirm("secure", "123456", "10.0.0.1"); err == nil {
+			t.Error("Expected second Confirm to fail")
+		}
+		if sec, err := b.Take("secure"); err != nil || !sec.Unlocked {
+			t.Errorf("Expected unlocked secret from Take, got %+v, %v", sec, err)
+		}
+	})
+
+	t.Run("ConfirmWithoutListener", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("secure", secureSecret(time.Minute))
+		if err := b.Confirm("secure", "123456", "10.0.0.1"); !errors.Is(err, ErrNoRecipient) {
+			t.Errorf("Expected ErrNoRecipient, got %v", err)
+		}
+	})
+
+	t.Run("ConfirmBlocksAfterFailures", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("secure", secureSecret(time.Minute))
+		for i := 0; i < internal.MaxCodeFailures; i++ {
+			if err := b.Confirm("secure", "000000", "10.0.0.1"); !errors.Is(err, ErrInvalidCode) {
+				t.Fatalf("Attempt %d: expected ErrInvalidCode, got %v", i, err)
+			}
+		}
+		if err := b.Confirm("secure", "123456", "10.0.0.1"); !errors.Is(err, ErrBlocked) {
+			t.Errorf("Expected ErrBlocked, got %v", err)
+		}
+		if err := b.Confirm("secure", "000000", "10.0.0.2"); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Block should be per IP, got %v", err)
+		}
+	})
+
+	t.Run("RecordFailureBlocksAndCounts", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("locked", plainSecret(time.Minute))
+		for i := 1; i <= internal.MaxCodeFailures; i++ {
+			total, err := b.RecordFailure("locked", "10.0.0.1")
+			if err != nil || total != i {
+				t.Fatalf("Attempt %d: expected total %d, got %d, %v", i, i, total, err)
+			}
+		}
+		if blocked, err := b.Blocked("locked", "10.0.0.1"); err != nil || !blocked {
+			t.Errorf("Expected IP to be blocked, got %t, %v", blocked, err)
+		}
+		if blocked, _ := b.Blocked("locked", "10.0.0.2"); blocked {
+			t.Error("Block should be per IP")
+		}
+		if total, _ := b.RecordFailure("locked", "10.0.0.2"); total != internal.MaxCodeFailures+1 {
+			t.Errorf("Expected failures to be counted across IPs, got %d", total)
+		}
+		if err := b.ResetFailures("locked", "10.0.0.1"); err != nil {
+			t.Fatalf("ResetFailures failed: %v", err)
+		}
+		if blocked, _ := b.Blocked("locked", "10.0.0.1"); blocked {
+			t.Error("Expected ResetFailures to lift the block")
+		}
+		if _, err := b.RecordFailure("missing", "10.0.0.1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for missing secret, got %v", err)
+		}
+	})
+
+	t.Run("ReceiptOutlivesSecret", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("read", plainSecret(time.Minute))
+		b.SaveReceipt("read", &Receipt{TokenHash: "h", Views: 1, RetainUntil: time.Now().Add(time.Hour)})
+		b.SaveReceipt("old", &Receipt{TokenHash: "h", Views: 1, RetainUntil: time.Now().Add(-time.Second)})
+
+		if _, err := b.Take("read"); err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		viewedAt := time.Now().Truncate(time.Millisecond)
+		if err := b.MarkViewed("read", viewedAt); err != nil {
+			t.Fatalf("MarkViewed failed: %v", err)
+		}
+		rec, err := b.GetReceipt("read")
+		if err != nil {
+			t.Fatalf("Expected receipt after Take, got %v", err)
+		}
+		if rec.TokenHash != "h" || len(rec.ViewedAt) != 1 || !rec.ViewedAt[0].Equal(viewedAt) {
+			t.Errorf("Unexpected receipt %+v", rec)
+		}
+
+		if _, err := b.GetReceipt("old"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected receipt past retention to be gone, got %v", err)
+		}
+		if err := b.MarkViewed("missing", time.Now()); err != nil {
+			t.Errorf("MarkViewed without receipt should be a no-op, got %v", err)
+		}
+		b.CleanupExpired()
+		if _, err := b.GetReceipt("read"); err != nil {
+			t.Errorf("CleanupExpired removed a retained receipt: %v", err)
+		}
+	})
+
+	t.Run("CleanupReportsExpiredOnce", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("expiring", plainSecret(50*time.Millisecond))
+		b.Save("read", plainSecret(50*time.Millisecond))
+		b.Save("live", plainSecret(time.Minute))
+		b.Take("read")
+		time.Sleep(100 * time.Millisecond)
+
+		expired := b.CleanupExpired()
+		if len(expired) != 1 {
+			t.Fatalf("Expected exactly one expired secret, got %v", expired)
+		}
+		if sec, ok := expired["expiring"]; !ok || sec == nil || sec.CipherText != "cipher" {
+			t.Errorf("Expected the expired record to be reported, got %+v", sec)
+		}
+		if again := b.CleanupExpired(); len(again) != 0 {
+			t.Errorf("Expected expired secret to be reported once, got %v", again)
+		}
+		if _, err := b.Get("live"); err != nil {
+			t.Errorf("Expected live secret to stay: %v", err)
+		}
+	})
+
+	t.Run("FulfillWakesWaiter", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("requested", awaitingSecret(time.Minute))
+		if waiting, err := b.IsWaiting("requested"); err != nil || !waiting {
+			t.Fatalf("Expected awaiting secret to be waiting, got %t, %v", waiting, err)
+		}
+
+		result := make(chan *Secret, 1)
+		go func() {
+			sec, err := b.WaitForUnlock(context.Background(), "requested", nil)
+			if err != nil {
+				t.Errorf("WaitForUnlock failed: %v", err)
+			}
+			result <- sec
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		if err := b.Confirm("requested", "", "10.0.0.1"); !errors.Is(err, ErrNoRecipient) {
+			t.Errorf("Expected an empty passcode not to unlock a request, got %v", err)
+		}
+		if err := b.Fulfill("requested", plainSecret(time.Minute)); err != nil {
+			t.Fatalf("Fulfill failed: %v", err)
+		}
+
+		select {
+		case sec := <-result:
+			if sec == nil || !sec.Unlocked || sec.Awaiting || sec.CipherText != "cipher" {
+				t.Errorf("Unexpected fulfilled secret: %+v", sec)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for fulfillment")
+		}
+
+		if err := b.Fulfill("requested", plainSecret(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected second Fulfill to fail with ErrNotFound, got %v", err)
+		}
+		if sec, err := b.Take("requested"); err != nil || sec.CipherText != "cipher" {
+			t.Errorf("Expected fulfilled secret from Take, got %+v, %v", sec, err)
+		}
+	})
+
+	t.Run("FulfillWithoutListener", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("requested", awaitingSecret(time.Minute))
+		if err := b.Fulfill("requested", plainSecret(time.Minute)); err != nil {
+			t.Fatalf("Fulfill failed: %v", err)
+		}
+		if sec, err := b.Get("requested"); err != nil || !sec.Unlocked || sec.Awaiting {
+			t.Errorf("Expected unlocked secret, got %+v, %v", sec, err)
+		}
+		if err := b.Fulfill("missing", plainSecret(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing request, got %v", err)
+		}
+		b.Save("plain", plainSecret(time.Minute))
+		if err := b.Fulfill("plain", plainSecret(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a secret that was not requested, got %v", err)
+		}
+	})
+
+	t.Run("WaitRejectsPlainSecret", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("plain", plainSecret(time.Minute))
+		if _, err := b.WaitForUnlock(context.Background(), "plain", nil); !errors.Is(err, ErrNotSecure) {
+			t.Errorf("Expected ErrNotSecure, got %v", err)
+		}
+	})
+
+	t.Run("SingleListener", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("secure", secureSecret(time.Minute))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := b.WaitForUnlock(ctx, "secure", nil)
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		if _, err := b.WaitForUnlock(context.Background(), "secure", nil); !errors.Is(err, ErrListenerConnected) {
+			t.Errorf("Expected ErrListenerConnected, got %v", err)
+		}
+
+		cancel()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for cancelled listener")
+		}
+
+		if err := b.Confirm("secure", "123456", "10.0.0.1"); !errors.Is(err, ErrNoRecipient) {
+			t.Errorf("Expected listener to be released, got %v", err)
+		}
+	})
+
+	t.Run("WaitAnnouncesFirstListenerOnly", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("secure", secureSecret(time.Minute))
+
+		var announced atomic.Int32
+		onWait := func(*Secret) { announced.Add(1) }
+		for range 3 {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			b.WaitForUnlock(ctx, "secure", onWait)
+			cancel()
+		}
+		if n := announced.Load(); n != 1 {
+			t.Errorf("Expected one announcement across reconnects, got %d", n)
+		}
+	})
+
+	t.Run("IDsSkipExpired", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("live", plainSecret(time.Minute))
+		b.Save("old", plainSecret(-time.Second))
+		ids, err := b.IDs()
+		if err != nil {
+			t.Fatalf("IDs failed: %v", err)
+		}
+		if len(ids) != 1 || ids[0] != "live" {
+			t.Errorf("Expected only the live secret, got %v", ids)
+		}
+	})
+
+	t.Run("ResealKeepsState", func(t *testing.T) {
+		b := newBackend(t)
+		sec := secureSecret(time.Minute)
+		sec.Views = 3
+		b.Save("rekeyed", sec)
+		b.Take("rekeyed")
+
+		if err := b.Reseal("rekeyed", &Secret{CipherText: "new", Nonce: []byte("n2"), KeyID: "k2"}); err != nil {
+			t.Fatalf("Reseal failed: %v", err)
+		}
+		got, err := b.Get("rekeyed")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got.CipherText != "new" || got.KeyID != "k2" || got.Views != 2 || got.Code != "123456" || got.Unlocked {
+			t.Errorf("Unexpected secret after Reseal: %+v", got)
+		}
+
+		b.Delete("rekeyed")
+		if err := b.Reseal("rekeyed", &Secret{CipherText: "new"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a deleted secret, got %v", err)
+		}
+		if _, err := b.Get("rekeyed"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected Reseal not to bring back a deleted secret, got %v", err)
+		}
+	})
+
+	t.Run("WaitTimesOutAtExpiry", func(t *testing.T) {
+		b := newBackend(t)
+		b.Save("secure", secureSecret(100*time.Millisecond))
+		if _, err := b.WaitForUnlock(context.Background(), "secure", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound at expiry, got %v", err)
+		}
+	})
+}
+
+func TestMemoryBackend_Conformance(t *testing.T) {
+	testBackendConformance(t, func(t *testing.T) Backend {
+		return NewMemoryBackend()
+	})
+}
+
+func plainSecret(ttl time.Duration) *Secret {
+	return &Secret{
+		CipherText: "cipher",
+		Nonce:      []byte("nonce"),
+		ExpiresAt:  time.Now().Add(ttl),
+		Unlocked:   true,
+	}
+}
+
+func secureSecret(ttl time.Duration) *Secret {
+	sec := plainSecret(ttl)
+	sec.Code = "123456"
+	sec.Unlocked = false
+	return sec
+}
+
+// confirmWhenListening retries Confirm until the waiter has registered,
+// since WaitForUnlock runs in another goroutine.
+func awaitingSecret(ttl time.Duration) *Secret {
+	return &Secret{
+		ExpiresAt: time.Now().Add(ttl),
+		Awaiting:  true,
+	}
+}
+
+func confirmWhenListening(t *testing.T, b Backend, id, code string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := b.Confirm(id, code, "10.0.0.1")
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrNoRecipient) || time.Now().After(deadline) {
+			t.Fatalf("Confirm failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,12 +3,38 @@ package storage
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/argon2"
+
+	"whisperbin/internal/secmem"
 )
 
+var errInvalidCiphertext = errors.New("invalid ciphertext")
+
+// Argon2id parameters for passphrase-protected secrets, following the
+// second recommended option of RFC 9106 (64 MiB, one pass).
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	saltSize     = 16
+
+	// maxDerivations bounds concurrent Argon2id runs, which hold
+	// argonMemory KiB each, so a burst of passphrase attempts cannot
+	// exhaust the server's memory.
+	maxDerivations = 4
+)
+
+var derivations = make(chan struct{}, maxDerivations)
+
 func generateID() (string, error) {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
@@ -26,31 +52,91 @@ func generateCode() (string, error) {
 	return fmt.Sprintf("%06d", val%1000000), nil
 }
 
-func encrypt(plaintext, key []byte) ([]byte, []byte, error) {
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// acquireDerivation reserves one of the maxDerivations slots for a call to
+// passphraseKey. It fails with ErrBusy rather than queueing, so waiting
+// requests do not pile up behind an attacker.
+func acquireDerivation() (release func(), err error) {
+	select {
+	case derivations <- struct{}{}:
+		return func() { <-derivations }, nil
+	default:
+		return nil, ErrBusy
+	}
+}
+
+// passphraseKey stretches the passphrase with Argon2id and mixes in the
+// instance key, so the ciphertext needs both the passphrase and the
+// server's key to be decrypted. Callers hold a slot from
+// acquireDerivation.
+func passphraseKey(instanceKey []byte, passphrase string, salt []byte) []byte {
+	stretched := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, 32)
+	defer wipe(stretched)
+	mac := hmac.New(sha256.New, instanceKey)
+	mac.Write(stretched)
+	return mac.Sum(secmem.Alloc(sha256.Size)[:0])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	nonce := make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext under key with a fresh nonce. aad is
+// authenticated but not encrypted; decrypt must be given the same.
+func encrypt(plaintext, key, aad []byte) ([]byte, []byte, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
 		return nil, nil, err
 	}
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, nil, err
 	}
-	ciphertext := aesgcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext := aesgcm.Seal(nil, nonce, plaintext, aad)
 	return ciphertext, nonce, nil
 }
 
-func decrypt(ciphertext, nonce, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+// decrypt opens ciphertext into a buffer from secmem.Alloc, which the
+// caller must wipe.
+func decrypt(ciphertext, nonce, key, aad []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	// Open panics on a nonce of the wrong size, which a stored record
+	// could carry.
+	if len(nonce) != aesgcm.NonceSize() || len(ciphertext) < aesgcm.Overhead() {
+		return nil, errInvalidCiphertext
+	}
+	plain := secmem.Alloc(len(ciphertext) - aesgcm.Overhead())
+	out, err := aesgcm.Open(plain[:0], nonce, ciphertext, aad)
 	if err != nil {
+		wipe(plain)
 		return nil, err
 	}
-	return aesgcm.Open(nil, nonce, ciphertext, nil)
+	return out, nil
 }
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"whisperbin/internal/secmem"
+)
+
+const (
+	dataKeySize = 32
+	nonceSize   = 12
+)
+
+// Sealed fields are stored as sealFormat + ":" + base64. Fields from
+// before authenticated metadata are bare base64, which never contains a
+// colon, and are opened without it.
+const sealFormat = "v1"
+
+// Names of the sealed fields in their authenticated data, so one field
+// cannot be passed off as another.
+const (
+	fieldText        = "text"
+	fieldNotifyEmail = "notify_email"
+	fieldRecipients  = "recipients"
+)
+
+// ErrTampered means a secret's data key opened but one of its fields did
+// not: the record was altered or moved to another ID.
+var ErrTampered = errors.New("secret does not match its stored metadata")
+
+var (
+	errInvalidWrappedKey = errors.New("invalid wrapped data key")
+	errUnknownFormat     = errors.New("unknown ciphertext format")
+)
+
+// aad is the authenticated data of field on sec. It binds the secret's
+// ID, expiry, mode and key ID, so changing any of them on a stored record,
+// or copying the field to another record, makes it fail to open.
+func (s *Secret) aad(field string) []byte {
+	return fmt.Appendf(nil, "whisperbin %s\x00%s\x00%s\x00%d\x00%s\x00%s",
+		sealFormat, field, s.ID, s.ExpiresAt.Unix(), s.mode(), s.KeyID)
+}
+
+// sealField encrypts plaintext as field of sec under key in the current
+// format. sec must already have its ID, expiry, mode and key ID.
+func sealField(sec *Secret, field string, plaintext, key []byte) (string, []byte, error) {
+	cipherText, nonce, err := encrypt(plaintext, key, sec.aad(field))
+	if err != nil {
+		return "", nil, err
+	}
+	return sealFormat + ":" + base64.StdEncoding.EncodeToString(cipherText), nonce, nil
+}
+
+func isVersioned(field string) bool {
+	return strings.Contains(field, ":")
+}
+
+// dataKey is a fresh per-secret key together with its wrapped form.
+// Callers must wipe it once the secret's fields are encrypted.
+type dataKey struct {
+	key     []byte
+	keyID   string
+	wrapped []byte
+}
+
+// newDataKey generates a data key and has the key provider wrap it.
+func (s *Store) newDataKey() (*dataKey, error) {
+	key := secmem.Alloc(dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := s.keys.Wrap(key)
+	if err != nil {
+		wipe(key)
+		return nil, err
+	}
+	return &dataKey{key: key, keyID: keyID, wrapped: wrapped}, nil
+}
+
+// seal encrypts plaintext as field of sec under the data key, whose key
+// ID sec must already carry.
+func (d *dataKey) seal(sec *Secret, field string, plaintext []byte) (string, []byte, error) {
+	return sealField(sec, field, plaintext, d.key)
+}
+
+func (d *dataKey) wipe() {
+	wipe(d.key)
+}
+
+// wrapAAD is the authenticated data of a data key wrapped under the
+// instance key keyID. No sealed field uses it, so a wrapped key copied
+// into a record as its text does not open as one.
+func wrapAAD(keyID string) []byte {
+	return []byte("whisperbin wrap\x00" + keyID)
+}
+
+// wrapKey seals a data key under the instance key keyID as
+// nonce || ciphertext.
+func wrapKey(key, instanceKey []byte, keyID string) ([]byte, error) {
+	sealed, nonce, err := encrypt(key, instanceKey, wrapAAD(keyID))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, sealed...), nil
+}
+
+func unwrapKey(wrapped, instanceKey []byte, keyID string) ([]byte, error) {
+	if len(wrapped) <= nonceSize {
+		return nil, errInvalidWrappedKey
+	}
+	return decrypt(wrapped[nonceSize:], wrapped[:nonceSize], instanceKey, wrapAAD(keyID))
+}
+
+// dataKeys returns the keys the fields of sec may be encrypted with: its
+// unwrapped data key, or, for records from before envelope encryption,
+// copies of the instance keys it may have been written under. Callers
+// must wipe them.
+func (s *Store) dataKeys(sec *Secret) ([][]byte, error) {
+	if sec.WrappedKey != nil {
+		dek, err := s.keys.Unwrap(sec.KeyID, sec.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{dek}, nil
+	}
+
+	// Only a keyring holds instance keys that can open old records.
+	ring, ok := s.keys.(*Keyring)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	keys, err := ring.candidates(sec.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, secmem.Clone(key))
+	}
+	return out, nil
+}
+
+// open decrypts the sealed field named field of sec, whose stored value
+// is text. derive, if set, turns the data key into the key the field was
+// actually encrypted with. It fails closed: a field in an unknown format,
+// or one whose authenticated data no longer matches sec, is not returned.
+// The caller must wipe the plaintext.
+func (s *Store) open(sec *Secret, field, text string, nonce []byte, derive func([]byte) []byte) ([]byte, error) {
+	var aad []byte
+	if format, rest, ok := strings.Cut(text, ":"); ok {
+		if format != sealFormat {
+			return nil, errUnknownFormat
+		}
+		if sec.WrappedKey == nil {
+			return nil, ErrTampered
+		}
+		text, aad = rest, sec.aad(field)
+	}
+	cipherBytes, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.dataKeys(sec)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(keys...)
+
+	for _, key := range keys {
+		if derive != nil {
+			key = derive(key)
+			defer wipe(key)
+		}
+		var plain []byte
+		if plain, err = decrypt(cipherBytes, nonce, key, aad); err == nil {
+			return plain, nil
+		}
+	}
+	// A data key opens every field it sealed, unless a passphrase is
+	// mixed in that may just be wrong.
+	if sec.WrappedKey != nil && derive == nil {
+		return nil, ErrTampered
+	}
+	return nil, err
+}
+
+// wipe overwrites key material and plaintext that is no longer needed,
+// and releases it if it is locked in memory.
+func wipe(bufs ...[]byte) {
+	secmem.Wipe(bufs...)
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventCreated  EventType = "created"
+	EventRevealed EventType = "revealed"
+	EventExpired  EventType = "expired"
+	// EventWaiting is a secure-mode recipient waiting for the sender to
+	// enter their passcode.
+	EventWaiting EventType = "waiting"
+	// EventConfirmFailed is a wrong passcode or passphrase. EventBlocked
+	// follows once the failures from one address reach MaxCodeFailures.
+	EventConfirmFailed EventType = "confirm_failed"
+	EventBlocked       EventType = "blocked"
+)
+
+// Event describes a change in a secret's lifecycle. It never carries
+// plaintext: Secret, if set, is the stored record with its ciphertext.
+// ID is the capability to reveal the secret, so listeners that forward
+// events elsewhere should send Ref instead.
+type Event struct {
+	Type   EventType
+	ID     string
+	Time   time.Time
+	IP     string
+	Secret *Secret
+	// Viewed is, for EventExpired, how often the secret had been read
+	// before it expired with views left.
+	Viewed int
+}
+
+// Ref is a stable reference to the secret that cannot be turned back into
+// its link.
+func (e Event) Ref() string {
+	return Ref(e.ID)
+}
+
+// Ref returns the reference of the secret with the given ID, which the
+// sender's management page shows to match it with notifications.
+func Ref(id string) string {
+	return hashToken(id)[:16]
+}
+
+// Listener receives events synchronously on the goroutine that caused
+// them, so it must not block.
+type Listener func(Event)
+
+type EventBus struct {
+	mu        sync.RWMutex
+	listeners []Listener
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Subscribe(l Listener) {
+	b.mu.Lock()
+	b.listeners = append(b.listeners, l)
+	b.mu.Unlock()
+}
+
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, l := range b.listeners {
+		l(e)
+	}
+}
@@ -0,0 +1,626 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	compactThreshold = 128
+	// keySlotSize is the size of one entry in the key file.
+	keySlotSize = 512
+)
+
+type fileRecord struct {
+	Op      string   `json:"op"`
+	ID      string   `json:"id"`
+	Secret  *Secret  `json:"secret,omitempty"`
+	Receipt *Receipt `json:"receipt,omitempty"`
+	// Sealed marks a secret whose wrapped data key is kept in the key
+	// file instead of the log.
+	Sealed bool `json:"sealed,omitempty"`
+}
+
+// fileKey is a secret's entry in the key file.
+type fileKey struct {
+	KeyID   string `json:"key_id"`
+	Wrapped []byte `json:"wrapped"`
+}
+
+// keySlot is a fileKey as stored in one slot of the key file.
+type keySlot struct {
+	ID      string `json:"id"`
+	KeyID   string `json:"key_id"`
+	Wrapped []byte `json:"wrapped"`
+}
+
+var blankKeySlot = append(bytes.Repeat([]byte{' '}, keySlotSize-1), '\n')
+
+// FileBackend keeps secrets in memory and mirrors every change to an
+// append-only log on disk, so outstanding links survive a restart. Only
+// ciphertext is written; expired and deleted records are compacted away.
+//
+// Wrapped data keys are not written to the log, which keeps old records
+// until the next compaction. They live in a separate key file of
+// fixed-size slots: a key is written into a free slot, and a deleted
+// secret's slot is overwritten in place, so its key is gone from disk once
+// Delete or Take returns and the ciphertext left in the log cannot be
+// decrypted. A key is written before the log record that needs it and
+// removed after the one that deletes it; the key file is authoritative
+// for the key ID. The key file is compacted together with the log.
+//
+// The log has a single writer: NewFileBackend holds a lock on path.lock
+// until Close, and fails with ErrFileLocked while another process has it.
+type FileBackend struct {
+	mu       sync.Mutex
+	path     string
+	keysPath string
+	lock     *os.File
+	file     *os.File
+	records  int
+	mem      *MemoryBackend
+
+	keys      *os.File
+	keySlots  map[string]int64
+	freeSlots []int64
+	nextSlot  int64
+}
+
+func NewFileBackend(path string) (*FileBackend, error) {
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	f, err := loadFileBackend(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	f.lock = lock
+	return f, nil
+}
+
+func loadFileBackend(path string) (*FileBackend, error) {
+	mem, err := LoadFileSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileBackend{
+		path:     path,
+		keysPath: path + ".keys",
+		mem:      mem,
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadFileSnapshot reads the log at path into a MemoryBackend without
+// writing to it or taking its lock, for read-only tools that run next to
+// a server. Changes to the snapshot are not written back.
+func LoadFileSnapshot(path string) (*MemoryBackend, error) {
+	secrets, receipts, err := loadLog(path)
+	if err != nil {
+		return nil, err
+	}
+	mem := NewMemoryBackend()
+	for id, sec := range secrets {
+		mem.Save(id, sec)
+	}
+	for id, rec := range receipts {
+		mem.SaveReceipt(id, rec)
+	}
+	return mem, nil
+}
+
+func (f *FileBackend) Save(id string, sec *Secret) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if sec.WrappedKey != nil {
+		if err := f.putKey(id, sec); err != nil {
+			return err
+		}
+	}
+	if err := f.appendRecord(putRecord(id, sec)); err != nil {
+		return err
+	}
+	return f.mem.Save(id, sec)
+}
+
+func (f *FileBackend) Get(id string) (*Secret, error) {
+	return f.mem.Get(id)
+}
+
+// Take and Delete are durable once they return: the deletion record, or
+// the decremented view count of a multi-view secret, has been fsynced, so
+// a revealed secret cannot come back after a crash.
+func (f *FileBackend) Take(id string) (*Secret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sec, err := f.mem.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	rec := fileRecord{Op: "del", ID: id}
+	if left := sec.ViewsLeft() - 1; left > 0 {
+		rec = putRecord(id, sec)
+		rec.Secret.Views = left
+	}
+	if err := f.appendRecord(rec); err != nil {
+		return nil, err
+	}
+	taken, err := f.mem.Take(id)
+	if err == nil && rec.Op == "del" && sec.WrappedKey != nil {
+		// The reveal already counts. A key that could not be removed
+		// now goes with the next compaction.
+		f.dropKey(id)
+	}
+	return taken, err
+}
+
+func (f *FileBackend) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.mem.Get(id); err != nil {
+		return f.mem.Delete(id)
+	}
+	if err := f.appendRecord(fileRecord{Op: "del", ID: id}); err != nil {
+		return err
+	}
+	f.mem.Delete(id)
+	return f.dropKey(id)
+}
+
+func (f *FileBackend) Confirm(id, inputCode, ip string) error {
+	return f.mem.Confirm(id, inputCode, ip)
+}
+
+func (f *FileBackend) WaitForUnlock(ctx context.Context, id string, onWait func(*Secret)) (*Secret, error) {
+	return f.mem.WaitForUnlock(ctx, id, onWait)
+}
+
+func (f *FileBackend) Fulfill(id string, sec *Secret) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cur, err := f.mem.Get(id); err != nil || !cur.Awaiting {
+		return ErrNotFound
+	}
+	if err := f.putKey(id, sec); err != nil {
+		return err
+	}
+	if err := f.appendRecord(putRecord(id, sec)); err != nil {
+		return err
+	}
+	return f.mem.Fulfill(id, sec)
+}
+
+func (f *FileBackend) IDs() ([]string, error) {
+	return f.mem.IDs()
+}
+
+func (f *FileBackend) Reseal(id string, sec *Secret) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cur, err := f.mem.Get(id)
+	if err != nil {
+		return err
+	}
+	cp := *cur
+	cp.setSealed(sec)
+	if err := f.putKey(id, &cp); err != nil {
+		return err
+	}
+	if err := f.appendRecord(putRecord(id, &cp)); err != nil {
+		return err
+	}
+	return f.mem.Reseal(id, sec)
+}
+
+func (f *FileBackend) IsWaiting(id string) (bool, error) {
+	return f.mem.IsWaiting(id)
+}
+
+func (f *FileBackend) RecordFailure(id, ip string) (int, error) {
+	return f.mem.RecordFailure(id, ip)
+}
+
+func (f *FileBackend) Blocked(id, ip string) (bool, error) {
+	return f.mem.Blocked(id, ip)
+}
+
+func (f *FileBackend) ResetFailures(id, ip string) error {
+	return f.mem.ResetFailures(id, ip)
+}
+
+func (f *FileBackend) SaveReceipt(id string, rec *Receipt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.appendRecord(fileRecord{Op: "receipt", ID: id, Receipt: rec}); err != nil {
+		return err
+	}
+	return f.mem.SaveReceipt(id, rec)
+}
+
+func (f *FileBackend) GetReceipt(id string) (*Receipt, error) {
+	return f.mem.GetReceipt(id)
+}
+
+func (f *FileBackend) MarkViewed(id string, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec, err := f.mem.GetReceipt(id)
+	if err != nil {
+		return nil
+	}
+	rec.ViewedAt = append(rec.ViewedAt, at)
+	if err := f.appendRecord(fileRecord{Op: "receipt", ID: id, Receipt: rec}); err != nil {
+		return err
+	}
+	return f.mem.SaveReceipt(id, rec)
+}
+
+// CleanupExpired leaves expired records in the log; loadLog and compact
+// skip them. A secret that expires while the server is down is therefore
+// not reported after the restart.
+func (f *FileBackend) CleanupExpired() map[string]*Secret {
+	expired := f.mem.CleanupExpired()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id := range expired {
+		f.dropKey(id)
+	}
+	if f.records > 2*f.mem.len()+compactThreshold {
+		f.compact()
+	}
+	return expired
+}
+
+func (f *FileBackend) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.file.Close()
+	f.keys.Close()
+	if f.lock != nil {
+		f.lock.Close()
+	}
+	return err
+}
+
+func (f *FileBackend) appendRecord(rec fileRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.records++
+	return nil
+}
+
+// compact rewrites the key file and the log with only the live secrets
+// and receipts and atomically replaces the old files. The caller must
+// hold f.mu, except during construction.
+func (f *FileBackend) compact() error {
+	live := f.mem.snapshot()
+	if err := f.compactKeys(live); err != nil {
+		return err
+	}
+
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for id, sec := range live {
+		line, err := json.Marshal(putRecord(id, sec))
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	receipts := f.mem.receiptSnapshot()
+	for id, rec := range receipts {
+		line, err := json.Marshal(fileRecord{Op: "receipt", ID: id, Receipt: rec})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(f.path)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	f.records = len(live) + len(receipts)
+	return nil
+}
+
+func loadLog(path string) (map[string]*Secret, map[string]*Receipt, error) {
+	secrets := make(map[string]*Secret)
+	receipts := make(map[string]*Receipt)
+	sealed := make(map[string]bool)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, receipts, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A final line without a newline is a write torn by a crash.
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var rec fileRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		switch rec.Op {
+		case "put":
+			if rec.Secret == nil {
+				return nil, nil, fmt.Errorf("%s:%d: put without secret", path, lineNo)
+			}
+			secrets[rec.ID] = rec.Secret
+			sealed[rec.ID] = rec.Sealed
+		case "del":
+			delete(secrets, rec.ID)
+		case "receipt":
+			if rec.Receipt == nil {
+				return nil, nil, fmt.Errorf("%s:%d: receipt record without receipt", path, lineNo)
+			}
+			receipts[rec.ID] = rec.Receipt
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: unknown op %q", path, lineNo, rec.Op)
+		}
+	}
+
+	keys, err := loadKeys(path + ".keys")
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	for id, sec := range secrets {
+		if now.After(sec.ExpiresAt) {
+			delete(secrets, id)
+			continue
+		}
+		if sealed[id] {
+			// Without its key the record was deleted and is unreadable.
+			key, ok := keys[id]
+			if !ok {
+				delete(secrets, id)
+				continue
+			}
+			sec.KeyID, sec.WrappedKey = key.KeyID, key.Wrapped
+		}
+	}
+	for id, rec := range receipts {
+		if now.After(rec.RetainUntil) {
+			delete(receipts, id)
+		}
+	}
+	return secrets, receipts, nil
+}
+
+// persistedSecret strips runtime state: a secure-mode secret is always
+// written as locked, so a restart requires the sender to approve again.
+// Awaiting secrets stay locked until they are fulfilled.
+func persistedSecret(sec *Secret) *Secret {
+	cp := *sec
+	cp.Unlocked = sec.Code == "" && !sec.Awaiting
+	return &cp
+}
+
+// putRecord logs sec without its wrapped key, which goes to the key file.
+func putRecord(id string, sec *Secret) fileRecord {
+	rec := fileRecord{Op: "put", ID: id, Secret: persistedSecret(sec), Sealed: sec.WrappedKey != nil}
+	rec.Secret.WrappedKey = nil
+	return rec
+}
+
+// putKey writes the wrapped key of sec into id's slot of the key file,
+// or into a free one. The caller must hold f.mu.
+func (f *FileBackend) putKey(id string, sec *Secret) error {
+	data, err := encodeKeySlot(keySlot{ID: id, KeyID: sec.KeyID, Wrapped: sec.WrappedKey})
+	if err != nil {
+		return err
+	}
+	slot, ok := f.keySlots[id]
+	if !ok {
+		if n := len(f.freeSlots); n > 0 {
+			slot, f.freeSlots = f.freeSlots[n-1], f.freeSlots[:n-1]
+		} else {
+			slot = f.nextSlot
+			f.nextSlot++
+		}
+	}
+	if err := f.writeKeySlot(slot, data); err != nil {
+		if !ok {
+			f.freeSlots = append(f.freeSlots, slot)
+		}
+		return err
+	}
+	f.keySlots[id] = slot
+	return nil
+}
+
+// dropKey overwrites id's slot of the key file, if it has one. The caller
+// must hold f.mu.
+func (f *FileBackend) dropKey(id string) error {
+	slot, ok := f.keySlots[id]
+	if !ok {
+		return nil
+	}
+	if err := f.writeKeySlot(slot, blankKeySlot); err != nil {
+		return err
+	}
+	delete(f.keySlots, id)
+	f.freeSlots = append(f.freeSlots, slot)
+	return nil
+}
+
+func (f *FileBackend) writeKeySlot(slot int64, data []byte) error {
+	if _, err := f.keys.WriteAt(data, slot*keySlotSize); err != nil {
+		return err
+	}
+	return f.keys.Sync()
+}
+
+// compactKeys replaces the key file with one slot for each live wrapped
+// key. The caller must hold f.mu, except during construction.
+func (f *FileBackend) compactKeys(live map[string]*Secret) error {
+	tmpPath := f.keysPath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	slots := make(map[string]int64)
+	for id, sec := range live {
+		if sec.WrappedKey == nil {
+			continue
+		}
+		data, err := encodeKeySlot(keySlot{ID: id, KeyID: sec.KeyID, Wrapped: sec.WrappedKey})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(data)
+		slots[id] = int64(len(slots))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, f.keysPath); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(f.keysPath)); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.keysPath, os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	if f.keys != nil {
+		f.keys.Close()
+	}
+	f.keys = file
+	f.keySlots, f.freeSlots, f.nextSlot = slots, nil, int64(len(slots))
+	return nil
+}
+
+// encodeKeySlot pads the JSON form of k to keySlotSize bytes.
+func encodeKeySlot(k keySlot) ([]byte, error) {
+	line, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) >= keySlotSize {
+		return nil, errors.New("wrapped key does not fit in a key file slot")
+	}
+	data := append([]byte(nil), blankKeySlot...)
+	copy(data, line)
+	return data, nil
+}
+
+// loadKeys reads the key file. Blank slots are free; a slot or trailing
+// fragment torn by a crash is skipped, so the secret it belonged to is
+// dropped as unreadable.
+func loadKeys(path string) (map[string]fileKey, error) {
+	keys := make(map[string]fileKey)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for off := 0; off+keySlotSize <= len(data); off += keySlotSize {
+		line := bytes.TrimSpace(data[off : off+keySlotSize])
+		if len(line) == 0 {
+			continue
+		}
+		var k keySlot
+		if err := json.Unmarshal(line, &k); err != nil || k.ID == "" {
+			continue
+		}
+		keys[k.ID] = fileKey{KeyID: k.KeyID, Wrapped: k.Wrapped}
+	}
+	return keys, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
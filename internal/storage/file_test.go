@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileBackend_Conformance(t *testing.T) {
+	testBackendConformance(t, func(t *testing.T) Backend {
+		b, err := NewFileBackend(filepath.Join(t.TempDir(), "secrets.log"))
+		if err != nil {
+			t.Fatalf("NewFileBackend failed: %v", err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	})
+}
+
+func TestFileBackend_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	b.Save("kept", plainSecret(time.Minute))
+	b.Save("secure", secureSecret(time.Minute))
+	b.Close()
+
+	b = openFileBackend(t, path)
+	sec, err := b.Get("kept")
+	if err != nil {
+		t.Fatalf("Expected secret to survive restart: %v", err)
+	}
+	if sec.CipherText != "cipher" || string(sec.Nonce) != "nonce" {
+		t.Errorf("Unexpected secret after restart: %+v", sec)
+	}
+	if waiting, err := b.IsWaiting("secure"); err != nil || !waiting {
+		t.Errorf("Expected secure secret to be locked after restart, got %t, %v", waiting, err)
+	}
+}
+
+func TestFileBackend_DeletedSecretNotResurrected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	b.Save("once", plainSecret(time.Minute))
+	b.Save("revoked", plainSecret(time.Minute))
+	if _, err := b.Take("once"); err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	if err := b.Delete("revoked"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	// Simulate a crash: the file is never closed or compacted.
+	crash(b)
+
+	b = openFileBackend(t, path)
+	for _, id := range []string{"once", "revoked"} {
+		if _, err := b.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected %s to stay deleted, got %v", id, err)
+		}
+	}
+}
+
+func TestFileBackend_ViewCountSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	sec := plainSecret(time.Minute)
+	sec.Views = 3
+	b.Save("multi", sec)
+	if _, err := b.Take("multi"); err != nil {
+		t.Fatalf("Take failed: %v", err)
+	}
+	crash(b)
+
+	b = openFileBackend(t, path)
+	got, err := b.Get("multi")
+	if err != nil {
+		t.Fatalf("Expected secret with views left to survive restart: %v", err)
+	}
+	if got.Views != 2 {
+		t.Errorf("Expected 2 views left after restart, got %d", got.Views)
+	}
+}
+
+func TestFileBackend_ReceiptSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	b.Save("read", plainSecret(time.Minute))
+	b.SaveReceipt("read", &Receipt{TokenHash: "h", Views: 1, RetainUntil: time.Now().Add(time.Hour)})
+	b.Take("read")
+	b.MarkViewed("read", time.Now())
+	crash(b)
+
+	b = openFileBackend(t, path)
+	rec, err := b.GetReceipt("read")
+	if err != nil {
+		t.Fatalf("Expected receipt to survive restart: %v", err)
+	}
+	if rec.Status() != ReceiptViewed {
+		t.Errorf("Expected viewed receipt after restart, got %q", rec.Status())
+	}
+	if _, err := b.Get("read"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected secret to stay deleted, got %v", err)
+	}
+}
+
+func TestFileBackend_AwaitingSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	b.Save("requested", awaitingSecret(time.Minute))
+	crash(b)
+
+	b = openFileBackend(t, path)
+	if waiting, err := b.IsWaiting("requested"); err != nil || !waiting {
+		t.Fatalf("Expected request to be awaiting after restart, got %t, %v", waiting, err)
+	}
+	if err := b.Fulfill("requested", plainSecret(time.Minute)); err != nil {
+		t.Fatalf("Fulfill failed: %v", err)
+	}
+	crash(b)
+
+	b = openFileBackend(t, path)
+	if sec, err := b.Get("requested"); err != nil || !sec.Unlocked || sec.CipherText != "cipher" {
+		t.Errorf("Expected fulfilled secret after restart, got %+v, %v", sec, err)
+	}
+}
+
+func TestFileBackend_DropsExpiredOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	b.Save("short", plainSecret(50*time.Millisecond))
+	b.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	b = openFileBackend(t, path)
+	if _, err := b.Get("short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected expired secret to be dropped, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "short") {
+		t.Error("Expected expired record to be compacted away")
+	}
+}
+
+func TestFileBackend_CompactsDeletedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	for i := 0; i < compactThreshold; i++ {
+		b.Save("churn", plainSecret(time.Minute))
+		b.Delete("churn")
+	}
+	b.Save("live", plainSecret(time.Minute))
+	b.CleanupExpired()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("Expected 1 record after compaction, got %d", lines)
+	}
+	if _, err := b.Get("live"); err != nil {
+		t.Errorf("Compaction lost a live secret: %v", err)
+	}
+}
+
+func TestFileBackend_IgnoresTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+
+	b := openFileBackend(t, path)
+	b.Save("kept", plainSecret(time.Minute))
+	b.Close()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"del","id":"ke`)
+	f.Close()
+
+	b = openFileBackend(t, path)
+	if _, err := b.Get("kept"); err != nil {
+		t.Errorf("Expected torn trailing record to be ignored: %v", err)
+	}
+}
+
+func TestFileBackend_KeysLiveOutsideTheLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+	wrapped := []byte("wrapped-data-key")
+
+	b := openFileBackend(t, path)
+	sec := plainSecret(time.Minute)
+	sec.KeyID, sec.WrappedKey = "k1", wrapped
+	b.Save("sealed", sec)
+	b.Save("other", plainSecret(time.Minute))
+
+	logData, _ := os.ReadFile(path)
+	encoded := base64.StdEncoding.EncodeToString(wrapped)
+	if strings.Contains(string(logData), encoded) {
+		t.Fatal("Expected the wrapped key to stay out of the log")
+	}
+
+	// Crash without closing: the key must come back from the key file.
+	crash(b)
+	b = openFileBackend(t, path)
+	got, err := b.Get("sealed")
+	if err != nil || string(got.WrappedKey) != string(wrapped) || got.KeyID != "k1" {
+		t.Fatalf("Expected the wrapped key after restart, got %+v, %v", got, err)
+	}
+
+	if err := b.Delete("sealed"); err != nil {
+		t.Fatal(err)
+	}
+	keyData, _ := os.ReadFile(path + ".keys")
+	if strings.Contains(string(keyData), encoded) {
+		t.Error("Expected Delete to remove the wrapped key from disk")
+	}
+	logData, _ = os.ReadFile(path)
+	if !strings.Contains(string(logData), `"sealed"`) {
+		t.Fatal("Expected the deleted record to still be in the uncompacted log")
+	}
+
+	// A sealed record whose key is gone is unreadable and not loaded, even
+	// if its delete record was lost.
+	os.WriteFile(path+".keys", nil, 0o600)
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	os.WriteFile(path, []byte(strings.Join(lines[:len(lines)-1], "\n")+"\n"), 0o600)
+	crash(b)
+	b = openFileBackend(t, path)
+	if _, err := b.Get("sealed"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a sealed record without its key to be dropped, got %v", err)
+	}
+	if _, err := b.Get("other"); err != nil {
+		t.Errorf("Expected records without data keys to load: %v", err)
+	}
+}
+
+func TestFileBackend_KeyFileIsWrittenInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+	b := openFileBackend(t, path)
+
+	sealed := func(wrapped string) *Secret {
+		sec := plainSecret(time.Minute)
+		sec.KeyID, sec.WrappedKey = "k1", []byte(wrapped)
+		return sec
+	}
+	b.Save("a", sealed("key-a"))
+	b.Save("b", sealed("key-b"))
+	if _, err := b.Take("a"); err != nil {
+		t.Fatal(err)
+	}
+	b.Save("c", sealed("key-c"))
+
+	keyData, _ := os.ReadFile(path + ".keys")
+	if len(keyData) != 2*keySlotSize {
+		t.Errorf("Expected the freed slot to be reused, key file is %d bytes", len(keyData))
+	}
+	if strings.Contains(string(keyData), base64.StdEncoding.EncodeToString([]byte("key-a"))) {
+		t.Error("Expected the taken secret's key to be overwritten")
+	}
+
+	crash(b)
+	b = openFileBackend(t, path)
+	for id, want := range map[string]string{"b": "key-b", "c": "key-c"} {
+		if got, err := b.Get(id); err != nil || string(got.WrappedKey) != want {
+			t.Errorf("Expected key %q for %s after restart, got %+v, %v", want, id, got, err)
+		}
+	}
+}
+
+func openFileBackend(t *testing.T, path string) *FileBackend {
+	t.Helper()
+	b, err := NewFileBackend(path)
+	if err != nil {
+		t.Fatalf("NewFileBackend failed: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// crash releases b's lock the way a dying process would, without closing
+// or compacting its log.
+func crash(b *FileBackend) {
+	b.lock.Close()
+}
+
+func TestFileBackend_SingleWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.log")
+	b := openFileBackend(t, path)
+	b.Save("kept", plainSecret(time.Minute))
+
+	if _, err := NewFileBackend(path); !errors.Is(err, ErrFileLocked) {
+		t.Fatalf("Expected a second writer to be refused, got %v", err)
+	}
+	before, _ := os.ReadFile(path)
+	snapshot, err := LoadFileSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.Get("kept"); err != nil {
+		t.Errorf("Expected the snapshot to hold the secret: %v", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Error("Expected the snapshot to leave the log alone")
+	}
+
+	b.Close()
+	openFileBackend(t, path)
+}
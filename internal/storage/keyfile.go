@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new wrapped key files. The passphrase is only
+// needed once at startup, so it gets three passes instead of one.
+const (
+	keyFileVersion = 1
+	keyFileTime    = 3
+	keyFileMemory  = 64 * 1024
+	keyFileThreads = 4
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase for key file")
+
+// wrappedKeyFile is a key file sealed with a passphrase.
+type wrappedKeyFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"`
+	Threads    uint8  `json:"threads"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// ParseKeyFile reads a keyring from the contents of a key file: base64
+// keys, one per line, current key first. Blank lines and lines starting
+// with # are ignored.
+func ParseKeyFile(data []byte) (*Keyring, error) {
+	var keys [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		v := strings.TrimSpace(sc.Text())
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key file line %d: must be a 32-byte base64-encoded key", line)
+		}
+		keys = append(keys, key)
+	}
+	defer wipe(keys...)
+	if len(keys) == 0 {
+		return nil, errors.New("key file contains no keys")
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+func LoadKeyFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(data)
+	return ParseKeyFile(data)
+}
+
+// SealKeyFile encrypts the contents of a key file with a key derived from
+// passphrase, for OpenKeyFile to unlock at startup.
+func SealKeyFile(keyFile []byte, passphrase string) ([]byte, error) {
+	if _, err := ParseKeyFile(keyFile); err != nil {
+		return nil, err
+	}
+	salt, err := generateSalt()
+	if err != nil {
+		return nil, err
+	}
+	f := wrappedKeyFile{
+		Version: keyFileVersion,
+		KDF:     "argon2id",
+		Time:    keyFileTime,
+		Memory:  keyFileMemory,
+		Threads: keyFileThreads,
+		Salt:    salt,
+		Nonce:   make([]byte, nonceSize),
+	}
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+	key := f.deriveKey(passphrase)
+	defer wipe(key)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	f.Ciphertext = gcm.Seal(nil, f.Nonce, keyFile, f.aad())
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// OpenKeyFile decrypts a file written by SealKeyFile.
+func OpenKeyFile(data []byte, passphrase string) (*Keyring, error) {
+	var f wrappedKeyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid wrapped key file: %w", err)
+	}
+	if f.Version != keyFileVersion || f.KDF != "argon2id" || f.Memory == 0 || f.Time == 0 || f.Threads == 0 || len(f.Nonce) != nonceSize {
+		return nil, errors.New("unsupported wrapped key file")
+	}
+	key := f.deriveKey(passphrase)
+	defer wipe(key)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, f.Nonce, f.Ciphertext, f.aad())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	defer wipe(plain)
+	return ParseKeyFile(plain)
+}
+
+func LoadWrappedKeyFile(path, passphrase string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenKeyFile(data, passphrase)
+}
+
+func (f *wrappedKeyFile) deriveKey(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), f.Salt, f.Time, f.Memory, f.Threads, 32)
+}
+
+// aad binds the KDF parameters, so they cannot be weakened in the file.
+func (f *wrappedKeyFile) aad() []byte {
+	return fmt.Appendf(nil, "whisperbin key file v%d %s t=%d m=%d p=%d", f.Version, f.KDF, f.Time, f.Memory, f.Threads)
+}
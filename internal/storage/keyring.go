@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"whisperbin/internal/secmem"
+)
+
+var ErrUnknownKey = errors.New("secret is encrypted under a key that is not configured")
+
+// KeyProvider holds the master key that per-secret data keys are wrapped
+// with. The store never needs the master key itself, so it can live in
+// an external key management service.
+type KeyProvider interface {
+	// Wrap seals a data key under the current master key and returns the
+	// ID of that key with the result.
+	Wrap(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap opens a data key wrapped under the master key keyID. It
+	// fails with ErrUnknownKey if that key is not available.
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+	// CurrentKeyID names the key Wrap uses.
+	CurrentKeyID() (string, error)
+}
+
+// Keyring is a KeyProvider for keys held in process memory, from
+// SECRET_KEY or a key file. New data keys are wrapped under the current
+// key; previous keys are only kept so that secrets created before a
+// rotation can still be read until they expire or are resealed.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+	order   []string
+}
+
+// NewKeyring returns a keyring that encrypts under current and can also
+// decrypt with each of previous. All keys must be 32 bytes. They are
+// copied into secmem buffers, so callers can wipe their own.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %d must be 32 bytes", i)
+		}
+		id := KeyID(key)
+		if _, ok := k.keys[id]; ok {
+			continue
+		}
+		k.keys[id] = secmem.Clone(key)
+		k.order = append(k.order, id)
+	}
+	k.current = k.order[0]
+	return k, nil
+}
+
+// RandomKeyring returns a keyring with a single random key that only
+// lives as long as the process.
+func RandomKeyring() (*Keyring, error) {
+	key := secmem.Alloc(32)
+	defer secmem.Free(key)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewKeyring(key)
+}
+
+// ParseKeyring reads a base64 current key and a comma-separated list of
+// base64 previous keys, as in SECRET_KEY and SECRET_KEYS_PREVIOUS.
+func ParseKeyring(current, previous string) (*Keyring, error) {
+	cur, err := base64.StdEncoding.DecodeString(current)
+	if err != nil || len(cur) != 32 {
+		return nil, errors.New("invalid SECRET_KEY: must be 32-byte base64-encoded")
+	}
+	var prev [][]byte
+	for _, v := range strings.Split(previous, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("invalid SECRET_KEYS_PREVIOUS: each key must be 32-byte base64-encoded")
+		}
+		prev = append(prev, key)
+	}
+	defer wipe(append(prev, cur)...)
+	return NewKeyring(cur, prev...)
+}
+
+// KeyID names a key by a short fingerprint, so that the ID stored with a
+// secret reveals nothing about the key itself.
+func KeyID(key []byte) string {
+	buf := append([]byte("whisperbin key id\x00"), key...)
+	defer wipe(buf)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k *Keyring) Wrap(dataKey []byte) (string, []byte, error) {
+	wrapped, err := wrapKey(dataKey, k.keys[k.current], k.current)
+	return k.current, wrapped, err
+}
+
+func (k *Keyring) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return unwrapKey(wrapped, key, keyID)
+}
+
+func (k *Keyring) CurrentKeyID() (string, error) {
+	return k.current, nil
+}
+
+// candidates returns the keys a record from before envelope encryption
+// may be encrypted under. Records from before key IDs have none and are
+// tried against every key, current first.
+func (k *Keyring) candidates(id string) ([][]byte, error) {
+	if id != "" {
+		key, ok := k.keys[id]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return [][]byte{key}, nil
+	}
+	keys := make([][]byte, 0, len(k.order))
+	for _, id := range k.order {
+		keys = append(keys, k.keys[id])
+	}
+	return keys, nil
+}
@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"whisperbin/internal"
+)
+
+type memoryEntry struct {
+	secret      *Secret
+	waitingCh   chan struct{}
+	listenerSet bool
+	// announced records that a listener has been reported to onWait.
+	announced bool
+}
+
+type MemoryBackend struct {
+	mu               sync.Mutex
+	secrets          map[string]*memoryEntry
+	confirmFailures  map[string]map[string]int
+	confirmBlockedAt map[string]map[string]time.Time
+	receipts         map[string]*Receipt
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		secrets:          make(map[string]*memoryEntry),
+		confirmFailures:  make(map[string]map[string]int),
+		confirmBlockedAt: make(map[string]map[string]time.Time),
+		receipts:         make(map[string]*Receipt),
+	}
+}
+
+func (m *MemoryBackend) Save(id string, sec *Secret) error {
+	entry := &memoryEntry{secret: sec}
+	if !sec.Unlocked {
+		entry.waitingCh = make(chan struct{})
+	}
+
+	m.mu.Lock()
+	m.secrets[id] = entry
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryBackend) Get(id string) (*Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.lookup(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return entry.secret, nil
+}
+
+func (m *MemoryBackend) Take(id string) (*Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.lookup(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Get hands out the stored pointer, so change a copy and swap it in.
+	cp := *entry.secret
+	if left := cp.ViewsLeft() - 1; left > 0 {
+		cp.Views = left
+		stored := cp
+		entry.secret = &stored
+		return &cp, nil
+	}
+	m.remove(id)
+	cp.Views = 0
+	return &cp, nil
+}
+
+func (m *MemoryBackend) Delete(id string) error {
+	m.mu.Lock()
+	m.remove(id)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryBackend) Confirm(id, inputCode, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	if m.isBlocked(id, ip) {
+		return ErrBlocked
+	}
+
+	sec := entry.secret
+	if subtle.ConstantTimeCompare([]byte(sec.Code), []byte(inputCode)) != 1 {
+		m.incrementFailure(id, ip)
+		return ErrInvalidCode
+	}
+
+	if sec.Code == "" || entry.waitingCh == nil || !entry.listenerSet {
+		return ErrNoRecipient
+	}
+	if sec.Unlocked {
+		return ErrAlreadyUnlocked
+	}
+	sec.Unlocked = true
+	close(entry.waitingCh)
+	entry.waitingCh = nil
+
+	m.resetFailures(id, ip)
+	return nil
+}
+
+func (m *MemoryBackend) WaitForUnlock(ctx context.Context, id string, onWait func(*Secret)) (*Secret, error) {
+	m.mu.Lock()
+	entry, ok := m.lookup(id)
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrNotFound
+	}
+
+	if err := canWait(entry.waitingCh != nil, entry.listenerSet); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	entry.listenerSet = true
+	first := !entry.announced
+	entry.announced = true
+	sec := entry.secret
+	ch := entry.waitingCh
+	m.mu.Unlock()
+
+	if first && onWait != nil {
+		onWait(sec)
+	}
+
+	timer := time.NewTimer(time.Until(sec.ExpiresAt))
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return entry.secret, nil
+	case <-ctx.Done():
+		m.releaseListener(id)
+		return nil, ctx.Err()
+	case <-timer.C:
+		m.releaseListener(id)
+		return nil, ErrNotFound
+	}
+}
+
+func (m *MemoryBackend) Fulfill(id string, sec *Secret) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.lookup(id)
+	if !ok || !entry.secret.Awaiting {
+		return ErrNotFound
+	}
+	entry.secret = sec
+	if entry.waitingCh != nil {
+		close(entry.waitingCh)
+		entry.waitingCh = nil
+	}
+	return nil
+}
+
+func (m *MemoryBackend) IDs() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.secrets))
+	for id := range m.secrets {
+		if _, ok := m.lookup(id); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Reseal swaps in a copy, since Get hands out the stored record.
+func (m *MemoryBackend) Reseal(id string, sec *Secret) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.lookup(id)
+	if !ok {
+		return ErrNotFound
+	}
+	cp := *entry.secret
+	cp.setSealed(sec)
+	entry.secret = &cp
+	return nil
+}
+
+func (m *MemoryBackend) IsWaiting(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.lookup(id)
+	if !ok {
+		return false, ErrNotFound
+	}
+	waiting := entry.waitingCh != nil && !entry.secret.Unlocked
+	return waiting, nil
+}
+
+func (m *MemoryBackend) RecordFailure(id, ip string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lookup(id); !ok {
+		return 0, ErrNotFound
+	}
+	m.incrementFailure(id, ip)
+	total := 0
+	for _, n := range m.confirmFailures[id] {
+		total += n
+	}
+	return total, nil
+}
+
+func (m *MemoryBackend) Blocked(id, ip string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isBlocked(id, ip), nil
+}
+
+func (m *MemoryBackend) ResetFailures(id, ip string) error {
+	m.mu.Lock()
+	m.resetFailures(id, ip)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryBackend) SaveReceipt(id string, rec *Receipt) error {
+	m.mu.Lock()
+	m.receipts[id] = rec.clone()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryBackend) GetReceipt(id string) (*Receipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.receipts[id]
+	if !ok || time.Now().After(rec.RetainUntil) {
+		return nil, ErrNotFound
+	}
+	return rec.clone(), nil
+}
+
+func (m *MemoryBackend) MarkViewed(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.receipts[id]; ok {
+		rec.ViewedAt = append(rec.ViewedAt, at)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) CleanupExpired() map[string]*Secret {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	expired := make(map[string]*Secret)
+	for id, entry := range m.secrets {
+		if now.After(entry.secret.ExpiresAt) {
+			expired[id] = entry.secret
+			m.remove(id)
+		}
+	}
+	for id, rec := range m.receipts {
+		if now.After(rec.RetainUntil) {
+			delete(m.receipts, id)
+		}
+	}
+	return expired
+}
+
+func (m *MemoryBackend) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.secrets) + len(m.receipts)
+}
+
+func (m *MemoryBackend) receiptSnapshot() map[string]*Receipt {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	live := make(map[string]*Receipt, len(m.receipts))
+	for id, rec := range m.receipts {
+		if !now.After(rec.RetainUntil) {
+			live[id] = rec.clone()
+		}
+	}
+	return live
+}
+
+func (m *MemoryBackend) snapshot() map[string]*Secret {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	live := make(map[string]*Secret, len(m.secrets))
+	for id, entry := range m.secrets {
+		if !now.After(entry.secret.ExpiresAt) {
+			cp := *entry.secret
+			live[id] = &cp
+		}
+	}
+	return live
+}
+
+func (m *MemoryBackend) lookup(id string) (*memoryEntry, bool) {
+	entry, ok := m.secrets[id]
+	if !ok || time.Now().After(entry.secret.ExpiresAt) {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (m *MemoryBackend) remove(id string) {
+	delete(m.secrets, id)
+	delete(m.confirmFailures, id)
+	delete(m.confirmBlockedAt, id)
+}
+
+func (m *MemoryBackend) releaseListener(id string) {
+	m.mu.Lock()
+	if entry, ok := m.secrets[id]; ok {
+		entry.listenerSet = false
+	}
+	m.mu.Unlock()
+}
+
+func (m *MemoryBackend) incrementFailure(id, ip string) {
+	if m.confirmFailures[id] == nil {
+		m.confirmFailures[id] = make(map[string]int)
+	}
+	m.confirmFailures[id][ip]++
+	if m.confirmFailures[id][ip] >= internal.MaxCodeFailures {
+		if m.confirmBlockedAt[id] == nil {
+			m.confirmBlockedAt[id] = make(map[string]time.Time)
+		}
+		m.confirmBlockedAt[id][ip] = time.Now().Add(internal.BlockDuration)
+	}
+}
+
+func (m *MemoryBackend) resetFailures(id, ip string) {
+	if m.confirmFailures[id] != nil {
+		delete(m.confirmFailures[id], ip)
+	}
+	if m.confirmBlockedAt[id] != nil {
+		delete(m.confirmBlockedAt[id], ip)
+	}
+}
+
+func (m *MemoryBackend) isBlocked(id, ip string) bool {
+	blockMap, exists := m.confirmBlockedAt[id]
+	if !exists {
+		return false
+	}
+	blockTime, blocked := blockMap[ip]
+	if !blocked {
+		return false
+	}
+	if time.Now().After(blockTime) {
+		delete(blockMap, ip)
+		return false
+	}
+	return true
+}
+
+func canWait(secure, listenerSet bool) error {
+	if !secure {
+		return ErrNotSecure
+	}
+	if listenerSet {
+		return ErrListenerConnected
+	}
+	return nil
+}
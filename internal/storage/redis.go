@@ -0,0 +1,495 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"whisperbin/internal"
+)
+
+const (
+	redisKeyPrefix = "whisperbin:"
+
+	// A waiting recipient holds the listener key with a short lease that is
+	// renewed while connected, so a crashed replica cannot block the
+	// recipient from reconnecting elsewhere for the whole TTL.
+	redisListenerLease = 30 * time.Second
+
+	// Secret records outlive their ExpiresAt by this much, so that
+	// CleanupExpired can still report them. Reads check ExpiresAt.
+	redisExpiryGrace = 2 * internal.CleanupInterval
+)
+
+// RedisBackend stores secrets in Redis so that several replicas can share
+// them. Expiry uses native key TTLs, reveals decrement a view counter in
+// the same transaction that reads the secret, and Confirm wakes a
+// WaitForUnlock on any replica through pub/sub.
+type RedisBackend struct {
+	client *redisClient
+}
+
+func NewRedisBackend(rawURL string) (*RedisBackend, error) {
+	client, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.Do("PING"); err != nil {
+		return nil, err
+	}
+	return &RedisBackend{client: client}, nil
+}
+
+func (r *RedisBackend) Save(id string, sec *Secret) error {
+	data, err := json.Marshal(persistedSecret(sec))
+	if err != nil {
+		return err
+	}
+	expiresAt := unixMilli(sec.ExpiresAt)
+	_, err = r.client.Tx(
+		[]string{"SET", secretKey(id), string(data), "PXAT", unixMilli(sec.ExpiresAt.Add(redisExpiryGrace))},
+		[]string{"SET", viewsKey(id), strconv.Itoa(sec.ViewsLeft()), "PXAT", expiresAt},
+		[]string{"ZADD", expiryKey, expiresAt, id},
+	)
+	return err
+}
+
+func (r *RedisBackend) Get(id string) (*Secret, error) {
+	reply, err := r.client.Do("MGET", secretKey(id), unlockedKey(id), viewsKey(id))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	if len(items) != 3 {
+		return nil, ErrNotFound
+	}
+	sec, err := decodeRedisSecret(items[0], items[1])
+	if err != nil {
+		return nil, err
+	}
+	if views, err := strconv.Atoi(string(asBytes(items[2]))); err == nil {
+		sec.Views = views
+	}
+	return sec, nil
+}
+
+// Take decrements the view counter and reads the secret in one
+// transaction. Only the caller that brings the counter to zero deletes
+// the keys; anyone who drives it below zero lost the race.
+func (r *RedisBackend) Take(id string) (*Secret, error) {
+	replies, err := r.client.Tx(
+		[]string{"DECR", viewsKey(id)},
+		[]string{"GET", secretKey(id)},
+		[]string{"GET", unlockedKey(id)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	left, ok := replies[0].(int64)
+	if !ok {
+		return nil, errors.New("redis: unexpected DECR reply")
+	}
+	if left < 0 {
+		// DECR on a missing key leaves a -1 behind without a TTL.
+		r.client.Do("DEL", viewsKey(id))
+		return nil, ErrNotFound
+	}
+	if left == 0 {
+		if err := r.Delete(id); err != nil {
+			return nil, err
+		}
+	}
+	sec, err := decodeRedisSecret(replies[1], replies[2])
+	if err != nil {
+		return nil, err
+	}
+	sec.Views = int(left)
+	return sec, nil
+}
+
+func (r *RedisBackend) Delete(id string) error {
+	_, err := r.client.Tx(
+		[]string{"DEL", secretKey(id), unlockedKey(id), listenerKey(id), announcedKey(id), viewsKey(id), failTotalKey(id)},
+		[]string{"ZREM", expiryKey, id},
+	)
+	return err
+}
+
+func (r *RedisBackend) Confirm(id, inputCode, ip string) error {
+	sec, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := r.Blocked(id, ip)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	if subtle.ConstantTimeCompare([]byte(sec.Code), []byte(inputCode)) != 1 {
+		if err := r.incrementFailure(id, ip, sec.ExpiresAt); err != nil {
+			return err
+		}
+		return ErrInvalidCode
+	}
+
+	if sec.Code == "" {
+		return ErrNoRecipient
+	}
+	listening, err := r.client.Do("EXISTS", listenerKey(id))
+	if err != nil {
+		return err
+	}
+	if listening.(int64) == 0 {
+		return ErrNoRecipient
+	}
+	if sec.Unlocked {
+		return ErrAlreadyUnlocked
+	}
+
+	set, err := r.client.Do("SET", unlockedKey(id), "1", "NX", "PXAT", unixMilli(sec.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		return ErrAlreadyUnlocked
+	}
+	if _, err := r.client.Do("PUBLISH", unlockChannel(id), "unlocked"); err != nil {
+		return err
+	}
+
+	r.ResetFailures(id, ip)
+	return nil
+}
+
+func (r *RedisBackend) WaitForUnlock(ctx context.Context, id string, onWait func(*Secret)) (*Secret, error) {
+	sec, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if (sec.Code == "" && !sec.Awaiting) || sec.Unlocked {
+		return nil, ErrNotSecure
+	}
+
+	// Subscribe before claiming the listener slot so that an unlock
+	// published right after the claim cannot be missed.
+	sub, err := r.client.Subscribe(unlockChannel(id))
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	claimed, err := r.client.Do("SET", listenerKey(id), "1", "NX", "PX", strconv.FormatInt(redisListenerLease.Milliseconds(), 10))
+	if err != nil {
+		return nil, err
+	}
+	if claimed == nil {
+		return nil, ErrListenerConnected
+	}
+	// Fulfill publishes whether or not anyone listens, so a drop that
+	// landed before the subscription is only seen by reading again.
+	if cur, err := r.Get(id); err != nil || cur.Unlocked || (sec.Awaiting && !cur.Awaiting) {
+		r.client.Do("DEL", listenerKey(id))
+		return cur, err
+	}
+	// The flag outlives the listener lease, so reconnects on any replica
+	// are not reported again.
+	if first, err := r.client.Do("SET", announcedKey(id), "1", "NX", "PXAT", unixMilli(sec.ExpiresAt)); err == nil && first != nil && onWait != nil {
+		onWait(sec)
+	}
+
+	msgs := make(chan error, 1)
+	go func() {
+		_, err := sub.Receive()
+		msgs <- err
+	}()
+
+	timer := time.NewTimer(time.Until(sec.ExpiresAt))
+	defer timer.Stop()
+	renew := time.NewTicker(redisListenerLease / 3)
+	defer renew.Stop()
+
+	for {
+		select {
+		case err := <-msgs:
+			if err != nil {
+				r.client.Do("DEL", listenerKey(id))
+				return nil, err
+			}
+			return r.Get(id)
+		case <-renew.C:
+			r.client.Do("PEXPIRE", listenerKey(id), strconv.FormatInt(redisListenerLease.Milliseconds(), 10))
+		case <-ctx.Done():
+			r.client.Do("DEL", listenerKey(id))
+			return nil, ctx.Err()
+		case <-timer.C:
+			r.client.Do("DEL", listenerKey(id))
+			return nil, ErrNotFound
+		}
+	}
+}
+
+func (r *RedisBackend) IsWaiting(id string) (bool, error) {
+	sec, err := r.Get(id)
+	if err != nil {
+		return false, err
+	}
+	return (sec.Code != "" || sec.Awaiting) && !sec.Unlocked, nil
+}
+
+// Fulfill only overwrites keys that still exist, so a request that was
+// revoked or expired in the meantime is not brought back.
+func (r *RedisBackend) Fulfill(id string, sec *Secret) error {
+	cur, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	if !cur.Awaiting {
+		return ErrNotFound
+	}
+	data, err := json.Marshal(persistedSecret(sec))
+	if err != nil {
+		return err
+	}
+	expiresAt := unixMilli(sec.ExpiresAt)
+	replies, err := r.client.Tx(
+		[]string{"SET", secretKey(id), string(data), "XX", "PXAT", unixMilli(sec.ExpiresAt.Add(redisExpiryGrace))},
+		[]string{"SET", viewsKey(id), strconv.Itoa(sec.ViewsLeft()), "XX", "PXAT", expiresAt},
+	)
+	if err != nil {
+		return err
+	}
+	if replies[0] == nil {
+		return ErrNotFound
+	}
+	_, err = r.client.Do("PUBLISH", unlockChannel(id), "unlocked")
+	return err
+}
+
+// IDs reads the expiry index, which holds every secret until it is
+// reported as expired.
+func (r *RedisBackend) IDs() ([]string, error) {
+	reply, err := r.client.Do("ZRANGEBYSCORE", expiryKey, "("+unixMilli(time.Now()), "+inf")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, string(asBytes(item)))
+	}
+	return ids, nil
+}
+
+// Reseal rewrites the record only while it exists and keeps its TTL. The
+// view counter and unlock flag live in their own keys and are untouched.
+func (r *RedisBackend) Reseal(id string, sec *Secret) error {
+	data, err := r.client.Do("GET", secretKey(id))
+	if err != nil {
+		return err
+	}
+	if asBytes(data) == nil {
+		return ErrNotFound
+	}
+	var cur Secret
+	if err := json.Unmarshal(asBytes(data), &cur); err != nil {
+		return err
+	}
+	cur.setSealed(sec)
+	updated, err := json.Marshal(&cur)
+	if err != nil {
+		return err
+	}
+	reply, err := r.client.Do("SET", secretKey(id), string(updated), "XX", "KEEPTTL")
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *RedisBackend) RecordFailure(id, ip string) (int, error) {
+	sec, err := r.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.incrementFailure(id, ip, sec.ExpiresAt); err != nil {
+		return 0, err
+	}
+	replies, err := r.client.Tx(
+		[]string{"INCR", failTotalKey(id)},
+		[]string{"PEXPIREAT", failTotalKey(id), unixMilli(sec.ExpiresAt)},
+	)
+	if err != nil {
+		return 0, err
+	}
+	total, _ := replies[0].(int64)
+	return int(total), nil
+}
+
+func (r *RedisBackend) Blocked(id, ip string) (bool, error) {
+	reply, err := r.client.Do("EXISTS", blockKey(id, ip))
+	if err != nil {
+		return false, err
+	}
+	return reply.(int64) > 0, nil
+}
+
+func (r *RedisBackend) ResetFailures(id, ip string) error {
+	_, err := r.client.Do("DEL", failKey(id, ip), blockKey(id, ip))
+	return err
+}
+
+// SaveReceipt stores everything but ViewedAt, which only grows through
+// MarkViewed so that reveals on different replicas cannot overwrite each
+// other.
+func (r *RedisBackend) SaveReceipt(id string, rec *Receipt) error {
+	cp := *rec
+	cp.ViewedAt = nil
+	data, err := json.Marshal(&cp)
+	if err != nil {
+		return err
+	}
+	retainUntil := unixMilli(rec.RetainUntil)
+	_, err = r.client.Tx(
+		[]string{"SET", receiptKey(id), string(data), "PXAT", retainUntil},
+		[]string{"PEXPIREAT", receiptViewsKey(id), retainUntil},
+	)
+	return err
+}
+
+func (r *RedisBackend) GetReceipt(id string) (*Receipt, error) {
+	replies, err := r.client.Tx(
+		[]string{"GET", receiptKey(id)},
+		[]string{"LRANGE", receiptViewsKey(id), "0", "-1"},
+	)
+	if err != nil {
+		return nil, err
+	}
+	raw := asBytes(replies[0])
+	if raw == nil {
+		return nil, ErrNotFound
+	}
+	var rec Receipt
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, err
+	}
+	views, _ := replies[1].([]interface{})
+	for _, v := range views {
+		at, err := time.Parse(time.RFC3339Nano, string(asBytes(v)))
+		if err != nil {
+			return nil, err
+		}
+		rec.ViewedAt = append(rec.ViewedAt, at)
+	}
+	return &rec, nil
+}
+
+func (r *RedisBackend) MarkViewed(id string, at time.Time) error {
+	rec, err := r.GetReceipt(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Tx(
+		[]string{"RPUSH", receiptViewsKey(id), at.UTC().Format(time.RFC3339Nano)},
+		[]string{"PEXPIREAT", receiptViewsKey(id), unixMilli(rec.RetainUntil)},
+	)
+	return err
+}
+
+// CleanupExpired reports secrets from the expiry index whose time has
+// passed. Redis drops the keys on its own; the index lets exactly one
+// replica, the one whose ZREM succeeds, report each secret.
+func (r *RedisBackend) CleanupExpired() map[string]*Secret {
+	expired := make(map[string]*Secret)
+	reply, err := r.client.Do("ZRANGEBYSCORE", expiryKey, "-inf", unixMilli(time.Now()), "LIMIT", "0", "100")
+	if err != nil {
+		return expired
+	}
+	items, _ := reply.([]interface{})
+	for _, item := range items {
+		id := string(asBytes(item))
+		removed, err := r.client.Do("ZREM", expiryKey, id)
+		if n, _ := removed.(int64); err != nil || n == 0 {
+			continue
+		}
+		var sec *Secret
+		if data, err := r.client.Do("GETDEL", secretKey(id)); err == nil && asBytes(data) != nil {
+			sec = new(Secret)
+			if json.Unmarshal(asBytes(data), sec) != nil {
+				sec = nil
+			}
+		}
+		r.Delete(id)
+		expired[id] = sec
+	}
+	return expired
+}
+
+func (r *RedisBackend) Close() error {
+	return r.client.Close()
+}
+
+func (r *RedisBackend) incrementFailure(id, ip string, expiresAt time.Time) error {
+	reply, err := r.client.Do("INCR", failKey(id, ip))
+	if err != nil {
+		return err
+	}
+	if _, err := r.client.Do("PEXPIREAT", failKey(id, ip), unixMilli(expiresAt)); err != nil {
+		return err
+	}
+	if reply.(int64) >= internal.MaxCodeFailures {
+		_, err := r.client.Do("SET", blockKey(id, ip), "1", "PX", strconv.FormatInt(internal.BlockDuration.Milliseconds(), 10))
+		return err
+	}
+	return nil
+}
+
+func decodeRedisSecret(data, unlocked interface{}) (*Secret, error) {
+	raw := asBytes(data)
+	if raw == nil {
+		return nil, ErrNotFound
+	}
+	var sec Secret
+	if err := json.Unmarshal(raw, &sec); err != nil {
+		return nil, err
+	}
+	if time.Now().After(sec.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	if asBytes(unlocked) != nil {
+		sec.Unlocked = true
+	}
+	return &sec, nil
+}
+
+func unixMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+const expiryKey = redisKeyPrefix + "expiry"
+
+func secretKey(id string) string     { return redisKeyPrefix + "secret:" + id }
+func unlockedKey(id string) string   { return redisKeyPrefix + "unlocked:" + id }
+func listenerKey(id string) string   { return redisKeyPrefix + "listener:" + id }
+func announcedKey(id string) string  { return redisKeyPrefix + "announced:" + id }
+func viewsKey(id string) string      { return redisKeyPrefix + "views:" + id }
+func unlockChannel(id string) string { return redisKeyPrefix + "unlock:" + id }
+func failKey(id, ip string) string   { return redisKeyPrefix + "fail:" + id + ":" + ip }
+func blockKey(id, ip string) string  { return redisKeyPrefix + "block:" + id + ":" + ip }
+func failTotalKey(id string) string  { return redisKeyPrefix + "failtotal:" + id }
+func receiptKey(id string) string    { return redisKeyPrefix + "receipt:" + id }
+func receiptViewsKey(id string) string {
+	return redisKeyPrefix + "receipt-views:" + id
+}
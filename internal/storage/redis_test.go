@@ -0,0 +1,498 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedisBackend_Conformance(t *testing.T) {
+	testBackendConformance(t, func(t *testing.T) Backend {
+		return newTestRedisBackend(t, startFakeRedis(t))
+	})
+}
+
+func TestRedisBackend_UnlockAcrossReplicas(t *testing.T) {
+	addr := startFakeRedis(t)
+	replicaA := newTestRedisBackend(t, addr)
+	replicaB := newTestRedisBackend(t, addr)
+
+	if err := replicaA.Save("shared", secureSecret(time.Minute)); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	result := make(chan *Secret, 1)
+	go func() {
+		sec, err := replicaA.WaitForUnlock(context.Background(), "shared", nil)
+		if err != nil {
+			t.Errorf("WaitForUnlock failed: %v", err)
+		}
+		result <- sec
+	}()
+
+	confirmWhenListening(t, replicaB, "shared", "123456")
+
+	select {
+	case sec := <-result:
+		if sec == nil || !sec.Unlocked {
+			t.Errorf("Expected unlocked secret on replica A, got %+v", sec)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Replica A was not woken by Confirm on replica B")
+	}
+
+	if _, err := replicaB.Take("shared"); err != nil {
+		t.Errorf("Expected replica B to see the unlocked secret: %v", err)
+	}
+	if _, err := replicaA.Get("shared"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected secret to be gone on replica A, got %v", err)
+	}
+}
+
+func TestRedisBackend_FulfillBeforeSubscribe(t *testing.T) {
+	srv, addr := startFakeRedisServer(t)
+	requester := newTestRedisBackend(t, addr)
+	holder := newTestRedisBackend(t, addr)
+	requester.Save("requested", awaitingSecret(time.Minute))
+
+	var once sync.Once
+	srv.beforeSubscribe = func() {
+		once.Do(func() {
+			if err := holder.Fulfill("requested", plainSecret(time.Minute)); err != nil {
+				t.Errorf("Fulfill failed: %v", err)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	sec, err := requester.WaitForUnlock(ctx, "requested", nil)
+	if err != nil {
+		t.Fatalf("Expected a drop before the subscription to be seen, got %v", err)
+	}
+	if sec.Awaiting || sec.CipherText != "cipher" {
+		t.Errorf("Unexpected fulfilled secret: %+v", sec)
+	}
+}
+
+func TestRedisBackend_TakeFailsOnCommandError(t *testing.T) {
+	b := newTestRedisBackend(t, startFakeRedis(t))
+	b.Save("broken", plainSecret(time.Minute))
+	b.client.Do("SET", viewsKey("broken"), "not a number", "KEEPTTL")
+
+	if _, err := b.Take("broken"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected the failed DECR to be reported, got %v", err)
+	}
+	if n, err := b.client.Do("EXISTS", secretKey("broken")); err != nil || n.(int64) != 1 {
+		t.Errorf("Expected the secret to survive a failed Take, got %v, %v", n, err)
+	}
+}
+
+func TestRedisClient_TimesOutStalledServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Read commands but never answer.
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	client, err := newRedisClient("redis://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.timeout = 50 * time.Millisecond
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Do("PING")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error from a server that never answers")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the command to time out")
+	}
+}
+
+func TestRedisBackend_UsesNativeTTL(t *testing.T) {
+	addr := startFakeRedis(t)
+	b := newTestRedisBackend(t, addr)
+	b.Save("ttl", plainSecret(time.Minute))
+
+	reply, err := b.client.Do("PTTL", secretKey("ttl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms := reply.(int64); ms <= 0 || ms > (time.Minute+redisExpiryGrace).Milliseconds() {
+		t.Errorf("Expected key TTL within one minute plus the cleanup grace, got %dms", ms)
+	}
+	reply, _ = b.client.Do("PTTL", viewsKey("ttl"))
+	if ms := reply.(int64); ms <= 0 || ms > time.Minute.Milliseconds() {
+		t.Errorf("Expected view counter TTL within one minute, got %dms", ms)
+	}
+}
+
+func newTestRedisBackend(t *testing.T, addr string) *RedisBackend {
+	t.Helper()
+	b, err := NewRedisBackend("redis://" + addr)
+	if err != nil {
+		t.Fatalf("NewRedisBackend failed: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// fakeRedis is a minimal in-process stand-in for the subset of Redis used by
+// RedisBackend.
+type fakeRedis struct {
+	mu      sync.Mutex
+	data    map[string]string
+	lists   map[string][]string
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+	subs    map[string][]*fakeRedisConn
+
+	// beforeSubscribe, if set, runs before a SUBSCRIBE is handled.
+	beforeSubscribe func()
+}
+
+type fakeRedisConn struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	multi  bool
+	queued [][]string
+}
+
+func startFakeRedis(t *testing.T) string {
+	t.Helper()
+	_, addr := startFakeRedisServer(t)
+	return addr
+}
+
+func startFakeRedisServer(t *testing.T) (*fakeRedis, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := &fakeRedis{
+		data:    make(map[string]string),
+		lists:   make(map[string][]string),
+		zsets:   make(map[string]map[string]float64),
+		expires: make(map[string]time.Time),
+		subs:    make(map[string][]*fakeRedisConn),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv, ln.Addr().String()
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	c := &fakeRedisConn{w: bufio.NewWriter(conn)}
+	defer s.unsubscribe(c)
+
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+
+		if c.multi && name != "EXEC" {
+			c.queued = append(c.queued, args)
+			c.write("+QUEUED\r\n")
+			continue
+		}
+
+		switch name {
+		case "MULTI":
+			c.multi = true
+			c.write("+OK\r\n")
+		case "EXEC":
+			s.mu.Lock()
+			replies := make([]string, len(c.queued))
+			for i, cmd := range c.queued {
+				replies[i] = s.exec(cmd)
+			}
+			s.mu.Unlock()
+			c.multi, c.queued = false, nil
+			c.write(fmt.Sprintf("*%d\r\n%s", len(replies), strings.Join(replies, "")))
+		case "SUBSCRIBE":
+			if s.beforeSubscribe != nil {
+				s.beforeSubscribe()
+			}
+			s.mu.Lock()
+			s.subs[args[1]] = append(s.subs[args[1]], c)
+			s.mu.Unlock()
+			c.write(fmt.Sprintf("*3\r\n%s%s:1\r\n", bulk("subscribe"), bulk(args[1])))
+		case "PUBLISH":
+			s.mu.Lock()
+			subs := append([]*fakeRedisConn(nil), s.subs[args[1]]...)
+			s.mu.Unlock()
+			for _, sub := range subs {
+				sub.write(fmt.Sprintf("*3\r\n%s%s%s", bulk("message"), bulk(args[1]), bulk(args[2])))
+			}
+			c.write(fmt.Sprintf(":%d\r\n", len(subs)))
+		default:
+			s.mu.Lock()
+			reply := s.exec(args)
+			s.mu.Unlock()
+			c.write(reply)
+		}
+	}
+}
+
+func (s *fakeRedis) unsubscribe(c *fakeRedisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch, subs := range s.subs {
+		for i, sub := range subs {
+			if sub == c {
+				s.subs[ch] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// exec runs a data command. The caller must hold s.mu.
+func (s *fakeRedis) exec(args []string) string {
+	for key, at := range s.expires {
+		if !time.Now().Before(at) {
+			delete(s.data, key)
+			delete(s.lists, key)
+			delete(s.expires, key)
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		return s.get(args[1])
+	case "GETDEL":
+		reply := s.get(args[1])
+		delete(s.data, args[1])
+		delete(s.expires, args[1])
+		return reply
+	case "MGET":
+		out := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			out += s.get(key)
+		}
+		return out
+	case "SET":
+		key, val := args[1], args[2]
+		var expireAt time.Time
+		keepTTL := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "KEEPTTL":
+				keepTTL = true
+			case "NX":
+				if _, ok := s.data[key]; ok {
+					return "$-1\r\n"
+				}
+			case "XX":
+				if _, ok := s.data[key]; !ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				ms, _ := strconv.ParseInt(args[i+1], 10, 64)
+				expireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				i++
+			case "PXAT":
+				ms, _ := strconv.ParseInt(args[i+1], 10, 64)
+				expireAt = time.UnixMilli(ms)
+				i++
+			}
+		}
+		s.data[key] = val
+		if !keepTTL {
+			delete(s.expires, key)
+		}
+		if !expireAt.IsZero() {
+			s.expires[key] = expireAt
+		}
+		return "+OK\r\n"
+	case "DEL", "EXISTS":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				n++
+				if strings.ToUpper(args[0]) == "DEL" {
+					delete(s.data, key)
+					delete(s.expires, key)
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "INCR", "DECR":
+		n := 0
+		if v, ok := s.data[args[1]]; ok {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				return "-ERR value is not an integer or out of range\r\n"
+			}
+		}
+		if strings.ToUpper(args[0]) == "INCR" {
+			n++
+		} else {
+			n--
+		}
+		s.data[args[1]] = strconv.Itoa(n)
+		return fmt.Sprintf(":%d\r\n", n)
+	case "RPUSH":
+		s.lists[args[1]] = append(s.lists[args[1]], args[2:]...)
+		return fmt.Sprintf(":%d\r\n", len(s.lists[args[1]]))
+	case "LRANGE":
+		items := s.lists[args[1]]
+		out := fmt.Sprintf("*%d\r\n", len(items))
+		for _, item := range items {
+			out += bulk(item)
+		}
+		return out
+	case "ZADD":
+		if s.zsets[args[1]] == nil {
+			s.zsets[args[1]] = make(map[string]float64)
+		}
+		score, _ := strconv.ParseFloat(args[2], 64)
+		_, exists := s.zsets[args[1]][args[3]]
+		s.zsets[args[1]][args[3]] = score
+		if exists {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+	case "ZREM":
+		n := 0
+		for _, member := range args[2:] {
+			if _, ok := s.zsets[args[1]][member]; ok {
+				delete(s.zsets[args[1]], member)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "ZRANGEBYSCORE":
+		min, minExclusive := scoreBound(args[2])
+		max, _ := scoreBound(args[3])
+		var members []string
+		for member, score := range s.zsets[args[1]] {
+			if score <= max && (score > min || !minExclusive && score == min) {
+				members = append(members, member)
+			}
+		}
+		out := fmt.Sprintf("*%d\r\n", len(members))
+		for _, member := range members {
+			out += bulk(member)
+		}
+		return out
+	case "PEXPIRE", "PEXPIREAT":
+		_, isString := s.data[args[1]]
+		_, isList := s.lists[args[1]]
+		if !isString && !isList {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		if strings.ToUpper(args[0]) == "PEXPIRE" {
+			s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		} else {
+			s.expires[args[1]] = time.UnixMilli(ms)
+		}
+		return ":1\r\n"
+	case "PTTL":
+		at, ok := s.expires[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(at).Milliseconds())
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func (s *fakeRedis) get(key string) string {
+	val, ok := s.data[key]
+	if !ok {
+		return "$-1\r\n"
+	}
+	return bulk(val)
+}
+
+func (c *fakeRedisConn) write(reply string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.WriteString(reply)
+	c.w.Flush()
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad command header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// scoreBound parses a ZRANGEBYSCORE bound such as "-inf", "(123" or "123".
+func scoreBound(arg string) (float64, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	v, _ := strconv.ParseFloat(strings.TrimPrefix(arg, "("), 64)
+	return v, exclusive
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	// redisIOTimeout bounds each command, so a stalled server fails the
+	// request instead of hanging it.
+	redisIOTimeout = 5 * time.Second
+	// redisMaxIdle is how many idle connections the pool keeps open.
+	redisMaxIdle = 16
+)
+
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn speaks just enough RESP2 for the Redis backend.
+type redisConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func (c *redisConn) send(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return c.w.Flush()
+}
+
+// do sends one command and reads its reply within c.timeout.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// newRedisClient parses a URL of the form redis://[:password@]host:port[/db].
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("redis: unsupported scheme %q", u.Scheme)
+	}
+
+	c := &redisClient{addr: u.Host, timeout: redisIOTimeout}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid database %q", db)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), timeout: c.timeout}
+
+	if c.password != "" {
+		if err := rc.expectOK("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if err := rc.expectOK("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (c *redisConn) expectOK(args ...string) error {
+	reply, err := c.do(args...)
+	if err != nil {
+		return err
+	}
+	if rerr, ok := reply.(redisError); ok {
+		return rerr
+	}
+	return nil
+}
+
+func (c *redisClient) get() (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		rc := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return rc, nil
+	}
+	c.mu.Unlock()
+	return c.dial()
+}
+
+func (c *redisClient) put(rc *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= redisMaxIdle {
+		rc.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, rc)
+}
+
+// Do runs a single command on a pooled connection. Redis error replies are
+// returned as errors.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	rc, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := rc.do(args...)
+	if err != nil {
+		rc.conn.Close()
+		return nil, err
+	}
+	c.put(rc)
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+// Tx runs the commands inside MULTI/EXEC and returns their replies. The
+// first command that failed inside the transaction is returned as an
+// error.
+func (c *redisClient) Tx(cmds ...[]string) ([]interface{}, error) {
+	rc, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(err error) ([]interface{}, error) {
+		rc.conn.Close()
+		return nil, err
+	}
+	if err := rc.expectOK("MULTI"); err != nil {
+		return fail(err)
+	}
+	for _, cmd := range cmds {
+		if err := rc.expectOK(cmd...); err != nil {
+			return fail(err)
+		}
+	}
+	reply, err := rc.do("EXEC")
+	if err != nil {
+		return fail(err)
+	}
+	c.put(rc)
+
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New("redis: transaction aborted")
+	}
+	for _, item := range items {
+		if rerr, ok := item.(redisError); ok {
+			return nil, rerr
+		}
+	}
+	return items, nil
+}
+
+// Subscribe opens a dedicated connection subscribed to channel. The caller
+// must Close the returned connection.
+func (c *redisClient) Subscribe(channel string) (*redisConn, error) {
+	rc, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := rc.do("SUBSCRIBE", channel)
+	if err != nil {
+		rc.conn.Close()
+		return nil, err
+	}
+	if items, ok := reply.([]interface{}); !ok || len(items) != 3 || string(asBytes(items[0])) != "subscribe" {
+		rc.conn.Close()
+		return nil, fmt.Errorf("redis: unexpected subscribe reply %v", reply)
+	}
+	// Messages may be far apart; the caller bounds the wait.
+	if err := rc.conn.SetDeadline(time.Time{}); err != nil {
+		rc.conn.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Receive blocks until the next published message arrives.
+func (c *redisConn) Receive() (string, error) {
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return "", err
+		}
+		items, ok := reply.([]interface{})
+		if ok && len(items) == 3 && string(asBytes(items[0])) == "message" {
+			return string(asBytes(items[2])), nil
+		}
+	}
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rc := range c.idle {
+		rc.conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func asBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	return nil
+}
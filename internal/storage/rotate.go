@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// KeyUsage counts the live secrets encrypted under one instance key. A
+// retired key can be removed from SECRET_KEYS_PREVIOUS once no secrets
+// use it.
+type KeyUsage struct {
+	// KeyID is empty for secrets written before key IDs existed.
+	KeyID   string
+	Current bool
+	Secrets int
+	// Passphrase counts secrets that cannot be resealed: their text only
+	// opens with the sender's passphrase and is bound to this key, either
+	// because they predate envelope encryption or through the key ID in
+	// its authenticated data. The key has to stay configured until
+	// LastExpiry.
+	Passphrase int
+	LastExpiry time.Time
+}
+
+// KeyUsage reports which keys the stored secrets are encrypted under, the
+// current key first.
+func (s *Store) KeyUsage() ([]KeyUsage, error) {
+	ids, err := s.backend.IDs()
+	if err != nil {
+		return nil, err
+	}
+	currentID, err := s.keys.CurrentKeyID()
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]*KeyUsage{currentID: {KeyID: currentID, Current: true}}
+	for _, id := range ids {
+		sec, err := s.backend.Get(id)
+		if err != nil || !sec.hasSealed() {
+			continue
+		}
+		u := usage[sec.KeyID]
+		if u == nil {
+			u = &KeyUsage{KeyID: sec.KeyID}
+			usage[sec.KeyID] = u
+		}
+		u.Secrets++
+		if !sec.canReseal() {
+			u.Passphrase++
+		}
+		if sec.ExpiresAt.After(u.LastExpiry) {
+			u.LastExpiry = sec.ExpiresAt
+		}
+	}
+
+	report := make([]KeyUsage, 0, len(usage))
+	for _, u := range usage {
+		report = append(report, *u)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Current != report[j].Current {
+			return report[i].Current
+		}
+		return report[i].KeyID < report[j].KeyID
+	})
+	return report, nil
+}
+
+// Rekey re-encrypts every secret that is not under the current key, or
+// not yet in the current format, and returns how many it resealed.
+// Secrets that cannot be resealed are skipped and show up in KeyUsage
+// until they expire. Secrets read or deleted while Rekey runs are left
+// alone. A secret that fails to reseal does not stop the others; the
+// failures are returned together at the end.
+func (s *Store) Rekey() (int, error) {
+	ids, err := s.backend.IDs()
+	if err != nil {
+		return 0, err
+	}
+	currentID, err := s.keys.CurrentKeyID()
+	if err != nil {
+		return 0, err
+	}
+	resealed := 0
+	var errs []error
+	for _, id := range ids {
+		sec, err := s.backend.Get(id)
+		if err != nil || !sec.hasSealed() || !sec.canReseal() || (sec.KeyID == currentID && !sec.outdated()) {
+			continue
+		}
+		sec = withID(id, sec)
+		sealed, err := s.reseal(sec)
+		if err == nil {
+			err = s.backend.Reseal(id, sealed)
+		}
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			// The ID would reveal the secret, so name it by its Ref.
+			errs = append(errs, fmt.Errorf("secret %s: %w", Ref(id), err))
+			continue
+		}
+		resealed++
+	}
+	if len(errs) > 0 {
+		return resealed, fmt.Errorf("%d secrets could not be resealed: %w", len(errs), errors.Join(errs...))
+	}
+	return resealed, nil
+}
+
+// reseal moves sec under the current key. A data key is wrapped again,
+// records from before envelope encryption are given one, and every field
+// that can be is sealed anew, since its authenticated data names the key.
+func (s *Store) reseal(sec *Secret) (*Secret, error) {
+	var dk *dataKey
+	if sec.WrappedKey != nil {
+		keys, err := s.dataKeys(sec)
+		if err != nil {
+			return nil, err
+		}
+		dk = &dataKey{key: keys[0]}
+		if dk.keyID, dk.wrapped, err = s.keys.Wrap(dk.key); err != nil {
+			dk.wipe()
+			return nil, err
+		}
+	} else {
+		var err error
+		if dk, err = s.newDataKey(); err != nil {
+			return nil, err
+		}
+	}
+	defer dk.wipe()
+
+	out := *sec
+	out.KeyID, out.WrappedKey = dk.keyID, dk.wrapped
+	fields := []struct {
+		name     string
+		text     string
+		nonce    []byte
+		dst      *string
+		dstNonce *[]byte
+	}{
+		{fieldText, sec.CipherText, sec.Nonce, &out.CipherText, &out.Nonce},
+		{fieldNotifyEmail, sec.NotifyEmail, sec.NotifyNonce, &out.NotifyEmail, &out.NotifyNonce},
+		{fieldRecipients, sec.Recipients, sec.RecipientsNonce, &out.Recipients, &out.RecipientsNonce},
+	}
+	for _, f := range fields {
+		// Only the passphrase opens the text; canReseal made sure it is
+		// not bound to the old key ID, so it is kept as it is.
+		if f.text == "" || (f.name == fieldText && sec.HasPassphrase()) {
+			continue
+		}
+		plain, err := s.open(sec, f.name, f.text, f.nonce, nil)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst, *f.dstNonce, err = dk.seal(&out, f.name, plain)
+		wipe(plain)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
@@ -2,267 +2,627 @@ package storage
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"os"
-	"sync"
 	"time"
 
 	"whisperbin/internal"
+	"whisperbin/internal/secmem"
+	"whisperbin/internal/shamir"
 )
 
 type Store struct {
-	mu               sync.Mutex
-	secrets          map[string]*Secret
-	key              []byte
-	confirmFailures  map[string]map[string]int
-	confirmBlockedAt map[string]map[string]time.Time
+	backend Backend
+	keys    KeyProvider
+	events  *EventBus
 }
 
+// NewStore returns an in-memory store under a random key, which is lost
+// on restart. Servers pick their key provider themselves and use
+// NewStoreWithKeys.
 func NewStore() *Store {
-	var key []byte
-	envKey := os.Getenv("SECRET_KEY")
-
-	if envKey != "" {
-		decoded, err := base64.StdEncoding.DecodeString(envKey)
-		if err != nil || len(decoded) != 32 {
-			panic("invalid SECRET_KEY: must be 32-byte base64-encoded")
-		}
-		key = decoded
-	} else {
-		key = make([]byte, 32)
-		if _, err := rand.Read(key); err != nil {
-			panic("could not generate encryption key")
-		}
+	keys, err := RandomKeyring()
+	if err != nil {
+		panic("could not generate encryption key")
 	}
+	return NewStoreWithKeys(NewMemoryBackend(), keys)
+}
 
+// NewStoreWithKeys wraps the data keys of new secrets with keys.
+func NewStoreWithKeys(backend Backend, keys KeyProvider) *Store {
 	return &Store{
-		secrets:          make(map[string]*Secret),
-		key:              key,
-		confirmFailures:  make(map[string]map[string]int),
-		confirmBlockedAt: make(map[string]map[string]time.Time),
+		backend: backend,
+		keys:    keys,
+		events:  NewEventBus(),
 	}
 }
 
+// Events is the bus on which the store publishes lifecycle events.
+func (s *Store) Events() *EventBus {
+	return s.events
+}
+
 func (s *Store) Save(text string, ttlMinutes int, withApproval bool) (string, string, error) {
+	res, err := s.SaveWithOptions(text, SaveOptions{TTLMinutes: ttlMinutes, WithApproval: withApproval})
+	if err != nil {
+		return "", "", err
+	}
+	return res.ID, res.Code, nil
+}
+
+func (s *Store) SaveWithOptions(text string, opts SaveOptions) (*SaveResult, error) {
+	plain := []byte(text)
+	defer wipe(plain)
+	return s.SaveSecret(plain, opts)
+}
+
+// SaveSecret stores text, which stays owned by the caller, so it can be
+// read from a buffer that is wiped afterwards.
+func (s *Store) SaveSecret(text []byte, opts SaveOptions) (*SaveResult, error) {
 	s.CleanupExpired()
 
 	id, err := generateID()
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return nil, err
 	}
 
-	cipherText, nonce, err := encrypt([]byte(text), s.key)
+	dk, err := s.newDataKey()
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
+	defer dk.wipe()
 
-	expiration := time.Now().Add(time.Duration(ttlMinutes) * time.Minute)
-	secret := &Secret{
-		CipherText: base64.StdEncoding.EncodeToString(cipherText),
-		Nonce:      nonce,
-		ExpiresAt:  expiration,
+	key := dk.key
+	var salt []byte
+	if opts.Passphrase != "" {
+		if salt, err = generateSalt(); err != nil {
+			return nil, err
+		}
+		release, err := acquireDerivation()
+		if err != nil {
+			return nil, err
+		}
+		key = passphraseKey(dk.key, opts.Passphrase, salt)
+		release()
+		defer wipe(key)
 	}
 
-	if withApproval {
+	expiration := time.Now().Add(time.Duration(opts.TTLMinutes) * time.Minute)
+	secret := &Secret{
+		ID:          id,
+		KeyID:       dk.keyID,
+		WrappedKey:  dk.wrapped,
+		ExpiresAt:   expiration,
+		Opaque:      opts.Opaque,
+		FileName:    opts.FileName,
+		ContentType: opts.ContentType,
+		Views:       opts.Views,
+
+		PassphraseSalt: salt,
+		BurnAfter:      opts.BurnAfter,
+		ShareSet:       opts.shareSet,
+		Threshold:      opts.threshold,
+		Creator:        opts.Creator,
+		AllowedCIDRs:   opts.AllowedCIDRs,
+	}
+	if secret.Views < 1 {
+		secret.Views = 1
+	}
+	if opts.WithApproval {
 		code, err := generateCode()
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
 		secret.Code = code
 		secret.Unlocked = false
-		secret.WaitingCh = make(chan struct{})
 	} else {
 		secret.Unlocked = true
 	}
 
-	s.mu.Lock()
-	s.secrets[id] = secret
-	s.mu.Unlock()
+	// The mode is complete now, so the fields can be bound to it.
+	if secret.CipherText, secret.Nonce, err = sealField(secret, fieldText, text, key); err != nil {
+		return nil, err
+	}
+	if opts.NotifyEmail != "" {
+		if secret.NotifyEmail, secret.NotifyNonce, err = dk.seal(secret, fieldNotifyEmail, []byte(opts.NotifyEmail)); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Recipients.IsZero() {
+		rules, _ := json.Marshal(opts.Recipients)
+		if secret.Recipients, secret.RecipientsNonce, err = dk.seal(secret, fieldRecipients, rules); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.backend.Save(id, secret); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	receipt := &Receipt{
+		TokenHash:   hashToken(token),
+		CreatedAt:   now,
+		ExpiresAt:   expiration,
+		RetainUntil: expiration.Add(internal.ReceiptRetention),
+		Views:       secret.Views,
+	}
+	if err := s.backend.SaveReceipt(id, receipt); err != nil {
+		s.backend.Delete(id)
+		return nil, err
+	}
+
+	s.events.Publish(Event{Type: EventCreated, ID: id, Secret: secret})
+	return &SaveResult{ID: id, Code: secret.Code, ManageToken: token, ExpiresAt: expiration}, nil
+}
+
+// SaveShares splits text into opts.Shares Shamir shares and stores each
+// as an independent one-time secret with its own ID and management token.
+// A SHA-256 digest of text is split along with it, so Combine can tell
+// when the shares do not add up to the original.
+func (s *Store) SaveShares(text []byte, opts ShareOptions) ([]*SaveResult, error) {
+	digest := sha256.Sum256(text)
+	payload := secmem.Alloc(len(text) + sha256.Size)
+	defer wipe(payload)
+	copy(payload, text)
+	copy(payload[len(text):], digest[:])
+	shares, err := shamir.Split(payload, opts.Shares, opts.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(shares...)
+	set, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SaveResult, 0, len(shares))
+	for _, share := range shares {
+		encoded := secmem.Alloc(base64.StdEncoding.EncodedLen(len(share)))
+		base64.StdEncoding.Encode(encoded, share)
+		res, err := s.SaveSecret(encoded, SaveOptions{
+			TTLMinutes: opts.TTLMinutes,
+			Creator:    opts.Creator,
+			shareSet:   set,
+			threshold:  opts.Threshold,
+		})
+		wipe(encoded)
+		if err != nil {
+			for _, saved := range results {
+				s.backend.Delete(saved.ID)
+			}
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Combine recovers a secret from the share links in ids. Every share is
+// checked before any is consumed, so too few shares or shares of
+// different secrets leave them all in place. The caller must wipe the
+// result.
+func (s *Store) Combine(ids []string) ([]byte, error) {
+	var set string
+	threshold := 0
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		sec, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if !sec.IsShare() || (set != "" && sec.ShareSet != set) {
+			return nil, ErrInvalidShares
+		}
+		set, threshold = sec.ShareSet, sec.Threshold
+		unique = append(unique, id)
+	}
+	if len(unique) == 0 || len(unique) < threshold {
+		return nil, ErrNotEnoughShares
+	}
+
+	shares := make([][]byte, 0, len(unique))
+	defer func() { wipe(shares...) }()
+	for _, id := range unique {
+		sec, err := s.Take(id)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := s.DecryptSecret(sec)
+		if err != nil {
+			return nil, err
+		}
+		share := secmem.Alloc(base64.StdEncoding.DecodedLen(len(encoded)))
+		n, err := base64.StdEncoding.Decode(share, encoded)
+		wipe(encoded)
+		shares = append(shares, share[:n])
+		if err != nil {
+			return nil, ErrInvalidShares
+		}
+	}
 
-	return id, secret.Code, nil
+	payload, err := shamir.Combine(shares)
+	if err != nil || len(payload) < sha256.Size {
+		wipe(payload)
+		return nil, ErrInvalidShares
+	}
+	text, sum := payload[:len(payload)-sha256.Size], payload[len(payload)-sha256.Size:]
+	digest := sha256.Sum256(text)
+	if subtle.ConstantTimeCompare(digest[:], sum) != 1 {
+		wipe(payload)
+		return nil, ErrInvalidShares
+	}
+	return text, nil
 }
 
+// Get returns the secret stored under id. Drop records are only reachable
+// through Drop and Fulfill.
 func (s *Store) Get(id string) (*Secret, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	secret, ok := s.secrets[id]
-	if !ok || time.Now().After(secret.ExpiresAt) {
-		return nil, errors.New("not found or expired")
+	sec, err := s.backend.Get(id)
+	if err != nil {
+		return nil, err
 	}
-	return secret, nil
+	if sec.IsDrop() {
+		return nil, ErrNotFound
+	}
+	return withID(id, sec), nil
 }
 
-func (s *Store) Delete(id string) {
-	s.mu.Lock()
-	delete(s.secrets, id)
-	delete(s.confirmFailures, id)
-	delete(s.confirmBlockedAt, id)
-	s.mu.Unlock()
+// Request creates an empty, Awaiting secret for the requester and a
+// one-time drop record that lets the holder fill it in. The requester
+// waits on the secret's ID with WaitForUnlock like a secure-mode recipient.
+func (s *Store) Request(opts RequestOptions) (*RequestResult, error) {
+	s.CleanupExpired()
+
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	dropID, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	dk, err := s.newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	defer dk.wipe()
+
+	expiration := time.Now().Add(time.Duration(opts.TTLMinutes) * time.Minute)
+	drop := &Secret{
+		ID:         dropID,
+		KeyID:      dk.keyID,
+		WrappedKey: dk.wrapped,
+		ExpiresAt:  expiration,
+		Unlocked:   true,
+		Views:      1,
+		DropFor:    id,
+	}
+	if drop.CipherText, drop.Nonce, err = dk.seal(drop, fieldText, []byte(opts.Note)); err != nil {
+		return nil, err
+	}
+
+	if err := s.backend.Save(id, &Secret{ID: id, ExpiresAt: expiration, Awaiting: true, Views: 1, Creator: opts.Creator}); err != nil {
+		return nil, err
+	}
+	if err := s.backend.Save(dropID, drop); err != nil {
+		s.backend.Delete(id)
+		return nil, err
+	}
+	return &RequestResult{ID: id, DropID: dropID, ExpiresAt: expiration}, nil
 }
 
-func (s *Store) Confirm(id, inputCode, ip string) error {
-	s.mu.Lock()
-	sec, ok := s.secrets[id]
-	if !ok || time.Now().After(sec.ExpiresAt) {
-		s.mu.Unlock()
-		return errors.New("not found or expired")
+// Drop returns the requester's note for a drop link that can still be
+// used.
+func (s *Store) Drop(dropID string) (string, error) {
+	drop, _, err := s.openDrop(dropID)
+	if err != nil {
+		return "", err
 	}
+	return s.DecryptSecretText(drop)
+}
 
-	if s.isBlocked(id, ip) {
-		s.mu.Unlock()
-		return errors.New("too many failed attempts, temporarily blocked")
+// Fulfill consumes the drop link and stores text as the requester's
+// secret, waking the requester if they are waiting.
+func (s *Store) Fulfill(dropID string, text []byte) error {
+	drop, target, err := s.openDrop(dropID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.backend.Take(dropID); err != nil {
+		return err
 	}
 
-	if subtle.ConstantTimeCompare([]byte(sec.Code), []byte(inputCode)) != 1 {
-		s.incrementFailure(id, ip)
-		s.mu.Unlock()
-		return errors.New("invalid code")
+	dk, err := s.newDataKey()
+	if err != nil {
+		return err
+	}
+	defer dk.wipe()
+	sec := &Secret{
+		ID:         drop.DropFor,
+		KeyID:      dk.keyID,
+		WrappedKey: dk.wrapped,
+		ExpiresAt:  drop.ExpiresAt,
+		Unlocked:   true,
+		Views:      1,
+		Creator:    target.Creator,
 	}
+	if sec.CipherText, sec.Nonce, err = dk.seal(sec, fieldText, text); err != nil {
+		return err
+	}
+	return s.backend.Fulfill(drop.DropFor, sec)
+}
 
-	if sec.WaitingCh == nil || !sec.listenerSet {
-		s.mu.Unlock()
-		return errors.New("no recipient waiting")
+// openDrop returns the drop record for dropID and the awaiting secret it
+// fills, if the request is still waiting.
+func (s *Store) openDrop(dropID string) (*Secret, *Secret, error) {
+	drop, err := s.backend.Get(dropID)
+	if err != nil {
+		return nil, nil, err
+	}
+	drop = withID(dropID, drop)
+	if !drop.IsDrop() {
+		return nil, nil, ErrNotFound
+	}
+	target, err := s.backend.Get(drop.DropFor)
+	if err != nil {
+		return nil, nil, err
 	}
-	if sec.Unlocked {
-		s.mu.Unlock()
-		return errors.New("already unlocked")
+	if !target.Awaiting {
+		return nil, nil, ErrNotFound
 	}
-	sec.Unlocked = true
-	close(sec.WaitingCh)
-	sec.WaitingCh = nil
+	return drop, withID(drop.DropFor, target), nil
+}
 
-	s.resetFailures(id, ip)
+// Take consumes one view and records it on the sender's receipt.
+func (s *Store) Take(id string) (*Secret, error) {
+	sec, err := s.backend.Take(id)
+	if err != nil {
+		return nil, err
+	}
+	sec = withID(id, sec)
+	s.backend.MarkViewed(id, time.Now())
+	s.events.Publish(Event{Type: EventRevealed, ID: id, Secret: sec})
+	return sec, nil
+}
 
-	s.mu.Unlock()
+// Delete removes a secret before it was read and marks its receipt as
+// revoked.
+func (s *Store) Delete(id string) error {
+	if _, err := s.backend.Get(id); err != nil {
+		return s.backend.Delete(id)
+	}
+	if err := s.backend.Delete(id); err != nil {
+		return err
+	}
+	s.closeReceipt(id, false)
 	return nil
 }
 
-func (s *Store) WaitForUnlock(ctx context.Context, id string) (*Secret, error) {
-	s.mu.Lock()
-	sec, ok := s.secrets[id]
-	if !ok || time.Now().After(sec.ExpiresAt) {
-		s.mu.Unlock()
-		return nil, errors.New("not found or expired")
+// Receipt returns the sender's receipt if token is the management token
+// issued when the secret was created.
+func (s *Store) Receipt(id, token string) (*Receipt, error) {
+	rec, err := s.backend.GetReceipt(id)
+	if err != nil {
+		return nil, err
 	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(rec.TokenHash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	return rec, nil
+}
 
-	if err := s.canWait(sec); err != nil {
-		s.mu.Unlock()
-		return nil, err
+// Revoke deletes a secret on behalf of its sender. It fails with
+// ErrNotPending once the secret is gone but its receipt is still kept.
+func (s *Store) Revoke(id, token string) error {
+	if _, err := s.Receipt(id, token); err != nil {
+		return err
+	}
+	if _, err := s.backend.Get(id); errors.Is(err, ErrNotFound) {
+		return ErrNotPending
+	} else if err != nil {
+		return err
+	}
+	return s.Delete(id)
+}
+
+func (s *Store) closeReceipt(id string, burned bool) {
+	rec, err := s.backend.GetReceipt(id)
+	if err != nil {
+		return
 	}
+	rec.RevokedAt = time.Now()
+	rec.Burned = burned
+	s.backend.SaveReceipt(id, rec)
+}
 
-	sec.listenerSet = true
-	ch := sec.WaitingCh
-	expiresAt := sec.ExpiresAt
-	s.mu.Unlock()
+func (s *Store) Confirm(id, inputCode, ip string) error {
+	err := s.backend.Confirm(id, inputCode, ip)
+	if errors.Is(err, ErrInvalidCode) {
+		s.publishFailure(id, ip)
+	}
+	return err
+}
 
-	timer := time.NewTimer(time.Until(expiresAt))
-	defer timer.Stop()
+// publishFailure reports a wrong passcode or passphrase, and the block if
+// it was the failure that triggered one.
+func (s *Store) publishFailure(id, ip string) {
+	s.events.Publish(Event{Type: EventConfirmFailed, ID: id, IP: ip})
+	if blocked, err := s.backend.Blocked(id, ip); err == nil && blocked {
+		s.events.Publish(Event{Type: EventBlocked, ID: id, IP: ip})
+	}
+}
 
-	select {
-	case <-ch:
-		return sec, nil
-	case <-ctx.Done():
-		s.releaseListener(id)
-		return nil, ctx.Err()
-	case <-timer.C:
-		s.releaseListener(id)
-		return nil, errors.New("not found or expired")
+// WaitForUnlock blocks until the secret is unlocked. The first time a
+// recipient waits on a secure-mode secret it publishes EventWaiting, so
+// the sender can be told that a passcode needs checking. Reconnects and
+// requests that lose the race for the listener slot publish nothing.
+func (s *Store) WaitForUnlock(ctx context.Context, id string) (*Secret, error) {
+	sec, err := s.backend.WaitForUnlock(ctx, id, func(sec *Secret) {
+		if sec.Code != "" {
+			s.events.Publish(Event{Type: EventWaiting, ID: id, Secret: withID(id, sec)})
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
+	return withID(id, sec), nil
 }
 
-func (s *Store) releaseListener(id string) {
-	s.mu.Lock()
-	if sec, ok := s.secrets[id]; ok {
-		sec.listenerSet = false
+// DecryptSecret decrypts secrets stored under the instance key into a
+// buffer the caller must release with secmem.Free once it is written out.
+// Passphrase-protected secrets must go through Unseal instead. It returns
+// ErrTampered if sec was changed or moved since it was sealed; sec must
+// come from the Store, which sets the ID it was looked up under.
+func (s *Store) DecryptSecret(sec *Secret) ([]byte, error) {
+	if sec.HasPassphrase() {
+		return nil, ErrPassphraseRequired
 	}
-	s.mu.Unlock()
+	return s.open(sec, fieldText, sec.CipherText, sec.Nonce, nil)
 }
 
+// DecryptSecretText is DecryptSecret for callers that need a string. The
+// string cannot be wiped, so the web paths do not use it.
 func (s *Store) DecryptSecretText(sec *Secret) (string, error) {
-	cipherBytes, err := base64.StdEncoding.DecodeString(sec.CipherText)
+	plain, err := s.DecryptSecret(sec)
 	if err != nil {
 		return "", err
 	}
-	plain, err := decrypt(cipherBytes, sec.Nonce, s.key)
+	defer wipe(plain)
+	return string(plain), nil
+}
+
+// NotifyEmail returns the sender's notification address of sec, or ""
+// if none was given.
+func (s *Store) NotifyEmail(sec *Secret) (string, error) {
+	if sec == nil || sec.NotifyEmail == "" {
+		return "", nil
+	}
+	plain, err := s.open(sec, fieldNotifyEmail, sec.NotifyEmail, sec.NotifyNonce, nil)
 	if err != nil {
 		return "", err
 	}
+	defer wipe(plain)
 	return string(plain), nil
 }
 
-func (s *Store) IsWaiting(id string) (bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	secret, ok := s.secrets[id]
-	if !ok || time.Now().After(secret.ExpiresAt) {
-		return false, errors.New("not found or expired")
-	}
-	waiting := secret.WaitingCh != nil && !secret.Unlocked
-	return waiting, nil
+// Recipients returns who may open sec. It is zero for secrets anyone
+// with the link can open.
+func (s *Store) Recipients(sec *Secret) (Recipients, error) {
+	var rules Recipients
+	if !sec.IsRestricted() {
+		return rules, nil
+	}
+	plain, err := s.open(sec, fieldRecipients, sec.Recipients, sec.RecipientsNonce, nil)
+	if err != nil {
+		return rules, err
+	}
+	defer wipe(plain)
+	err = json.Unmarshal(plain, &rules)
+	return rules, err
 }
 
-func (s *Store) CleanupExpired() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	now := time.Now()
-	for id, sec := range s.secrets {
-		if now.After(sec.ExpiresAt) {
-			delete(s.secrets, id)
-			delete(s.confirmFailures, id)
-			delete(s.confirmBlockedAt, id)
-		}
+// Unseal is UnsealSecret for callers that need a string.
+func (s *Store) Unseal(id, passphrase, ip string) (*Secret, string, error) {
+	sec, plain, err := s.UnsealSecret(id, passphrase, ip)
+	if err != nil {
+		return nil, "", err
 	}
+	defer wipe(plain)
+	return sec, string(plain), nil
 }
 
-func (s *Store) incrementFailure(id, ip string) {
-	if s.confirmFailures[id] == nil {
-		s.confirmFailures[id] = make(map[string]int)
+// UnsealSecret decrypts a passphrase-protected secret and consumes one
+// view of it. The caller must release the plaintext with secmem.Free. A
+// wrong passphrase counts towards the same per-IP lockout as a wrong
+// passcode, and destroys the secret once its BurnAfter limit is reached.
+// It fails with ErrBusy, without counting an attempt, while too many
+// passphrases are being checked at once.
+func (s *Store) UnsealSecret(id, passphrase, ip string) (*Secret, []byte, error) {
+	sec, err := s.backend.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	sec = withID(id, sec)
+	if !sec.HasPassphrase() {
+		return nil, nil, ErrNotFound
 	}
-	s.confirmFailures[id][ip]++
-	if s.confirmFailures[id][ip] >= internal.MaxCodeFailures {
-		if s.confirmBlockedAt[id] == nil {
-			s.confirmBlockedAt[id] = make(map[string]time.Time)
+	if blocked, err := s.backend.Blocked(id, ip); err != nil {
+		return nil, nil, err
+	} else if blocked {
+		return nil, nil, ErrBlocked
+	}
+
+	release, err := acquireDerivation()
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := s.open(sec, fieldText, sec.CipherText, sec.Nonce, func(key []byte) []byte {
+		return passphraseKey(key, passphrase, sec.PassphraseSalt)
+	})
+	release()
+	if errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrTampered) || errors.Is(err, errUnknownFormat) {
+		return nil, nil, err
+	}
+	if err != nil {
+		failures, err := s.backend.RecordFailure(id, ip)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.publishFailure(id, ip)
+		if sec.BurnAfter > 0 && failures >= sec.BurnAfter {
+			s.backend.Delete(id)
+			s.closeReceipt(id, true)
+			return nil, nil, ErrBurned
 		}
-		s.confirmBlockedAt[id][ip] = time.Now().Add(internal.BlockDuration)
+		return nil, nil, ErrInvalidPassphrase
 	}
 
+	s.backend.ResetFailures(id, ip)
+	if sec, err = s.Take(id); err != nil {
+		wipe(plain)
+		return nil, nil, err
+	}
+	return sec, plain, nil
 }
 
-func (s *Store) resetFailures(id, ip string) {
-	if s.confirmFailures[id] != nil {
-		delete(s.confirmFailures[id], ip)
-	}
-	if s.confirmBlockedAt[id] != nil {
-		delete(s.confirmBlockedAt[id], ip)
-	}
+func (s *Store) IsWaiting(id string) (bool, error) {
+	return s.backend.IsWaiting(id)
 }
 
-func (s *Store) isBlocked(id, ip string) bool {
-	blockMap, exists := s.confirmBlockedAt[id]
-	if !exists {
-		return false
-	}
-	blockTime, blocked := blockMap[ip]
-	if !blocked {
-		return false
-	}
-	if time.Now().After(blockTime) {
-		delete(blockMap, ip)
-		return false
+func (s *Store) CleanupExpired() {
+	for id, sec := range s.backend.CleanupExpired() {
+		if sec != nil && sec.IsDrop() {
+			continue
+		}
+		e := Event{Type: EventExpired, ID: id, Secret: withID(id, sec)}
+		if rec, err := s.backend.GetReceipt(id); err == nil {
+			e.Viewed = len(rec.ViewedAt)
+		}
+		s.events.Publish(e)
 	}
-	return true
 }
 
-func (s *Store) canWait(sec *Secret) error {
-	if sec.WaitingCh == nil {
-		return errors.New("not secure mode")
+// withID returns sec with the ID it was looked up under, which the
+// authenticated data of its fields is checked against. A record whose ID
+// differs is copied rather than changed, since backends may share it.
+func withID(id string, sec *Secret) *Secret {
+	if sec == nil || sec.ID == id {
+		return sec
 	}
-	if sec.listenerSet {
-		return errors.New("listener already connected")
-	}
-	return nil
+	cp := *sec
+	cp.ID = id
+	return &cp
 }
@@ -1,9 +1,17 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	"whisperbin/internal"
+	"whisperbin/internal/secmem"
 )
 
 func TestStore_SecureFlow(t *testing.T) {
@@ -13,7 +21,7 @@ func TestStore_SecureFlow(t *testing.T) {
 	}
 
 	store := NewStore()
-	store.key = key
+	store.keys, _ = NewKeyring(key)
 
 	text := "super secret"
 	id, code, err := store.Save(text, 5, true)
@@ -68,3 +76,852 @@ func TestStore_SecureFlow(t *testing.T) {
 		t.Fatal("Timeout waiting for unlock result")
 	}
 }
+
+func TestStore_Passphrase(t *testing.T) {
+	store := NewStore()
+	res, err := store.SaveWithOptions("launch codes", SaveOptions{TTLMinutes: 5, Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+	id := res.ID
+
+	sec, err := store.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !sec.HasPassphrase() {
+		t.Fatal("Expected secret to be passphrase-protected")
+	}
+	if _, err := store.DecryptSecretText(sec); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+
+	if _, _, err := store.Unseal(id, "wrong horse", "127.0.0.1"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Fatalf("Expected ErrInvalidPassphrase, got %v", err)
+	}
+	if _, err := store.Get(id); err != nil {
+		t.Fatalf("Wrong passphrase must not consume the secret: %v", err)
+	}
+
+	_, text, err := store.Unseal(id, "correct horse", "127.0.0.1")
+	if err != nil || text != "launch codes" {
+		t.Fatalf("Unseal = %q, %v", text, err)
+	}
+	if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected secret to be consumed, got %v", err)
+	}
+}
+
+func TestStore_PassphraseLockout(t *testing.T) {
+	store := NewStore()
+	res, _ := store.SaveWithOptions("x", SaveOptions{TTLMinutes: 5, Passphrase: "pw"})
+	id := res.ID
+
+	for i := 0; i < internal.MaxCodeFailures; i++ {
+		store.Unseal(id, "guess", "10.0.0.1")
+	}
+	if _, _, err := store.Unseal(id, "pw", "10.0.0.1"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected ErrBlocked, got %v", err)
+	}
+	if _, text, err := store.Unseal(id, "pw", "10.0.0.2"); err != nil || text != "x" {
+		t.Errorf("Expected other IP to unseal, got %q, %v", text, err)
+	}
+}
+
+func TestStore_PassphraseBusy(t *testing.T) {
+	store := NewStore()
+	res, _ := store.SaveWithOptions("x", SaveOptions{TTLMinutes: 5, Passphrase: "pw", BurnAfter: 1})
+
+	for i := 0; i < maxDerivations; i++ {
+		derivations <- struct{}{}
+	}
+	if _, _, err := store.Unseal(res.ID, "guess", "10.0.0.1"); !errors.Is(err, ErrBusy) {
+		t.Fatalf("Expected ErrBusy, got %v", err)
+	}
+	if _, err := store.SaveWithOptions("y", SaveOptions{TTLMinutes: 5, Passphrase: "pw"}); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy on save, got %v", err)
+	}
+	for i := 0; i < maxDerivations; i++ {
+		<-derivations
+	}
+
+	if _, text, err := store.Unseal(res.ID, "pw", "10.0.0.1"); err != nil || text != "x" {
+		t.Errorf("A busy attempt must not count as a failure, got %q, %v", text, err)
+	}
+}
+
+func TestStore_PassphraseBurnAfter(t *testing.T) {
+	store := NewStore()
+	res, _ := store.SaveWithOptions("x", SaveOptions{TTLMinutes: 5, Passphrase: "pw", BurnAfter: 2})
+	id := res.ID
+
+	if _, _, err := store.Unseal(id, "guess", "10.0.0.1"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Fatalf("Expected ErrInvalidPassphrase, got %v", err)
+	}
+	if _, _, err := store.Unseal(id, "guess", "10.0.0.2"); !errors.Is(err, ErrBurned) {
+		t.Fatalf("Expected ErrBurned, got %v", err)
+	}
+	if _, _, err := store.Unseal(id, "pw", "10.0.0.3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected burned secret to be gone, got %v", err)
+	}
+}
+
+func TestStore_ReceiptAndRevoke(t *testing.T) {
+	store := NewStore()
+	res, err := store.SaveWithOptions("x", SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+
+	if _, err := store.Receipt(res.ID, "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+	rec, err := store.Receipt(res.ID, res.ManageToken)
+	if err != nil || rec.Status() != ReceiptPending {
+		t.Fatalf("Expected pending receipt, got %+v, %v", rec, err)
+	}
+
+	if err := store.Revoke(res.ID, "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected revoke with wrong token to fail, got %v", err)
+	}
+	if err := store.Revoke(res.ID, res.ManageToken); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := store.Get(res.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected revoked secret to be gone, got %v", err)
+	}
+	if rec, _ := store.Receipt(res.ID, res.ManageToken); rec.Status() != ReceiptRevoked {
+		t.Errorf("Expected revoked receipt, got %q", rec.Status())
+	}
+	if err := store.Revoke(res.ID, res.ManageToken); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Expected ErrNotPending, got %v", err)
+	}
+}
+
+func TestStore_ReceiptRecordsViews(t *testing.T) {
+	store := NewStore()
+	res, _ := store.SaveWithOptions("x", SaveOptions{TTLMinutes: 5, Views: 2})
+
+	store.Take(res.ID)
+	rec, _ := store.Receipt(res.ID, res.ManageToken)
+	if rec.Status() != ReceiptPending || rec.ViewsLeft() != 1 || len(rec.ViewedAt) != 1 {
+		t.Errorf("Expected one view recorded, got %+v", rec)
+	}
+
+	store.Take(res.ID)
+	rec, _ = store.Receipt(res.ID, res.ManageToken)
+	if rec.Status() != ReceiptViewed || len(rec.ViewedAt) != 2 {
+		t.Errorf("Expected viewed receipt, got %+v", rec)
+	}
+}
+
+func TestStore_RequestFlow(t *testing.T) {
+	store := NewStore()
+	req, err := store.Request(RequestOptions{TTLMinutes: 5, Note: "staging DB password"})
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	if _, err := store.Get(req.DropID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the drop record to be hidden from Get, got %v", err)
+	}
+	if note, err := store.Drop(req.DropID); err != nil || note != "staging DB password" {
+		t.Errorf("Expected note from drop, got %q, %v", note, err)
+	}
+	if err := store.Fulfill(req.ID, []byte("wrong link")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the requester's ID not to work as a drop link, got %v", err)
+	}
+
+	unlocked := make(chan string, 1)
+	go func() {
+		got, err := store.WaitForUnlock(context.Background(), req.ID)
+		if err != nil {
+			t.Errorf("WaitForUnlock failed: %v", err)
+			unlocked <- ""
+			return
+		}
+		plain, _ := store.DecryptSecretText(got)
+		unlocked <- plain
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := store.Fulfill(req.DropID, []byte("hunter2")); err != nil {
+		t.Fatalf("Fulfill failed: %v", err)
+	}
+	select {
+	case got := <-unlocked:
+		if got != "hunter2" {
+			t.Errorf("Expected requester to receive %q, got %q", "hunter2", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the submitted secret")
+	}
+
+	if err := store.Fulfill(req.DropID, []byte("again")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected drop link to work only once, got %v", err)
+	}
+	if _, err := store.Drop(req.DropID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected used drop link to be gone, got %v", err)
+	}
+}
+
+func TestStore_SharesThreshold(t *testing.T) {
+	store := NewStore()
+	shares, err := store.SaveShares([]byte("break-glass root password"), ShareOptions{TTLMinutes: 5, Shares: 5, Threshold: 3})
+	if err != nil {
+		t.Fatalf("SaveShares failed: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(shares))
+	}
+	for _, share := range shares {
+		sec, err := store.Get(share.ID)
+		if err != nil || !sec.IsShare() || sec.Threshold != 3 {
+			t.Fatalf("Expected share secret with threshold 3, got %+v, %v", sec, err)
+		}
+		if share.ManageToken == "" {
+			t.Error("Expected every share to have its own management token")
+		}
+	}
+
+	text, err := store.Combine([]string{shares[4].ID, shares[1].ID, shares[2].ID, shares[1].ID})
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if string(text) != "break-glass root password" {
+		t.Errorf("Expected original secret, got %q", text)
+	}
+
+	for i, share := range shares {
+		_, err := store.Get(share.ID)
+		if used := i == 1 || i == 2 || i == 4; used != errors.Is(err, ErrNotFound) {
+			t.Errorf("Share %d: consumed=%t, got %v", i, used, err)
+		}
+	}
+	if _, err := store.Combine([]string{shares[0].ID, shares[1].ID, shares[3].ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected consumed share to be gone, got %v", err)
+	}
+}
+
+func TestStore_SharesBelowThresholdAreNotConsumed(t *testing.T) {
+	store := NewStore()
+	shares, err := store.SaveShares([]byte("root"), ShareOptions{TTLMinutes: 5, Shares: 5, Threshold: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.SaveShares([]byte("other"), ShareOptions{TTLMinutes: 5, Shares: 2, Threshold: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _, _ := store.Save("not a share", 5, false)
+
+	for name, ids := range map[string][]string{
+		"too few":    {shares[0].ID, shares[1].ID},
+		"duplicates": {shares[0].ID, shares[0].ID, shares[0].ID},
+		"none":       nil,
+	} {
+		if _, err := store.Combine(ids); !errors.Is(err, ErrNotEnoughShares) {
+			t.Errorf("%s: expected ErrNotEnoughShares, got %v", name, err)
+		}
+	}
+	for name, ids := range map[string][]string{
+		"mixed sets": {shares[0].ID, shares[1].ID, other[0].ID},
+		"plain":      {shares[0].ID, shares[1].ID, plain},
+	} {
+		if _, err := store.Combine(ids); !errors.Is(err, ErrInvalidShares) {
+			t.Errorf("%s: expected ErrInvalidShares, got %v", name, err)
+		}
+	}
+
+	for _, share := range shares {
+		if _, err := store.Get(share.ID); err != nil {
+			t.Errorf("Expected rejected combine to leave share %s in place: %v", share.ID, err)
+		}
+	}
+	if text, err := store.Combine([]string{shares[0].ID, shares[2].ID, shares[3].ID}); err != nil || string(text) != "root" {
+		t.Errorf("Expected remaining shares to still combine, got %q, %v", text, err)
+	}
+}
+
+func TestStore_PublishesLifecycleEvents(t *testing.T) {
+	store := NewStore()
+	var events []Event
+	store.Events().Subscribe(func(e Event) { events = append(events, e) })
+
+	res, err := store.SaveWithOptions("plaintext value", SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Take(res.ID)
+
+	id, _, _ := store.Save("secure value", 5, true)
+	for i := 0; i < internal.MaxCodeFailures; i++ {
+		store.Confirm(id, "000000x", "10.0.0.9")
+	}
+
+	store.backend.Save("short", plainSecret(10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	store.CleanupExpired()
+
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+		if e.Time.IsZero() {
+			t.Errorf("Expected %s event to carry a time", e.Type)
+		}
+		if s := fmt.Sprintf("%+v", e); strings.Contains(s, "plaintext value") || strings.Contains(s, "secure value") {
+			t.Errorf("Event leaks plaintext: %s", s)
+		}
+	}
+	want := []EventType{EventCreated, EventRevealed, EventCreated}
+	for i := 0; i < internal.MaxCodeFailures; i++ {
+		want = append(want, EventConfirmFailed)
+	}
+	want = append(want, EventBlocked, EventExpired)
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("Expected events %v, got %v", want, types)
+	}
+
+	last := events[len(events)-2]
+	if last.ID != id || last.IP != "10.0.0.9" {
+		t.Errorf("Expected blocked event for %s from 10.0.0.9, got %+v", id, last)
+	}
+	if ref := events[0].Ref(); len(ref) != 16 || strings.Contains(res.ID, ref) {
+		t.Errorf("Expected an opaque 16-character reference, got %q", ref)
+	}
+}
+
+func TestStore_ExpiryReportsEarlierViews(t *testing.T) {
+	store := NewStore()
+	var expired []Event
+	store.Events().Subscribe(func(e Event) {
+		if e.Type == EventExpired {
+			expired = append(expired, e)
+		}
+	})
+
+	read, _ := store.SaveWithOptions("x", SaveOptions{TTLMinutes: 5, Views: 3})
+	store.Take(read.ID)
+	unread, _ := store.SaveWithOptions("y", SaveOptions{TTLMinutes: 5, Views: 3})
+	// Let both run out now instead of in five minutes.
+	for _, id := range []string{read.ID, unread.ID} {
+		sec, _ := store.backend.Get(id)
+		sec.ExpiresAt = time.Now().Add(-time.Second)
+	}
+	store.CleanupExpired()
+
+	viewed := map[string]int{}
+	for _, e := range expired {
+		viewed[e.ID] = e.Viewed
+	}
+	if len(viewed) != 2 || viewed[read.ID] != 1 || viewed[unread.ID] != 0 {
+		t.Errorf("Expected one earlier view on the read secret only, got %v", viewed)
+	}
+}
+
+func TestStore_NotifyEmailIsEncrypted(t *testing.T) {
+	store := NewStore()
+	res, err := store.SaveWithOptions("value", SaveOptions{TTLMinutes: 5, NotifyEmail: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec, err := store.Get(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(fmt.Sprintf("%+v", sec), "alice@example.com") {
+		t.Error("Notify email is stored in plaintext")
+	}
+	if got, err := store.NotifyEmail(sec); err != nil || got != "alice@example.com" {
+		t.Errorf("Expected decrypted notify email, got %q, %v", got, err)
+	}
+
+	plain, _, _ := store.Save("other", 5, false)
+	sec, _ = store.Get(plain)
+	if got, err := store.NotifyEmail(sec); err != nil || got != "" {
+		t.Errorf("Expected no notify email, got %q, %v", got, err)
+	}
+}
+
+func TestStore_RecipientsAreEncrypted(t *testing.T) {
+	store := NewStore()
+	rules := Recipients{Emails: []string{"Bob@Example.com"}, Groups: []string{"ops"}}
+	res, err := store.SaveWithOptions("value", SaveOptions{TTLMinutes: 5, Recipients: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec, _ := store.Get(res.ID)
+	if !sec.IsRestricted() || strings.Contains(fmt.Sprintf("%+v", sec), "Example.com") {
+		t.Fatalf("Expected encrypted recipient restriction, got %+v", sec)
+	}
+	got, err := store.Recipients(sec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		email  string
+		groups []string
+		want   bool
+	}{
+		{"bob@example.com", nil, true},
+		{"alice@example.com", []string{"ops"}, true},
+		{"alice@example.com", []string{"dev"}, false},
+		{"", nil, false},
+	} {
+		if got.Allows(tc.email, tc.groups) != tc.want {
+			t.Errorf("Allows(%q, %v) != %v", tc.email, tc.groups, tc.want)
+		}
+	}
+}
+
+func TestSecret_AllowsIP(t *testing.T) {
+	open := &Secret{}
+	if !open.AllowsIP("203.0.113.9") {
+		t.Error("Expected a secret without networks to allow any address")
+	}
+
+	sec := &Secret{AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"::ffff:10.1.2.3": true,
+		"2001:db8::1":     true,
+		"192.168.1.1":     false,
+		"2001:db9::1":     false,
+		"not-an-ip":       false,
+		"":                false,
+	} {
+		if got := sec.AllowsIP(ip); got != want {
+			t.Errorf("AllowsIP(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestStore_KeyRotation(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	for i := range oldKey {
+		oldKey[i], newKey[i] = byte(i), byte(100+i)
+	}
+	backend := NewMemoryBackend()
+	oldRing, _ := NewKeyring(oldKey)
+	before := NewStoreWithKeys(backend, oldRing)
+
+	plain, err := before.SaveWithOptions("old secret", SaveOptions{TTLMinutes: 5, NotifyEmail: "me@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected, err := before.SaveWithOptions("old protected", SaveOptions{TTLMinutes: 5, Passphrase: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Records from before key IDs and envelope encryption were encrypted
+	// under the instance key directly.
+	legacyText, legacyNonce, _ := encrypt([]byte("pre-rotation"), oldKey, nil)
+	backend.Save("legacy", &Secret{
+		CipherText: base64.StdEncoding.EncodeToString(legacyText),
+		Nonce:      legacyNonce,
+		ExpiresAt:  time.Now().Add(5 * time.Minute),
+		Unlocked:   true,
+	})
+	salt, _ := generateSalt()
+	legacyText, legacyNonce, _ = encrypt([]byte("pre-rotation protected"), passphraseKey(oldKey, "hunter2", salt), nil)
+	backend.Save("legacy-protected", &Secret{
+		CipherText:     base64.StdEncoding.EncodeToString(legacyText),
+		Nonce:          legacyNonce,
+		PassphraseSalt: salt,
+		ExpiresAt:      time.Now().Add(5 * time.Minute),
+		Unlocked:       true,
+	})
+	// Enveloped records from before authenticated metadata can still be
+	// rewrapped, since their text is not bound to the key ID.
+	dk, _ := before.newDataKey()
+	legacyText, legacyNonce, _ = encrypt([]byte("enveloped protected"), passphraseKey(dk.key, "hunter2", salt), nil)
+	backend.Save("enveloped-protected", &Secret{
+		CipherText:     base64.StdEncoding.EncodeToString(legacyText),
+		Nonce:          legacyNonce,
+		KeyID:          dk.keyID,
+		WrappedKey:     dk.wrapped,
+		PassphraseSalt: salt,
+		ExpiresAt:      time.Now().Add(5 * time.Minute),
+		Unlocked:       true,
+	})
+
+	ring, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStoreWithKeys(backend, ring)
+	fresh, err := store.SaveWithOptions("new secret", SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sec, _ := store.Get(fresh.ID); sec.KeyID != KeyID(newKey) {
+		t.Errorf("Expected new secrets under the current key, got %q", sec.KeyID)
+	}
+
+	usage, err := store.KeyUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 3 || !usage[0].Current || usage[0].Secrets != 1 {
+		t.Fatalf("Unexpected usage before rekey: %+v", usage)
+	}
+
+	n, err := store.Rekey()
+	if err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 secrets resealed, got %d", n)
+	}
+	usage, _ = store.KeyUsage()
+	if len(usage) != 3 || usage[0].Secrets != 4 || usage[1].KeyID != "" || usage[1].Passphrase != 1 ||
+		usage[2].KeyID != KeyID(oldKey) || usage[2].Passphrase != 1 {
+		t.Errorf("Expected only the passphrase secrets bound to their key left behind: %+v", usage)
+	}
+
+	// Without the old key, resealed secrets still open.
+	newOnly, _ := NewKeyring(newKey)
+	after := NewStoreWithKeys(backend, newOnly)
+	for id, want := range map[string]string{plain.ID: "old secret", "legacy": "pre-rotation"} {
+		sec, _ := after.Get(id)
+		if text, err := after.DecryptSecretText(sec); err != nil || text != want {
+			t.Errorf("Expected %q after rekey, got %q, %v", want, text, err)
+		}
+	}
+	sec, _ := after.Get(plain.ID)
+	if email, err := after.NotifyEmail(sec); err != nil || email != "me@example.com" {
+		t.Errorf("Expected notify email to be resealed, got %q, %v", email, err)
+	}
+	if _, text, err := after.Unseal("enveloped-protected", "hunter2", "127.0.0.1"); err != nil || text != "enveloped protected" {
+		t.Errorf("Expected the enveloped passphrase secret to be rewrapped, got %q, %v", text, err)
+	}
+	for id, want := range map[string]string{protected.ID: "old protected", "legacy-protected": "pre-rotation protected"} {
+		if _, text, err := store.Unseal(id, "hunter2", "127.0.0.1"); err != nil || text != want {
+			t.Errorf("Expected the old key to open %s, got %q, %v", id, text, err)
+		}
+	}
+}
+
+func TestStore_EnvelopeEncryption(t *testing.T) {
+	store := NewStore()
+	a, err := store.SaveWithOptions("same text", SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.SaveWithOptions("same text", SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secA, _ := store.Get(a.ID)
+	secB, _ := store.Get(b.ID)
+	if len(secA.WrappedKey) == 0 || string(secA.WrappedKey) == string(secB.WrappedKey) {
+		t.Fatal("Expected every secret to get its own wrapped data key")
+	}
+
+	// Opening a secret with another secret's data key fails.
+	swapped := *secA
+	swapped.WrappedKey = secB.WrappedKey
+	if _, err := store.DecryptSecretText(&swapped); err == nil {
+		t.Error("Expected a foreign data key not to decrypt the secret")
+	}
+
+	// A copy of the ciphertext kept after deletion is useless without the
+	// wrapped key.
+	shredded := *secA
+	shredded.WrappedKey = nil
+	if err := store.Delete(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DecryptSecretText(&shredded); err == nil {
+		t.Error("Expected the ciphertext to be unrecoverable without its data key")
+	}
+}
+
+func TestStore_WrappedKeyIsNotAField(t *testing.T) {
+	backend := NewMemoryBackend()
+	keys, err := RandomKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStoreWithKeys(backend, keys)
+	res, _ := store.SaveWithOptions("victim", SaveOptions{TTLMinutes: 5})
+	victim, _ := backend.Get(res.ID)
+
+	// Someone with write access to the backend stores the victim's
+	// wrapped key as the text of a record from before envelope
+	// encryption, hoping to have it decrypted.
+	forged := &Secret{
+		ID:         "forged",
+		CipherText: base64.StdEncoding.EncodeToString(victim.WrappedKey[nonceSize:]),
+		Nonce:      victim.WrappedKey[:nonceSize],
+		KeyID:      victim.KeyID,
+		Unlocked:   true,
+		ExpiresAt:  victim.ExpiresAt,
+	}
+	if plain, err := store.DecryptSecret(forged); err == nil {
+		t.Fatalf("Expected a wrapped key not to open as a secret, got %d bytes", len(plain))
+	}
+}
+
+func TestStore_SwappedSecretFailsClosed(t *testing.T) {
+	backend := NewMemoryBackend()
+	keys, err := RandomKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStoreWithKeys(backend, keys)
+	a, _ := store.SaveWithOptions("for alice", SaveOptions{TTLMinutes: 5})
+	b, _ := store.SaveWithOptions("for bob", SaveOptions{TTLMinutes: 5, NotifyEmail: "bob@example.com"})
+
+	// Someone with write access to the backend copies Alice's record over
+	// Bob's, so Bob's link would show Alice's secret.
+	secA, _ := backend.Get(a.ID)
+	moved := *secA
+	backend.Save(b.ID, &moved)
+	sec, err := store.Get(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := store.DecryptSecretText(sec); !errors.Is(err, ErrTampered) || text != "" {
+		t.Errorf("Expected a moved record to fail with ErrTampered, got %q, %v", text, err)
+	}
+
+	// Within a record, one field cannot stand in for another.
+	c, _ := store.SaveWithOptions("text", SaveOptions{TTLMinutes: 5, NotifyEmail: "carol@example.com"})
+	secC, _ := store.Get(c.ID)
+	fields := *secC
+	fields.CipherText, fields.Nonce = secC.NotifyEmail, secC.NotifyNonce
+	if _, err := store.DecryptSecretText(&fields); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected the notify email not to open as the text, got %v", err)
+	}
+}
+
+func TestStore_TamperedMetadataFailsClosed(t *testing.T) {
+	store := NewStore()
+	res, err := store.SaveWithOptions("needs approval", SaveOptions{TTLMinutes: 5, WithApproval: true, Recipients: Recipients{Emails: []string{"a@example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := store.Get(res.ID)
+	if !strings.HasPrefix(orig.CipherText, sealFormat+":") {
+		t.Fatalf("Expected a versioned ciphertext, got %q", orig.CipherText[:8])
+	}
+	if text, err := store.DecryptSecretText(orig); err != nil || text != "needs approval" {
+		t.Fatalf("Expected the untouched record to open, got %q, %v", text, err)
+	}
+
+	for name, tamper := range map[string]func(*Secret){
+		"extended expiry":  func(s *Secret) { s.ExpiresAt = s.ExpiresAt.Add(24 * time.Hour) },
+		"approval removed": func(s *Secret) { s.Code, s.Unlocked = "", true },
+		"made opaque":      func(s *Secret) { s.Opaque = true },
+		"made a file":      func(s *Secret) { s.FileName = "secret.txt" },
+		"key ID changed":   func(s *Secret) { s.KeyID = strings.Repeat("0", 16) },
+	} {
+		sec := *orig
+		tamper(&sec)
+		if text, err := store.DecryptSecretText(&sec); err == nil || text != "" {
+			t.Errorf("%s: expected decryption to fail, got %q", name, text)
+		}
+		if _, err := store.Recipients(&sec); err == nil {
+			t.Errorf("%s: expected the recipients not to open", name)
+		}
+	}
+}
+
+func TestStore_RollbackFailsClosed(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	for i := range oldKey {
+		oldKey[i], newKey[i] = byte(i), byte(100+i)
+	}
+	backend := NewMemoryBackend()
+	oldRing, _ := NewKeyring(oldKey)
+	res, err := NewStoreWithKeys(backend, oldRing).SaveWithOptions("rotated", SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := backend.Get(res.ID)
+	before = withID(res.ID, before)
+
+	// Presenting a versioned field as one from before authenticated
+	// metadata does not skip the check.
+	downgraded := *before
+	downgraded.CipherText = strings.TrimPrefix(before.CipherText, sealFormat+":")
+	if _, err := NewStoreWithKeys(backend, oldRing).DecryptSecretText(&downgraded); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected a stripped format version to fail with ErrTampered, got %v", err)
+	}
+	unknown := *before
+	unknown.CipherText = "v9:" + strings.TrimPrefix(before.CipherText, sealFormat+":")
+	if _, err := NewStoreWithKeys(backend, oldRing).DecryptSecretText(&unknown); !errors.Is(err, errUnknownFormat) {
+		t.Errorf("Expected an unknown format to be refused, got %v", err)
+	}
+	unwrapped := *before
+	unwrapped.WrappedKey, unwrapped.KeyID = nil, ""
+	if _, err := NewStoreWithKeys(backend, oldRing).DecryptSecretText(&unwrapped); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected a versioned record without a data key to fail with ErrTampered, got %v", err)
+	}
+
+	// After a rekey, rolling the wrapped key back to the retired key does
+	// not match the key ID the fields are now bound to.
+	ring, _ := NewKeyring(newKey, oldKey)
+	store := NewStoreWithKeys(backend, ring)
+	if n, err := store.Rekey(); err != nil || n != 1 {
+		t.Fatalf("Expected one secret resealed, got %d, %v", n, err)
+	}
+	after, _ := store.Get(res.ID)
+	rolledBack := *after
+	rolledBack.KeyID, rolledBack.WrappedKey = before.KeyID, before.WrappedKey
+	if _, err := store.DecryptSecretText(&rolledBack); !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected a rolled back data key to fail with ErrTampered, got %v", err)
+	}
+	if text, err := store.DecryptSecretText(after); err != nil || text != "rotated" {
+		t.Errorf("Expected the resealed secret to open, got %q, %v", text, err)
+	}
+}
+
+func TestStore_RekeySkipsBrokenRecords(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	for i := range oldKey {
+		oldKey[i], newKey[i] = byte(i), byte(100+i)
+	}
+	backend := NewMemoryBackend()
+	oldRing, _ := NewKeyring(oldKey)
+	before := NewStoreWithKeys(backend, oldRing)
+	var ids []string
+	for _, text := range []string{"a", "b", "c"} {
+		res, _ := before.SaveWithOptions(text, SaveOptions{TTLMinutes: 5})
+		ids = append(ids, res.ID)
+	}
+	broken, _ := backend.Get(ids[1])
+	broken.WrappedKey = []byte("not a wrapped key")
+
+	ring, _ := NewKeyring(newKey, oldKey)
+	store := NewStoreWithKeys(backend, ring)
+	n, err := store.Rekey()
+	if err == nil || n != 2 {
+		t.Fatalf("Expected two secrets resealed and an error for the third, got %d, %v", n, err)
+	}
+	if strings.Contains(err.Error(), ids[1]) {
+		t.Errorf("Expected the error not to reveal the secret's ID: %v", err)
+	}
+	for _, id := range []string{ids[0], ids[2]} {
+		if sec, _ := store.Get(id); sec.KeyID != KeyID(newKey) {
+			t.Errorf("Expected %s under the new key, got %q", id, sec.KeyID)
+		}
+	}
+}
+
+func TestNewDataKey_IsWiped(t *testing.T) {
+	store := NewStore()
+	dk, err := store.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := dk.key
+	dk.wipe()
+	for _, b := range key {
+		if b != 0 {
+			t.Fatal("Expected the data key to be zeroed")
+		}
+	}
+}
+
+// recordingKeys hands out the data keys it unwraps so tests can check
+// that they are wiped after use.
+type recordingKeys struct {
+	*Keyring
+	unwrapped [][]byte
+}
+
+func (k *recordingKeys) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	dek, err := k.Keyring.Unwrap(keyID, wrapped)
+	if err == nil {
+		k.unwrapped = append(k.unwrapped, dek)
+	}
+	return dek, err
+}
+
+func TestStore_DecryptWipesBuffers(t *testing.T) {
+	ring, _ := NewKeyring(bytes.Repeat([]byte{7}, 32))
+	keys := &recordingKeys{Keyring: ring}
+	store := NewStoreWithKeys(NewMemoryBackend(), keys)
+
+	res, err := store.SaveSecret([]byte("plaintext"), SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec, _ := store.Get(res.ID)
+	text, err := store.DecryptSecret(sec)
+	if err != nil || string(text) != "plaintext" {
+		t.Fatalf("Expected the secret to decrypt, got %q, %v", text, err)
+	}
+	if len(keys.unwrapped) == 0 {
+		t.Fatal("Expected the data key to be unwrapped")
+	}
+	for _, dek := range keys.unwrapped {
+		if !bytes.Equal(dek, make([]byte, len(dek))) {
+			t.Error("Expected the unwrapped data key to be zeroed after decryption")
+		}
+	}
+
+	secmem.Free(text)
+	if !bytes.Equal(text, make([]byte, len(text))) {
+		t.Errorf("Expected the plaintext to be zeroed, got %q", text)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	cur := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	prev := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("p", 32)))
+	ring, err := ParseKeyring(cur, " "+prev+", ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := ring.CurrentKeyID(); id != KeyID(make([]byte, 32)) || len(ring.keys) != 2 {
+		t.Errorf("Unexpected keyring: current %q, %d keys", id, len(ring.keys))
+	}
+	if _, err := ParseKeyring(cur, "short"); err == nil {
+		t.Error("Expected an invalid previous key to be rejected")
+	}
+}
+
+func TestKeyFile_SealAndOpen(t *testing.T) {
+	cur := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("c", 32)))
+	prev := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("p", 32)))
+	keyFile := []byte("# rotated 2026-10\n" + cur + "\n\n" + prev + "\n")
+
+	ring, err := ParseKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := ring.CurrentKeyID(); id != KeyID([]byte(strings.Repeat("c", 32))) || len(ring.keys) != 2 {
+		t.Errorf("Unexpected keyring from key file: %q, %d keys", id, len(ring.keys))
+	}
+
+	sealed, err := SealKeyFile(keyFile, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), cur) {
+		t.Fatal("Expected the sealed file not to contain the key")
+	}
+	opened, err := OpenKeyFile(sealed, "correct horse")
+	if err != nil {
+		t.Fatalf("OpenKeyFile failed: %v", err)
+	}
+	if id, _ := opened.CurrentKeyID(); id != KeyID([]byte(strings.Repeat("c", 32))) {
+		t.Errorf("Expected the same current key, got %q", id)
+	}
+	if _, err := OpenKeyFile(sealed, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	weakened := strings.Replace(string(sealed), `"time": 3`, `"time": 1`, 1)
+	if _, err := OpenKeyFile([]byte(weakened), "correct horse"); err == nil {
+		t.Error("Expected changed KDF parameters to be detected")
+	}
+	if _, err := ParseKeyFile([]byte("not-base64\n")); err == nil {
+		t.Error("Expected an invalid key line to be rejected")
+	}
+}
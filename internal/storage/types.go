@@ -1,15 +1,307 @@
 package storage
 
 import (
+	"net/netip"
+	"slices"
+	"strings"
 	"time"
 )
 
 type Secret struct {
-	CipherText  string
-	Nonce       []byte
-	ExpiresAt   time.Time
+	// ID is the ID the record was stored or looked up under. It is set by
+	// the Store and never persisted, so a record moved to another ID does
+	// not carry its old one along.
+	ID string `json:"-"`
+	// CipherText and the other sealed fields are base64, prefixed with
+	// their format version ("v1:") since metadata is authenticated.
+	CipherText string
+	Nonce      []byte
+	// KeyID names the instance key that CipherText, NotifyEmail and
+	// Recipients are encrypted under, directly or through WrappedKey.
+	// Records from before key rotation have none.
+	KeyID string
+	// WrappedKey is the secret's own data key, sealed under the instance
+	// key as nonce || ciphertext. The encrypted fields can only be read
+	// with it, so once a record is deleted, copies of its ciphertext are
+	// useless. Records from before envelope encryption have none and are
+	// encrypted under the instance key directly.
+	WrappedKey []byte
+	ExpiresAt  time.Time
+	Code       string
+	Unlocked   bool
+	// Opaque marks a zero-knowledge secret: the stored text is ciphertext
+	// produced in the browser, whose key never reaches the server.
+	Opaque bool
+	// FileName is set for file attachments, which are served as a
+	// download instead of being rendered as text.
+	FileName    string
+	ContentType string
+	// PassphraseSalt is set when the secret is encrypted under a key
+	// derived from a passphrase chosen by the sender. BurnAfter, if set,
+	// deletes the secret after that many wrong passphrases.
+	PassphraseSalt []byte
+	BurnAfter      int
+	// Views is the number of reveals left before the secret is deleted.
+	// Records written before multi-view support have zero, which counts
+	// as a single view.
+	Views int
+	// Awaiting marks a requested secret whose holder has not submitted it
+	// yet. DropFor is set on the one-time drop record handed to the
+	// holder and names the awaiting secret it fills.
+	Awaiting bool
+	DropFor  string
+	// ShareSet and Threshold are set on a Shamir share: Threshold shares
+	// of the same set recover the original secret through Store.Combine.
+	ShareSet  string
+	Threshold int
+	// NotifyEmail is the sender's address for read notifications,
+	// encrypted under the instance key with NotifyNonce.
+	NotifyEmail string
+	NotifyNonce []byte
+	// Creator is the identity provider subject of the logged-in sender, or
+	// "apikey:" and the key's name for API requests made without a login,
+	// kept for audit.
+	Creator string
+	// Recipients, if set, restricts who may open the secret. It holds the
+	// JSON of a Recipients value encrypted with RecipientsNonce.
+	Recipients      string
+	RecipientsNonce []byte
+	// AllowedCIDRs, if set, are the only networks the secret can be
+	// revealed or confirmed from.
+	AllowedCIDRs []string
+}
+
+// setSealed copies the fields encrypted under the instance key from src.
+func (s *Secret) setSealed(src *Secret) {
+	s.KeyID, s.WrappedKey = src.KeyID, src.WrappedKey
+	s.CipherText, s.Nonce = src.CipherText, src.Nonce
+	s.NotifyEmail, s.NotifyNonce = src.NotifyEmail, src.NotifyNonce
+	s.Recipients, s.RecipientsNonce = src.Recipients, src.RecipientsNonce
+}
+
+// hasSealed reports whether anything on sec is encrypted under an
+// instance key. A request still awaiting its secret has nothing yet.
+func (s *Secret) hasSealed() bool {
+	return s.CipherText != "" || s.NotifyEmail != "" || s.Recipients != ""
+}
+
+// canReseal is false for passphrase-protected records whose text is tied
+// to the key they are under: records from before envelope encryption mix
+// the instance key into it, and sealed fields bind its key ID. Neither can
+// be changed without the passphrase.
+func (s *Secret) canReseal() bool {
+	if !s.HasPassphrase() {
+		return true
+	}
+	return s.WrappedKey != nil && !isVersioned(s.CipherText)
+}
+
+// outdated reports whether Rekey can bring any part of s up to the
+// current format.
+func (s *Secret) outdated() bool {
+	if s.WrappedKey == nil {
+		return true
+	}
+	if s.CipherText != "" && !s.HasPassphrase() && !isVersioned(s.CipherText) {
+		return true
+	}
+	return (s.NotifyEmail != "" && !isVersioned(s.NotifyEmail)) ||
+		(s.Recipients != "" && !isVersioned(s.Recipients))
+}
+
+// mode lists the properties fixed at creation that decide how the secret
+// is handed out. It is part of the authenticated data of every sealed
+// field, so they cannot be stripped from a stored record.
+func (s *Secret) mode() string {
+	var mode []string
+	if s.Code != "" {
+		mode = append(mode, "approval")
+	}
+	if s.Opaque {
+		mode = append(mode, "opaque")
+	}
+	if s.IsFile() {
+		mode = append(mode, "file")
+	}
+	if s.HasPassphrase() {
+		mode = append(mode, "passphrase")
+	}
+	if s.IsShare() {
+		mode = append(mode, "share")
+	}
+	if s.IsDrop() {
+		mode = append(mode, "drop")
+	}
+	return strings.Join(mode, ",")
+}
+
+func (s *Secret) IsFile() bool {
+	return s.FileName != ""
+}
+
+func (s *Secret) HasPassphrase() bool {
+	return len(s.PassphraseSalt) > 0
+}
+
+func (s *Secret) IsShare() bool {
+	return s.Threshold > 0
+}
+
+func (s *Secret) IsDrop() bool {
+	return s.DropFor != ""
+}
+
+func (s *Secret) IsRestricted() bool {
+	return s.Recipients != ""
+}
+
+// AllowsIP reports whether ip is inside AllowedCIDRs, or true if the
+// secret has no allowlist. Unparseable addresses are never allowed.
+func (s *Secret) AllowsIP(ip string) bool {
+	if len(s.AllowedCIDRs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, cidr := range s.AllowedCIDRs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recipients are the identities allowed to open a secret: anyone with one
+// of the verified emails or in one of the groups.
+type Recipients struct {
+	Emails []string `json:"emails,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+func (r Recipients) IsZero() bool {
+	return len(r.Emails) == 0 && len(r.Groups) == 0
+}
+
+func (r Recipients) Allows(email string, groups []string) bool {
+	if email != "" && slices.ContainsFunc(r.Emails, func(e string) bool { return strings.EqualFold(e, email) }) {
+		return true
+	}
+	for _, g := range groups {
+		if slices.Contains(r.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Secret) ViewsLeft() int {
+	if s.Views < 1 {
+		return 1
+	}
+	return s.Views
+}
+
+type SaveOptions struct {
+	TTLMinutes   int
+	WithApproval bool
+	Opaque       bool
+	FileName     string
+	ContentType  string
+	Views        int
+	Passphrase   string
+	BurnAfter    int
+	// NotifyEmail, if set, is told when the secret is read or expires.
+	NotifyEmail string
+	Creator     string
+	Recipients  Recipients
+	// AllowedCIDRs must already be validated prefixes.
+	AllowedCIDRs []string
+
+	shareSet  string
+	threshold int
+}
+
+type ShareOptions struct {
+	TTLMinutes int
+	// Shares is the number of links created; Threshold of them are needed
+	// to recover the secret.
+	Shares    int
+	Threshold int
+	Creator   string
+}
+
+// SaveResult is what the sender learns when a secret is created. The
+// management token is only returned here; the store keeps its hash.
+type SaveResult struct {
+	ID          string
 	Code        string
-	Unlocked    bool
-	WaitingCh   chan struct{}
-	listenerSet bool
+	ManageToken string
+	ExpiresAt   time.Time
+}
+
+type RequestOptions struct {
+	TTLMinutes int
+	// Note tells the holder what is being asked for. It is stored
+	// encrypted on the drop record.
+	Note    string
+	Creator string
+}
+
+// RequestResult holds the requester's secret ID and the drop ID for the
+// holder. Only the requester's ID can reveal the secret once submitted.
+type RequestResult struct {
+	ID        string
+	DropID    string
+	ExpiresAt time.Time
+}
+
+const (
+	ReceiptPending = "pending"
+	ReceiptViewed  = "viewed"
+	ReceiptExpired = "expired"
+	ReceiptRevoked = "revoked"
+	ReceiptBurned  = "burned"
+)
+
+// Receipt tells the sender what happened to a secret. It never holds
+// ciphertext and is kept until RetainUntil, after the secret is gone.
+type Receipt struct {
+	TokenHash   string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	RetainUntil time.Time
+	Views       int
+	ViewedAt    []time.Time
+	RevokedAt   time.Time
+	Burned      bool
+}
+
+func (r *Receipt) Status() string {
+	switch {
+	case r.Burned:
+		return ReceiptBurned
+	case !r.RevokedAt.IsZero():
+		return ReceiptRevoked
+	case len(r.ViewedAt) >= r.Views:
+		return ReceiptViewed
+	case time.Now().After(r.ExpiresAt):
+		return ReceiptExpired
+	}
+	return ReceiptPending
+}
+
+func (r *Receipt) ViewsLeft() int {
+	if r.Status() != ReceiptPending {
+		return 0
+	}
+	return r.Views - len(r.ViewedAt)
+}
+
+func (r *Receipt) clone() *Receipt {
+	cp := *r
+	cp.ViewedAt = append([]time.Time(nil), r.ViewedAt...)
+	return &cp
 }
@@ -0,0 +1,187 @@
+// Package vault wraps data keys with the transit secrets engine of
+// HashiCorp Vault, or any server that speaks its HTTP API, so the master
+// key never leaves it.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+const requestTimeout = 10 * time.Second
+
+type Config struct {
+	// Addr is the server URL, e.g. https://vault.example.com:8200.
+	Addr string
+	// Token is sent as X-Vault-Token. It may be empty behind a Vault
+	// agent that adds it.
+	Token     string
+	Namespace string
+	// Mount is the path of the transit engine, "transit" by default.
+	Mount string
+	// Key is the name of the transit key data keys are wrapped with.
+	Key        string
+	HTTPClient *http.Client
+}
+
+// Transit is a storage.KeyProvider backed by a transit key. Key IDs have
+// the form "vault:<key>:v<version>", so secrets can be resealed after the
+// transit key is rotated.
+type Transit struct {
+	base   string
+	token  string
+	ns     string
+	key    string
+	client *http.Client
+}
+
+var _ storage.KeyProvider = (*Transit)(nil)
+
+func NewTransit(cfg Config) (*Transit, error) {
+	u, err := url.Parse(cfg.Addr)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("vault: address must be an http(s) URL")
+	}
+	if cfg.Key == "" || strings.Contains(cfg.Key, "/") {
+		return nil, errors.New("vault: invalid transit key name")
+	}
+	mount := strings.Trim(cfg.Mount, "/")
+	if mount == "" {
+		mount = "transit"
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	return &Transit{
+		base:   strings.TrimRight(cfg.Addr, "/") + "/v1/" + mount,
+		token:  cfg.Token,
+		ns:     cfg.Namespace,
+		key:    cfg.Key,
+		client: client,
+	}, nil
+}
+
+func (t *Transit) Wrap(dataKey []byte) (string, []byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	if err := t.do(http.MethodPost, "/encrypt/"+t.key, req, &resp); err != nil {
+		return "", nil, err
+	}
+	version, ok := ciphertextVersion(resp.Data.Ciphertext)
+	if !ok {
+		return "", nil, errors.New("vault: unexpected ciphertext format")
+	}
+	return t.keyID(version), []byte(resp.Data.Ciphertext), nil
+}
+
+func (t *Transit) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	version, ok := ciphertextVersion(string(wrapped))
+	if !ok || keyID != t.keyID(version) {
+		return nil, storage.ErrUnknownKey
+	}
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := t.do(http.MethodPost, "/decrypt/"+t.key, map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+// CurrentKeyID asks for the latest version of the transit key.
+func (t *Transit) CurrentKeyID() (string, error) {
+	var resp struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+		} `json:"data"`
+	}
+	if err := t.do(http.MethodGet, "/keys/"+t.key, nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.Data.LatestVersion < 1 {
+		return "", errors.New("vault: transit key has no versions")
+	}
+	return t.keyID(resp.Data.LatestVersion), nil
+}
+
+func (t *Transit) keyID(version int) string {
+	return "vault:" + t.key + ":v" + strconv.Itoa(version)
+}
+
+// ciphertextVersion reads N from a "vault:vN:..." ciphertext.
+func ciphertextVersion(ciphertext string) (int, bool) {
+	rest, ok := strings.CutPrefix(ciphertext, "vault:v")
+	if !ok {
+		return 0, false
+	}
+	v, _, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(v)
+	return version, err == nil && version > 0
+}
+
+func (t *Transit) do(method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, t.base+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.token != "" {
+		req.Header.Set("X-Vault-Token", t.token)
+	}
+	if t.ns != "" {
+		req.Header.Set("X-Vault-Namespace", t.ns)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(data, &e)
+		return fmt.Errorf("vault: %s %s: %d %s", method, path, resp.StatusCode, strings.Join(e.Errors, "; "))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("vault: invalid response: %w", err)
+	}
+	return nil
+}
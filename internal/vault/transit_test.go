@@ -0,0 +1,175 @@
+package vault_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"whisperbin/internal/storage"
+	"whisperbin/internal/vault"
+)
+
+const testToken = "s.test-token"
+
+// transitServer is a stand-in for Vault's transit engine with one key
+// that can be rotated.
+type transitServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	versions [][]byte
+}
+
+func newTransitServer(t *testing.T) *transitServer {
+	s := &transitServer{}
+	s.rotate()
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *transitServer) rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := make([]byte, 32)
+	rand.Read(key)
+	s.versions = append(s.versions, key)
+}
+
+func (s *transitServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != testToken {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var req struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	reply := func(data map[string]any) {
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /v1/transit/keys/whisperbin":
+		reply(map[string]any{"latest_version": len(s.versions)})
+	case "POST /v1/transit/encrypt/whisperbin":
+		plain, err := base64.StdEncoding.DecodeString(req.Plaintext)
+		if err != nil {
+			http.Error(w, `{"errors":["invalid plaintext"]}`, http.StatusBadRequest)
+			return
+		}
+		version := len(s.versions)
+		gcm := newGCM(s.versions[version-1])
+		nonce := make([]byte, gcm.NonceSize())
+		rand.Read(nonce)
+		sealed := gcm.Seal(nonce, nonce, plain, nil)
+		reply(map[string]any{"ciphertext": fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(sealed)), "key_version": version})
+	case "POST /v1/transit/decrypt/whisperbin":
+		parts := strings.SplitN(req.Ciphertext, ":", 3)
+		version, _ := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+		sealed, _ := base64.StdEncoding.DecodeString(parts[2])
+		if version < 1 || version > len(s.versions) || len(sealed) < 12 {
+			http.Error(w, `{"errors":["invalid ciphertext"]}`, http.StatusBadRequest)
+			return
+		}
+		plain, err := newGCM(s.versions[version-1]).Open(nil, sealed[:12], sealed[12:], nil)
+		if err != nil {
+			http.Error(w, `{"errors":["cipher: message authentication failed"]}`, http.StatusBadRequest)
+			return
+		}
+		reply(map[string]any{"plaintext": base64.StdEncoding.EncodeToString(plain)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+func newTransit(t *testing.T, addr, token string) *vault.Transit {
+	t.Helper()
+	tr, err := vault.NewTransit(vault.Config{Addr: addr, Token: token, Key: "whisperbin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTransit_StoreRoundTrip(t *testing.T) {
+	server := newTransitServer(t)
+	store := storage.NewStoreWithKeys(storage.NewMemoryBackend(), newTransit(t, server.URL, testToken))
+
+	res, err := store.SaveWithOptions("kept in vault", storage.SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	sec, _ := store.Get(res.ID)
+	if sec.KeyID != "vault:whisperbin:v1" || !strings.HasPrefix(string(sec.WrappedKey), "vault:v1:") {
+		t.Errorf("Unexpected key ID %q or wrapped key", sec.KeyID)
+	}
+	if text, err := store.DecryptSecretText(sec); err != nil || text != "kept in vault" {
+		t.Errorf("Expected round trip through transit, got %q, %v", text, err)
+	}
+
+	// A different key ID than the ciphertext names is refused up front.
+	forged := *sec
+	forged.KeyID = "vault:other:v1"
+	if _, err := store.DecryptSecretText(&forged); !errors.Is(err, storage.ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for a foreign key ID, got %v", err)
+	}
+}
+
+func TestTransit_RotationAndRekey(t *testing.T) {
+	server := newTransitServer(t)
+	transit := newTransit(t, server.URL, testToken)
+	store := storage.NewStoreWithKeys(storage.NewMemoryBackend(), transit)
+
+	res, err := store.SaveWithOptions("before rotation", storage.SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.rotate()
+	if id, err := transit.CurrentKeyID(); err != nil || id != "vault:whisperbin:v2" {
+		t.Fatalf("Expected v2 after rotation, got %q, %v", id, err)
+	}
+
+	n, err := store.Rekey()
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one secret rewrapped, got %d, %v", n, err)
+	}
+	sec, _ := store.Get(res.ID)
+	if sec.KeyID != "vault:whisperbin:v2" {
+		t.Errorf("Expected the secret on v2, got %q", sec.KeyID)
+	}
+	if text, err := store.DecryptSecretText(sec); err != nil || text != "before rotation" {
+		t.Errorf("Expected the secret to open after rekey, got %q, %v", text, err)
+	}
+}
+
+func TestTransit_RejectedToken(t *testing.T) {
+	server := newTransitServer(t)
+	transit := newTransit(t, server.URL, "wrong")
+	if _, _, err := transit.Wrap(make([]byte, 32)); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+	if _, err := vault.NewTransit(vault.Config{Addr: "vault.example.com", Key: "k"}); err == nil {
+		t.Error("Expected an address without scheme to be rejected")
+	}
+}
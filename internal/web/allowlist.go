@@ -0,0 +1,49 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"whisperbin/internal"
+	"whisperbin/internal/storage"
+)
+
+var errNetworkDenied = errors.New("not available from this network")
+
+// parseCIDRs validates the networks a sender limits a secret to. A bare
+// address is taken as a single-host prefix.
+func parseCIDRs(values []string) ([]string, error) {
+	var cidrs []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		var prefix netip.Prefix
+		if addr, err := netip.ParseAddr(v); err == nil {
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		} else if prefix, err = netip.ParsePrefix(v); err != nil {
+			return nil, fmt.Errorf("invalid network %q", v)
+		}
+		cidrs = append(cidrs, prefix.Masked().String())
+	}
+	if len(cidrs) > internal.MaxCIDRs {
+		return nil, fmt.Errorf("at most %d networks", internal.MaxCIDRs)
+	}
+	return cidrs, nil
+}
+
+// checkNetwork enforces the secret's allowlist against the client address,
+// which honours TRUST_PROXY like rate limiting does.
+func (h *Handler) checkNetwork(r *http.Request, sec *storage.Secret) error {
+	if !sec.AllowsIP(h.clientIP(r)) {
+		return errNetworkDenied
+	}
+	return nil
+}
+
+func (h *Handler) renderNetworkError(w http.ResponseWriter) {
+	h.renderError(w, http.StatusForbidden, "Not Available Here", "This secret can only be opened from specific networks, such as the office VPN. It has not been opened.")
+}
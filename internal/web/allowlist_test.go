@@ -0,0 +1,121 @@
+package web
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"whisperbin/internal/storage"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	got, err := parseCIDRs([]string{"10.1.2.3/8", " 192.0.2.7 ", "", "2001:db8::1/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::/32"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, err := parseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an invalid prefix to be rejected")
+	}
+	if _, err := parseCIDRs([]string{"office"}); err == nil {
+		t.Error("Expected a name to be rejected")
+	}
+}
+
+func TestAllowlist_DeniesOtherNetworks(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("vpn only", storage.SaveOptions{TTLMinutes: 5, AllowedCIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithTemplates(store, projectRootPath("ui/templates/*.html"))
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	token := "test-csrf-token"
+	resp, body := postWithCSRF(t, server.URL+"/"+res.ID, token, url.Values{})
+	if resp.StatusCode != http.StatusForbidden || strings.Contains(body, "vpn only") {
+		t.Errorf("Expected 403 from outside the allowlist, got %d", resp.StatusCode)
+	}
+
+	apiResp := apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+res.ID+"/reveal", "")
+	var apiErr apiError
+	decodeJSON(t, apiResp, &apiErr)
+	if apiResp.StatusCode != http.StatusForbidden || apiErr.Code != "network_denied" {
+		t.Errorf("Expected API reveal to be refused, got %d %q", apiResp.StatusCode, apiErr.Code)
+	}
+
+	apiResp = apiRequest(t, "GET", server.URL+"/api/v1/secrets/"+res.ID, "")
+	apiErr = apiError{}
+	decodeJSON(t, apiResp, &apiErr)
+	if apiResp.StatusCode != http.StatusForbidden || apiErr.Code != "network_denied" {
+		t.Errorf("Expected API metadata to be refused, got %d %q", apiResp.StatusCode, apiErr.Code)
+	}
+
+	if _, err := store.Get(res.ID); err != nil {
+		t.Fatalf("Expected the secret to survive a denied reveal: %v", err)
+	}
+
+	// Behind a trusted proxy the forwarded address is what counts.
+	h.trustProxy = true
+	form := url.Values{"csrf_token": {token}}
+	req, _ := http.NewRequest("POST", server.URL+"/"+res.ID, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "vpn only") {
+		t.Errorf("Expected reveal from the allowed network, got %d", resp.StatusCode)
+	}
+}
+
+func TestAllowlist_GuardsSecureFlow(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("vpn only", storage.SaveOptions{TTLMinutes: 5, WithApproval: true, AllowedCIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithTemplates(store, projectRootPath("ui/templates/*.html"))
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/sse?id="+res.ID, nil)
+	req.Header.Set("Origin", h.allowedOrigin)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	resp.Body.Close()
+	if !strings.Contains(line, "error: "+errNetworkDenied.Error()) {
+		t.Errorf("Expected SSE to be refused, got %q", line)
+	}
+
+	resp, err = http.Get(server.URL + "/confirm/" + res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for the passcode page, got %d", resp.StatusCode)
+	}
+
+	resp, _ = postWithCSRF(t, server.URL+"/confirm/"+res.ID, "test-csrf-token", url.Values{"code": {res.Code}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for confirm, got %d", resp.StatusCode)
+	}
+	if sec, err := store.Get(res.ID); err != nil || sec.Unlocked {
+		t.Errorf("Expected the secret to stay locked, got %v", err)
+	}
+}
@@ -0,0 +1,673 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"whisperbin/internal"
+	"whisperbin/internal/secmem"
+	"whisperbin/internal/storage"
+)
+
+const (
+	apiPrefix       = "/api/v1/secrets"
+	sharesPrefix    = "/api/v1/shares"
+	maxAPIBodyBytes = 64 << 10
+)
+
+type apiCreateRequest struct {
+	Secret string `json:"secret"`
+	TTL    int    `json:"ttl"`
+	Secure bool   `json:"secure"`
+	Views  int    `json:"views"`
+	Opaque bool   `json:"opaque"`
+
+	Passphrase string `json:"passphrase"`
+	BurnAfter  int    `json:"burn_after"`
+
+	NotifyEmail string `json:"notify_email"`
+
+	Recipients      []string `json:"recipients"`
+	RecipientGroups []string `json:"recipient_groups"`
+	AllowedNetworks []string `json:"allowed_networks"`
+}
+
+type apiCreateResponse struct {
+	ID        string    `json:"id"`
+	Link      string    `json:"link"`
+	Passcode  string    `json:"passcode,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ManageToken authorizes the receipt and revoke endpoints. It is only
+	// returned once.
+	ManageToken string `json:"manage_token"`
+	ManageLink  string `json:"manage_link"`
+}
+
+type apiSharesRequest struct {
+	Secret    string `json:"secret"`
+	TTL       int    `json:"ttl"`
+	Shares    int    `json:"shares"`
+	Threshold int    `json:"threshold"`
+}
+
+type apiSharesResponse struct {
+	Threshold int                 `json:"threshold"`
+	ExpiresAt time.Time           `json:"expires_at"`
+	Shares    []apiCreateResponse `json:"shares"`
+}
+
+type apiCombineRequest struct {
+	// Links are share links or bare share IDs.
+	Links []string `json:"links"`
+}
+
+type apiMetadata struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Secure    bool      `json:"secure"`
+	Unlocked  bool      `json:"unlocked"`
+	Opaque    bool      `json:"opaque"`
+	// Passphrase tells the recipient that reveal needs a passphrase.
+	Passphrase bool `json:"passphrase"`
+	// Passcode is shown to the recipient while waiting for approval, just
+	// like waiting.html does.
+	Passcode       string `json:"passcode,omitempty"`
+	FileName       string `json:"file_name,omitempty"`
+	ContentType    string `json:"content_type,omitempty"`
+	ViewsRemaining int    `json:"views_remaining"`
+	// Threshold is set on Shamir shares, which are only revealed through
+	// the combine endpoint.
+	Threshold int `json:"threshold,omitempty"`
+}
+
+type apiReceipt struct {
+	ID             string      `json:"id"`
+	Status         string      `json:"status"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ExpiresAt      time.Time   `json:"expires_at"`
+	Views          int         `json:"views"`
+	ViewsRemaining int         `json:"views_remaining"`
+	ViewedAt       []time.Time `json:"viewed_at"`
+	RevokedAt      *time.Time  `json:"revoked_at,omitempty"`
+}
+
+type apiRevealRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type apiConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+type apiRevealResponse struct {
+	Secret      string `json:"secret"`
+	Opaque      bool   `json:"opaque"`
+	FileName    string `json:"file_name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Encoding is "base64" for file attachments, whose content may be
+	// binary.
+	Encoding string `json:"encoding,omitempty"`
+	// ViewsRemaining counts the reveals left after this one.
+	ViewsRemaining int `json:"views_remaining"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// apiHandler serves POST /api/v1/secrets.
+func (h *Handler) apiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limits, creator, ok := h.apiAuthorizeCreation(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiCreateRequest
+	bodyLimit := max(maxAPIBodyBytes, 2*maxSecretLength(limits.maxSecretBytes, true))
+	if !decodeAPIRequest(w, r, &req, int64(bodyLimit)) {
+		return
+	}
+
+	req.Secret = strings.TrimSpace(req.Secret)
+	if req.Secret == "" {
+		writeAPIError(w, http.StatusBadRequest, "secret is required")
+		return
+	}
+	if len(req.Secret) > maxSecretLength(limits.maxSecretBytes, req.Opaque) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "secret too large")
+		return
+	}
+	if req.Opaque && !validOpaqueBlob([]byte(req.Secret)) {
+		writeAPIError(w, http.StatusBadRequest, "opaque secret must be base64url(nonce || AES-GCM ciphertext)")
+		return
+	}
+	if req.Passphrase != "" && req.Secure {
+		writeAPIError(w, http.StatusBadRequest, "secure mode and passphrase cannot be combined")
+		return
+	}
+	if len(req.Passphrase) > internal.MaxPassphraseBytes || req.BurnAfter < 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid passphrase options")
+		return
+	}
+	if req.Views < 0 || req.Views > internal.MaxViews {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("views must be between 1 and %d", internal.MaxViews))
+		return
+	}
+
+	notifyEmail, err := h.parseNotifyEmail(req.NotifyEmail)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	recipients, err := h.parseRecipients(req.Recipients, req.RecipientGroups)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	networks, err := parseCIDRs(req.AllowedNetworks)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ttl := internal.DefaultTTLMinutes
+	if req.TTL != 0 {
+		ttl = req.TTL
+	}
+
+	if !h.apiChargeQuota(w, limits) {
+		return
+	}
+	res, err := h.store.SaveWithOptions(req.Secret, storage.SaveOptions{
+		TTLMinutes:   clampTTL(ttl, limits.maxTTLMinutes),
+		WithApproval: req.Secure,
+		Opaque:       req.Opaque,
+		Views:        req.Views,
+		Passphrase:   req.Passphrase,
+		BurnAfter:    req.BurnAfter,
+		NotifyEmail:  notifyEmail,
+		Recipients:   recipients,
+		AllowedCIDRs: networks,
+		Creator:      creator,
+	})
+	if errors.Is(err, storage.ErrBusy) {
+		writeAPIBusy(w)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "could not save secret")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, apiCreateResponse{
+		ID:          res.ID,
+		Link:        fmt.Sprintf("%s/%s", h.allowedOrigin, res.ID),
+		Passcode:    res.Code,
+		ExpiresAt:   res.ExpiresAt,
+		ManageToken: res.ManageToken,
+		ManageLink:  h.manageLink(res.ID, res.ManageToken),
+	})
+}
+
+// apiAuthorizeCreation checks the bearer token of a create request and
+// writes the error response if it is rejected. With OIDC_ISSUER set, the
+// request needs either an API key or a login session, like the form does.
+// It returns the creator to record: the session's subject, or the API
+// key's name prefixed with "apikey:".
+func (h *Handler) apiAuthorizeCreation(w http.ResponseWriter, r *http.Request) (creationLimits, string, bool) {
+	token := bearerToken(r)
+	user := h.sessionIdentity(r)
+	if h.auth != nil && token == "" && user == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIErrorCode(w, http.StatusUnauthorized, "login_required", "an API key or a login session is required")
+		return creationLimits{}, "", false
+	}
+	limits, err := h.authorizeCreation(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, creationErrorStatus(err), err.Error())
+		return limits, "", false
+	}
+	switch {
+	case user != nil:
+		return limits, user.Subject, true
+	case limits.key != nil:
+		return limits, "apikey:" + limits.key.Name, true
+	}
+	return limits, "", true
+}
+
+// apiChargeQuota charges the request's API key right before a save and
+// writes a 429 if its quota is used up.
+func (h *Handler) apiChargeQuota(w http.ResponseWriter, limits creationLimits) bool {
+	if err := h.chargeQuota(limits); err != nil {
+		writeAPIErrorCode(w, http.StatusTooManyRequests, "quota_exceeded", err.Error())
+		return false
+	}
+	return true
+}
+
+// apiSharesHandler serves POST /api/v1/shares, which splits a secret into
+// Shamir shares stored as separate one-time secrets.
+func (h *Handler) apiSharesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limits, creator, ok := h.apiAuthorizeCreation(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiSharesRequest
+	if !decodeAPIRequest(w, r, &req, maxAPIBodyBytes) {
+		return
+	}
+	req.Secret = strings.TrimSpace(req.Secret)
+	if req.Secret == "" {
+		writeAPIError(w, http.StatusBadRequest, "secret is required")
+		return
+	}
+	if len(req.Secret) > limits.maxSecretBytes {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "secret too large")
+		return
+	}
+	if err := validateShareCounts(req.Shares, req.Threshold); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl := internal.DefaultTTLMinutes
+	if req.TTL != 0 {
+		ttl = req.TTL
+	}
+
+	if !h.apiChargeQuota(w, limits) {
+		return
+	}
+	results, err := h.store.SaveShares([]byte(req.Secret), storage.ShareOptions{
+		TTLMinutes: clampTTL(ttl, limits.maxTTLMinutes),
+		Shares:     req.Shares,
+		Threshold:  req.Threshold,
+		Creator:    creator,
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "could not save secret")
+		return
+	}
+
+	resp := apiSharesResponse{Threshold: req.Threshold, ExpiresAt: results[0].ExpiresAt}
+	for _, res := range results {
+		resp.Shares = append(resp.Shares, apiCreateResponse{
+			ID:          res.ID,
+			Link:        fmt.Sprintf("%s/%s", h.allowedOrigin, res.ID),
+			ExpiresAt:   res.ExpiresAt,
+			ManageToken: res.ManageToken,
+			ManageLink:  h.manageLink(res.ID, res.ManageToken),
+		})
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// apiCombineHandler serves POST /api/v1/shares/combine. The shares are
+// only consumed if there are enough of them from the same secret.
+func (h *Handler) apiCombineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req apiCombineRequest
+	if !decodeAPIRequest(w, r, &req, maxAPIBodyBytes) {
+		return
+	}
+
+	text, err := h.store.Combine(shareIDs(req.Links))
+	defer secmem.Free(text)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, apiRevealResponse{Secret: string(text)})
+	case errors.Is(err, storage.ErrNotEnoughShares):
+		writeAPIErrorCode(w, http.StatusBadRequest, "not_enough_shares", err.Error())
+	case errors.Is(err, storage.ErrInvalidShares):
+		writeAPIErrorCode(w, http.StatusBadRequest, "invalid_shares", err.Error())
+	case errors.Is(err, storage.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "could not combine shares")
+	}
+}
+
+// apiSecretHandler serves GET and DELETE /api/v1/secrets/{id} as well as
+// POST /api/v1/secrets/{id}/reveal and POST /api/v1/secrets/{id}/confirm.
+// DELETE and the sender's GET /api/v1/secrets/{id}/receipt and POST
+// /api/v1/secrets/{id}/revoke require the management token in the
+// X-Management-Token header.
+func (h *Handler) apiSecretHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, apiPrefix+"/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			h.apiMetadata(w, r, id)
+		case http.MethodDelete:
+			h.apiManagedRevoke(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case "reveal":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.apiReveal(w, r, id)
+	case "confirm":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.apiConfirm(w, r, id)
+	case "receipt":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.apiReceipt(w, r, id)
+	case "revoke":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.apiManagedRevoke(w, r, id)
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) apiMetadata(w http.ResponseWriter, r *http.Request, id string) {
+	sec, err := h.store.Get(id)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, storage.ErrNotFound.Error())
+		return
+	}
+	if h.checkNetwork(r, sec) != nil {
+		writeAPIErrorCode(w, http.StatusForbidden, "network_denied", errNetworkDenied.Error())
+		return
+	}
+	// The passcode and file name are for the listed recipients only, as on
+	// the waiting page.
+	if h.checkRecipient(r, sec) != nil {
+		writeAPIErrorCode(w, http.StatusForbidden, "recipient_restricted", "secret is addressed to specific people")
+		return
+	}
+	meta := apiMetadata{
+		ID:        id,
+		ExpiresAt: sec.ExpiresAt,
+		Secure:    sec.Code != "",
+		Unlocked:  sec.Unlocked,
+		Opaque:    sec.Opaque,
+		FileName:  sec.FileName,
+
+		Passphrase:     sec.HasPassphrase(),
+		ViewsRemaining: sec.ViewsLeft(),
+		Threshold:      sec.Threshold,
+	}
+	if sec.IsFile() {
+		meta.ContentType = sec.ContentType
+	}
+	if !sec.Unlocked {
+		meta.Passcode = sec.Code
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+func (h *Handler) apiReceipt(w http.ResponseWriter, r *http.Request, id string) {
+	rec, err := h.store.Receipt(id, r.Header.Get("X-Management-Token"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "receipt not found or expired")
+		return
+	}
+	resp := apiReceipt{
+		ID:             id,
+		Status:         rec.Status(),
+		CreatedAt:      rec.CreatedAt,
+		ExpiresAt:      rec.ExpiresAt,
+		Views:          rec.Views,
+		ViewsRemaining: rec.ViewsLeft(),
+		ViewedAt:       rec.ViewedAt,
+	}
+	if resp.ViewedAt == nil {
+		resp.ViewedAt = []time.Time{}
+	}
+	if !rec.RevokedAt.IsZero() {
+		resp.RevokedAt = &rec.RevokedAt
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) apiManagedRevoke(w http.ResponseWriter, r *http.Request, id string) {
+	err := h.store.Revoke(id, r.Header.Get("X-Management-Token"))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, storage.ErrNotPending):
+		writeAPIErrorCode(w, http.StatusConflict, "not_pending", err.Error())
+	default:
+		writeAPIError(w, http.StatusNotFound, "receipt not found or expired")
+	}
+}
+
+func (h *Handler) apiConfirm(w http.ResponseWriter, r *http.Request, id string) {
+	var req apiConfirmRequest
+	if !decodeAPIRequest(w, r, &req, maxAPIBodyBytes) {
+		return
+	}
+
+	if sec, err := h.store.Get(id); err == nil && h.checkNetwork(r, sec) != nil {
+		writeAPIErrorCode(w, http.StatusForbidden, "network_denied", errNetworkDenied.Error())
+		return
+	}
+
+	err := h.store.Confirm(id, strings.TrimSpace(req.Code), h.clientIP(r))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, storage.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrBlocked):
+		writeAPIErrorCode(w, http.StatusTooManyRequests, "blocked", err.Error())
+	case errors.Is(err, storage.ErrInvalidCode):
+		writeAPIErrorCode(w, http.StatusForbidden, "invalid_code", err.Error())
+	case errors.Is(err, storage.ErrNoRecipient):
+		writeAPIErrorCode(w, http.StatusConflict, "no_recipient", err.Error())
+	case errors.Is(err, storage.ErrAlreadyUnlocked):
+		writeAPIErrorCode(w, http.StatusConflict, "already_unlocked", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "could not confirm secret")
+	}
+}
+
+func (h *Handler) apiReveal(w http.ResponseWriter, r *http.Request, id string) {
+	// The body is optional; it only carries a passphrase.
+	var req apiRevealRequest
+	if r.ContentLength != 0 && !decodeAPIRequest(w, r, &req, maxAPIBodyBytes) {
+		return
+	}
+
+	sec, err := h.store.Get(id)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, storage.ErrNotFound.Error())
+		return
+	}
+	if h.checkNetwork(r, sec) != nil {
+		writeAPIErrorCode(w, http.StatusForbidden, "network_denied", errNetworkDenied.Error())
+		return
+	}
+	if !sec.Unlocked {
+		writeAPIErrorCode(w, http.StatusConflict, "approval_required", "secret requires sender approval")
+		return
+	}
+	if sec.IsShare() {
+		writeAPIErrorCode(w, http.StatusConflict, "combine_required", "shares can only be revealed through "+sharesPrefix+"/combine")
+		return
+	}
+	if sec.IsRestricted() {
+		writeAPIErrorCode(w, http.StatusForbidden, "recipient_restricted", "secret is addressed to specific people and can only be opened in the browser after login")
+		return
+	}
+
+	var text []byte
+	if sec.HasPassphrase() {
+		if req.Passphrase == "" {
+			writeAPIErrorCode(w, http.StatusUnauthorized, "passphrase_required", storage.ErrPassphraseRequired.Error())
+			return
+		}
+		sec, text, err = h.store.UnsealSecret(id, req.Passphrase, h.clientIP(r))
+		switch {
+		case errors.Is(err, storage.ErrInvalidPassphrase):
+			writeAPIErrorCode(w, http.StatusForbidden, "invalid_passphrase", err.Error())
+			return
+		case errors.Is(err, storage.ErrBlocked):
+			writeAPIErrorCode(w, http.StatusTooManyRequests, "blocked", err.Error())
+			return
+		case errors.Is(err, storage.ErrBurned):
+			writeAPIErrorCode(w, http.StatusGone, "burned", err.Error())
+			return
+		case errors.Is(err, storage.ErrBusy):
+			writeAPIBusy(w)
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusNotFound, storage.ErrNotFound.Error())
+			return
+		}
+	} else {
+		if sec, err = h.store.Take(id); err != nil {
+			writeAPIError(w, http.StatusNotFound, storage.ErrNotFound.Error())
+			return
+		}
+		if text, err = h.store.DecryptSecret(sec); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "decryption failed")
+			return
+		}
+	}
+	defer secmem.Free(text)
+	// The JSON encoder only takes strings, so this copy cannot be wiped.
+	resp := apiRevealResponse{Opaque: sec.Opaque, ViewsRemaining: sec.Views}
+	if sec.IsFile() {
+		resp.Secret = base64.StdEncoding.EncodeToString(text)
+		resp.FileName = sec.FileName
+		resp.ContentType = sec.ContentType
+		resp.Encoding = "base64"
+	} else {
+		resp.Secret = string(text)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiRateLimit shares the per-IP limiter with the HTML routes but answers
+// with a JSON error body.
+func (h *Handler) apiRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limiter := h.ipLimiter.getLimiter(h.clientIP(r))
+		if !limiter.Allow() {
+			writeAPIError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// decodeAPIRequest requires a JSON content type, which also keeps
+// cross-site HTML forms from reaching the API.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return false
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIErrorCode(w, status, apiErrorCode(status), message)
+}
+
+// writeAPIErrorCode lets clients tell apart errors that share a status,
+// such as a blocked passcode and a rate limit.
+func writeAPIErrorCode(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: message, Code: code})
+}
+
+// writeAPIBusy answers a request that found every passphrase derivation
+// slot taken.
+func writeAPIBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	writeAPIErrorCode(w, http.StatusServiceUnavailable, "busy", storage.ErrBusy.Error())
+}
+
+func apiErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case http.StatusUnsupportedMediaType:
+		return "unsupported_media_type"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	}
+	return "internal_error"
+}
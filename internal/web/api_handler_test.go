@@ -0,0 +1,447 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"whisperbin/internal/apikey"
+	"whisperbin/internal/storage"
+)
+
+func TestAPI_CreateMetadataRevealFlow(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "from ci", "ttl": 5}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", resp.StatusCode)
+	}
+	var created apiCreateResponse
+	decodeJSON(t, resp, &created)
+	if created.ID == "" || created.Link != h.allowedOrigin+"/"+created.ID {
+		t.Fatalf("Unexpected create response: %+v", created)
+	}
+	if created.Passcode != "" {
+		t.Error("Expected no passcode for non-secure secret")
+	}
+
+	resp = apiRequest(t, "GET", server.URL+"/api/v1/secrets/"+created.ID, "")
+	var meta apiMetadata
+	decodeJSON(t, resp, &meta)
+	if resp.StatusCode != http.StatusOK || !meta.Unlocked || meta.Secure {
+		t.Fatalf("Unexpected metadata %d: %+v", resp.StatusCode, meta)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "")
+	var revealed apiRevealResponse
+	decodeJSON(t, resp, &revealed)
+	if resp.StatusCode != http.StatusOK || revealed.Secret != "from ci" {
+		t.Fatalf("Unexpected reveal %d: %+v", resp.StatusCode, revealed)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "")
+	var apiErr apiError
+	decodeJSON(t, resp, &apiErr)
+	if resp.StatusCode != http.StatusNotFound || apiErr.Error == "" {
+		t.Errorf("Expected JSON 404 after reveal, got %d: %+v", resp.StatusCode, apiErr)
+	}
+}
+
+func TestAPI_SecureSecretRequiresApproval(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "locked", "secure": true}`)
+	var created apiCreateResponse
+	decodeJSON(t, resp, &created)
+	if len(created.Passcode) != 6 {
+		t.Fatalf("Expected 6-digit passcode, got %q", created.Passcode)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for locked secret, got %d", resp.StatusCode)
+	}
+	if _, err := store.Get(created.ID); err != nil {
+		t.Error("Locked secret should not be consumed")
+	}
+
+	resp = apiRequest(t, "GET", server.URL+"/api/v1/secrets/"+created.ID, "")
+	var meta apiMetadata
+	decodeJSON(t, resp, &meta)
+	if meta.Passcode != created.Passcode {
+		t.Errorf("Expected waiting recipient to see passcode %q, got %q", created.Passcode, meta.Passcode)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/confirm", `{"code": "`+created.Passcode+`"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 without a waiting recipient, got %d", resp.StatusCode)
+	}
+
+	go store.WaitForUnlock(context.Background(), created.ID)
+	time.Sleep(50 * time.Millisecond)
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/confirm", `{"code": "wrong"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for wrong code, got %d", resp.StatusCode)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/confirm", `{"code": "`+created.Passcode+`"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 for correct code, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPI_Revoke(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("revoke me", storage.SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	// The recipient link alone does not allow deleting the secret.
+	resp := apiRequest(t, "DELETE", server.URL+"/api/v1/secrets/"+res.ID, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 without a management token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/api/v1/secrets/"+res.ID, nil)
+	req.Header.Set("X-Management-Token", res.ManageToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", resp.StatusCode)
+	}
+
+	resp = apiRequest(t, "GET", server.URL+"/api/v1/secrets/"+res.ID, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 after revoke, got %d", resp.StatusCode)
+	}
+	if rec, err := store.Receipt(res.ID, res.ManageToken); err != nil || rec.RevokedAt.IsZero() {
+		t.Errorf("Expected the revocation on the receipt, got %v", err)
+	}
+}
+
+func TestAPI_RejectsBadRequests(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	form, _ := http.Post(server.URL+"/api/v1/secrets", "application/x-www-form-urlencoded", strings.NewReader("secret=x"))
+	form.Body.Close()
+	if form.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for form body, got %d", form.StatusCode)
+	}
+
+	cases := map[string]int{
+		`{"secret": `:                                        http.StatusBadRequest,
+		`{"secret": ""}`:                                     http.StatusBadRequest,
+		`{"secret": "x", "unknown": true}`:                   http.StatusBadRequest,
+		`{"secret": "x", "opaque": true}`:                    http.StatusBadRequest,
+		`{"secret": "x", "views": 101}`:                      http.StatusBadRequest,
+		`{"secret": "x", "secure": true, "passphrase": "p"}`: http.StatusBadRequest,
+		`{"secret": "` + strings.Repeat("a", 10241) + `"}`:   http.StatusRequestEntityTooLarge,
+	}
+	for body, want := range cases {
+		resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets", body)
+		var apiErr apiError
+		decodeJSON(t, resp, &apiErr)
+		if resp.StatusCode != want || apiErr.Error == "" {
+			t.Errorf("Body %.40q: expected JSON %d, got %d", body, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPI_MultiViewCountsDown(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	var created apiCreateResponse
+	decodeJSON(t, apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "on-call", "views": 2}`), &created)
+
+	var meta apiMetadata
+	decodeJSON(t, apiRequest(t, "GET", server.URL+"/api/v1/secrets/"+created.ID, ""), &meta)
+	if meta.ViewsRemaining != 2 {
+		t.Errorf("Expected 2 views remaining, got %d", meta.ViewsRemaining)
+	}
+
+	for want := 1; want >= 0; want-- {
+		var revealed apiRevealResponse
+		resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "")
+		decodeJSON(t, resp, &revealed)
+		if resp.StatusCode != http.StatusOK || revealed.Secret != "on-call" || revealed.ViewsRemaining != want {
+			t.Errorf("Expected reveal with %d views left, got %d %+v", want, resp.StatusCode, revealed)
+		}
+	}
+
+	resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 after last view, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPI_ReceiptAndManagedRevoke(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	var created apiCreateResponse
+	decodeJSON(t, apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "read me"}`), &created)
+	if created.ManageToken == "" || !strings.HasSuffix(created.ManageLink, "/manage/"+created.ID+"/"+created.ManageToken) {
+		t.Fatalf("Expected management token and link, got %+v", created)
+	}
+
+	receipt := func(token string) (*http.Response, apiReceipt) {
+		req, _ := http.NewRequest("GET", server.URL+"/api/v1/secrets/"+created.ID+"/receipt", nil)
+		req.Header.Set("X-Management-Token", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec apiReceipt
+		decodeJSON(t, resp, &rec)
+		return resp, rec
+	}
+
+	if resp, _ := receipt("guess"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for wrong token, got %d", resp.StatusCode)
+	}
+	if _, rec := receipt(created.ManageToken); rec.Status != "pending" || len(rec.ViewedAt) != 0 {
+		t.Errorf("Expected pending receipt, got %+v", rec)
+	}
+
+	apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "").Body.Close()
+	if _, rec := receipt(created.ManageToken); rec.Status != "viewed" || len(rec.ViewedAt) != 1 {
+		t.Errorf("Expected viewed receipt, got %+v", rec)
+	}
+
+	req, _ := http.NewRequest("POST", server.URL+"/api/v1/secrets/"+created.ID+"/revoke", nil)
+	req.Header.Set("X-Management-Token", created.ManageToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr apiError
+	decodeJSON(t, resp, &apiErr)
+	if resp.StatusCode != http.StatusConflict || apiErr.Code != "not_pending" {
+		t.Errorf("Expected 409 not_pending after reveal, got %d %+v", resp.StatusCode, apiErr)
+	}
+}
+
+func TestAPI_RateLimitReturnsJSON(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	var last *http.Response
+	for i := 0; i < 20; i++ {
+		last = apiRequest(t, "GET", server.URL+"/api/v1/secrets/missing", "")
+		if last.StatusCode == http.StatusTooManyRequests {
+			break
+		}
+		last.Body.Close()
+	}
+	if last.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected rate limit, got %d", last.StatusCode)
+	}
+	if ct := last.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON rate limit response, got %q", ct)
+	}
+	last.Body.Close()
+}
+
+func apiRequest(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func decodeJSON(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode JSON response: %v", err)
+	}
+}
+
+func TestAPI_RequiresKeyForCreationOnly(t *testing.T) {
+	token, hash, _ := apikey.Generate()
+	ring, err := apikey.Parse([]byte(`[{"name": "ci", "hash": "` + hash + `"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	h.keyring = ring
+	h.requireAPIKey = true
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "x"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("Expected 401 without key, got %d", resp.StatusCode)
+	}
+
+	resp = apiRequestWithKey(t, server.URL+"/api/v1/secrets", `{"secret": "x"}`, "wb_wrong")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with wrong key, got %d", resp.StatusCode)
+	}
+
+	resp = apiRequestWithKey(t, server.URL+"/api/v1/secrets", `{"secret": "x"}`, token)
+	var created apiCreateResponse
+	decodeJSON(t, resp, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 with valid key, got %d", resp.StatusCode)
+	}
+	if sec, err := store.Get(created.ID); err != nil || sec.Creator != "apikey:ci" {
+		t.Errorf("Expected the key's name as creator, got %v", err)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.ID+"/reveal", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected anonymous reveal, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPI_KeyLimitsOverrideDefaults(t *testing.T) {
+	token, hash, _ := apikey.Generate()
+	ring, err := apikey.Parse([]byte(`[{"name": "ci", "hash": "` + hash + `",
+		"max_ttl_minutes": 5, "max_secret_bytes": 20000, "quota": 2, "quota_window": "24h"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	h.keyring = ring
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	big := strings.Repeat("a", 15000)
+	resp := apiRequestWithKey(t, server.URL+"/api/v1/secrets", `{"secret": "`+big+`", "ttl": 60}`, token)
+	var created apiCreateResponse
+	decodeJSON(t, resp, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected key to allow a larger secret, got %d", resp.StatusCode)
+	}
+	if remaining := time.Until(created.ExpiresAt); remaining > 5*time.Minute {
+		t.Errorf("Expected TTL clamped to key maximum, got %s", remaining)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "`+big+`"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected default size limit without key, got %d", resp.StatusCode)
+	}
+
+	resp = apiRequestWithKey(t, server.URL+"/api/v1/secrets", `{"secret": ""}`, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected invalid request to be rejected, got %d", resp.StatusCode)
+	}
+	resp = apiRequestWithKey(t, server.URL+"/api/v1/secrets", `{"secret": "x"}`, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected rejected request not to use up the quota, got %d", resp.StatusCode)
+	}
+	resp = apiRequestWithKey(t, server.URL+"/api/v1/secrets", `{"secret": "x"}`, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected quota to be exhausted, got %d", resp.StatusCode)
+	}
+}
+
+func apiRequestWithKey(t *testing.T, url, body, token string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAPI_NotifyEmail(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "x", "notify_email": "alice@example.com"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 while email is disabled, got %d", resp.StatusCode)
+	}
+
+	h.notifyEmail = true
+	for _, addr := range []string{"not an address", "Alice <alice@example.com>", "a@example.com\r\nBcc: b@example.com"} {
+		body, _ := json.Marshal(apiCreateRequest{Secret: "x", NotifyEmail: addr})
+		resp := apiRequest(t, "POST", server.URL+"/api/v1/secrets", string(body))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", addr, resp.StatusCode)
+		}
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets", `{"secret": "x", "notify_email": "alice@example.com"}`)
+	var created apiCreateResponse
+	decodeJSON(t, resp, &created)
+	sec, err := store.Get(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email, _ := store.NotifyEmail(sec); email != "alice@example.com" {
+		t.Errorf("Expected notify email to be stored, got %q", email)
+	}
+}
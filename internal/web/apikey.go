@@ -0,0 +1,100 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"whisperbin/internal"
+	"whisperbin/internal/apikey"
+)
+
+var (
+	errAPIKeyRequired = errors.New("API key required")
+	errInvalidAPIKey  = errors.New("invalid API key")
+	errQuotaExceeded  = errors.New("API key quota exceeded")
+)
+
+type creationLimits struct {
+	maxTTLMinutes  int
+	maxSecretBytes int
+	// maxFileBytes bounds file attachments: MAX_FILE_BYTES, or the key's
+	// max_secret_bytes if that is lower.
+	maxFileBytes int64
+	// key is the API key the request was made with, if any.
+	key *apikey.Key
+}
+
+var defaultCreationLimits = creationLimits{
+	maxTTLMinutes:  internal.MaxTTLMinutes,
+	maxSecretBytes: internal.MaxSecretBytes,
+}
+
+func loadKeyring() (*apikey.Keyring, error) {
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		return apikey.LoadFile(path)
+	}
+	if data := os.Getenv("API_KEYS"); data != "" {
+		return apikey.Parse([]byte(data))
+	}
+	return nil, nil
+}
+
+// authorizeCreation checks the API key presented for a new secret and
+// returns the limits that apply to it. Without a key the instance defaults
+// apply, unless REQUIRE_API_KEY is set. Recipient routes never call this.
+// The key's quota is charged separately by chargeQuota once the request
+// has been validated.
+func (h *Handler) authorizeCreation(token string) (creationLimits, error) {
+	limits := defaultCreationLimits
+	limits.maxFileBytes = h.maxFileBytes
+	if token == "" {
+		if h.requireAPIKey {
+			return creationLimits{}, errAPIKeyRequired
+		}
+		return limits, nil
+	}
+	if h.keyring == nil {
+		return creationLimits{}, errInvalidAPIKey
+	}
+
+	key, ok := h.keyring.Lookup(token)
+	if !ok {
+		return creationLimits{}, errInvalidAPIKey
+	}
+	limits.key = key
+	if key.MaxTTLMinutes > 0 {
+		limits.maxTTLMinutes = key.MaxTTLMinutes
+	}
+	if key.MaxSecretBytes > 0 {
+		limits.maxSecretBytes = key.MaxSecretBytes
+		limits.maxFileBytes = min(limits.maxFileBytes, int64(key.MaxSecretBytes))
+	}
+	return limits, nil
+}
+
+// chargeQuota consumes one creation from the quota of the request's API
+// key. Handlers call it right before saving, so rejected requests do not
+// count against the key.
+func (h *Handler) chargeQuota(limits creationLimits) error {
+	if limits.key != nil && !h.keyring.Allow(limits.key) {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func creationErrorStatus(err error) int {
+	if errors.Is(err, errQuotaExceeded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,337 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"whisperbin/internal"
+	"whisperbin/internal/oidc"
+	"whisperbin/internal/storage"
+)
+
+const (
+	sessionCookie = "session"
+	flowCookie    = "oidc_flow"
+	// sessionDuration caps a login even if the ID token lives longer.
+	sessionDuration = 8 * time.Hour
+	flowDuration    = 10 * time.Minute
+)
+
+// identity is the logged-in user, kept in a signed session cookie.
+type identity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Expiry  int64    `json:"exp"`
+}
+
+// loginFlow is what the callback needs to finish a login it started.
+type loginFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+	Expiry   int64  `json:"exp"`
+}
+
+type identityKey struct{}
+
+var (
+	errLoginRequired = errors.New("login required")
+	errNotRecipient  = errors.New("secret is addressed to someone else")
+)
+
+func identityFrom(ctx context.Context) *identity {
+	id, _ := ctx.Value(identityKey{}).(*identity)
+	return id
+}
+
+// loadOIDC discovers the provider named by OIDC_ISSUER. Without it,
+// creators are not asked to log in.
+func loadOIDC(allowedOrigin string) (*oidc.Provider, []byte, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil, nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		return nil, nil, errors.New("OIDC_ISSUER requires OIDC_CLIENT_ID")
+	}
+
+	var sessionKey []byte
+	if v := os.Getenv("SESSION_KEY"); v != "" {
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(decoded) != 32 {
+			return nil, nil, errors.New("SESSION_KEY must be 32-byte base64-encoded")
+		}
+		sessionKey = decoded
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider, err := oidc.Discover(ctx, oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  allowedOrigin + "/auth/callback",
+	})
+	return provider, sessionKey, err
+}
+
+// setAuth enables login with provider. Sessions are signed with
+// sessionKey, or with a random key if it is nil, which logs everyone out
+// on restart.
+func (h *Handler) setAuth(provider *oidc.Provider, sessionKey []byte) {
+	if sessionKey == nil {
+		sessionKey = make([]byte, 32)
+		if _, err := rand.Read(sessionKey); err != nil {
+			panic("could not generate session key")
+		}
+	}
+	h.auth = provider
+	h.sessionKey = sessionKey
+}
+
+// requireLogin lets the request through if login is disabled or the user
+// has a session. Otherwise browsers are sent to log in; other methods are
+// refused.
+func (h *Handler) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.auth == nil {
+			next(w, r)
+			return
+		}
+		if id := h.sessionIdentity(r); id != nil {
+			next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+			return
+		}
+		if r.Method == http.MethodGet {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		h.renderError(w, http.StatusUnauthorized, "Login Required", "Please log in to create secrets.")
+	}
+}
+
+func (h *Handler) sessionIdentity(r *http.Request) *identity {
+	var id identity
+	if h.auth == nil || !h.readSigned(r, sessionCookie, &id) || id.Subject == "" {
+		return nil
+	}
+	return &id
+}
+
+// checkRecipient allows anyone to open an unrestricted secret. A
+// restricted one needs a session whose verified email or groups match.
+func (h *Handler) checkRecipient(r *http.Request, sec *storage.Secret) error {
+	if !sec.IsRestricted() {
+		return nil
+	}
+	id := h.sessionIdentity(r)
+	if id == nil {
+		return errLoginRequired
+	}
+	rules, err := h.store.Recipients(sec)
+	if err != nil || !rules.Allows(id.Email, id.Groups) {
+		return errNotRecipient
+	}
+	return nil
+}
+
+// renderRecipientError leaves the secret untouched; a recipient who is
+// not logged in is sent to log in and back.
+func (h *Handler) renderRecipientError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errLoginRequired) {
+		if r.Method == http.MethodGet && h.auth != nil {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		h.renderError(w, http.StatusUnauthorized, "Login Required", "This secret is addressed to specific people. Please log in to open it.")
+		return
+	}
+	h.renderError(w, http.StatusForbidden, "Not Addressed to You", "This secret was sent to someone else. It has not been opened.")
+}
+
+// parseRecipients reads the emails and groups a sender restricts a secret
+// to. Restrictions need login to be enabled, or no one could open it.
+func (h *Handler) parseRecipients(emails, groups []string) (storage.Recipients, error) {
+	var rules storage.Recipients
+	for _, e := range emails {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(e)
+		if err != nil || addr.Address != e || len(e) > internal.MaxEmailBytes {
+			return rules, fmt.Errorf("invalid recipient email address %q", e)
+		}
+		rules.Emails = append(rules.Emails, e)
+	}
+	for _, g := range groups {
+		if g = strings.TrimSpace(g); g != "" {
+			rules.Groups = append(rules.Groups, g)
+		}
+	}
+	if rules.IsZero() {
+		return rules, nil
+	}
+	if h.auth == nil {
+		return rules, errors.New("recipient restrictions need login to be enabled on this server")
+	}
+	if len(rules.Emails)+len(rules.Groups) > internal.MaxRecipients {
+		return rules, fmt.Errorf("at most %d recipients and groups", internal.MaxRecipients)
+	}
+	return rules, nil
+}
+
+// loginHandler starts the authorization code flow.
+func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		http.NotFound(w, r)
+		return
+	}
+	flow := loginFlow{Next: safeNext(r.URL.Query().Get("next")), Expiry: time.Now().Add(flowDuration).Unix()}
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		s, err := oidc.RandomString()
+		if err != nil {
+			http.Error(w, "Could not start login", http.StatusInternalServerError)
+			return
+		}
+		*v = s
+	}
+	if err := h.writeSigned(w, flowCookie, flow, flowDuration); err != nil {
+		http.Error(w, "Could not start login", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, h.auth.AuthCodeURL(flow.State, flow.Nonce, flow.Verifier), http.StatusFound)
+}
+
+// callbackHandler finishes the flow at /auth/callback.
+func (h *Handler) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var flow loginFlow
+	if !h.readSigned(r, flowCookie, &flow) || flow.State == "" ||
+		!hmac.Equal([]byte(flow.State), []byte(r.URL.Query().Get("state"))) {
+		h.renderError(w, http.StatusBadRequest, "Login Failed", "The login expired or was started in another browser. Please try again.")
+		return
+	}
+	h.clearCookie(w, flowCookie)
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		h.renderError(w, http.StatusForbidden, "Login Failed", "The identity provider refused the login.")
+		return
+	}
+	claims, err := h.auth.Exchange(r.Context(), r.URL.Query().Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		h.renderError(w, http.StatusForbidden, "Login Failed", "The login could not be verified.")
+		return
+	}
+
+	expiry := time.Now().Add(sessionDuration)
+	if claims.Expiry.Before(expiry) {
+		expiry = claims.Expiry
+	}
+	id := identity{Subject: claims.Subject, Groups: claims.Groups, Expiry: expiry.Unix()}
+	if claims.EmailVerified {
+		id.Email = claims.Email
+	}
+	if err := h.writeSigned(w, sessionCookie, id, time.Until(expiry)); err != nil {
+		http.Error(w, "Could not create session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, flow.Next, http.StatusFound)
+}
+
+func (h *Handler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	h.clearCookie(w, sessionCookie)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// safeNext only allows redirects to local paths after login.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// writeSigned stores v in a cookie as base64url(JSON).base64url(HMAC).
+// Values must carry their own "exp" so a replayed cookie still expires.
+func (h *Handler) writeSigned(w http.ResponseWriter, name string, v any, maxAge time.Duration) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded + "." + base64.RawURLEncoding.EncodeToString(h.cookieMAC(name, encoded)),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		// Lax, because the login callback is a cross-site redirect.
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(maxAge),
+	})
+	return nil
+}
+
+func (h *Handler) readSigned(r *http.Request, name string, v any) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	encoded, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, h.cookieMAC(name, encoded)) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(payload, v) != nil {
+		return false
+	}
+	var exp struct {
+		Expiry int64 `json:"exp"`
+	}
+	json.Unmarshal(payload, &exp)
+	return time.Now().Unix() < exp.Expiry
+}
+
+// cookieMAC binds the signature to the cookie name, so a login flow
+// cookie cannot be presented as a session.
+func (h *Handler) cookieMAC(name, encoded string) []byte {
+	mac := hmac.New(sha256.New, h.sessionKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+func (h *Handler) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
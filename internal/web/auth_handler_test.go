@@ -0,0 +1,301 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"whisperbin/internal/oidc"
+	"whisperbin/internal/oidc/oidctest"
+	"whisperbin/internal/storage"
+)
+
+var noRedirects = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+// newAuthServer serves h with login through a fake identity provider.
+func newAuthServer(t *testing.T, store *storage.Store) (*Handler, *httptest.Server, *oidctest.Provider) {
+	t.Helper()
+	fake := oidctest.NewProvider()
+	t.Cleanup(fake.Close)
+
+	h := NewHandlerWithTemplates(store, projectRootPath("ui/templates/*.html"))
+	server := httptest.NewServer(h.Routes())
+	t.Cleanup(server.Close)
+
+	provider, err := oidc.Discover(context.Background(), fake.Config(server.URL+"/auth/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.setAuth(provider, nil)
+	return h, server, fake
+}
+
+func getNoRedirect(t *testing.T, target string, cookies ...*http.Cookie) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", target, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	resp, err := noRedirects.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func responseCookie(resp *http.Response, name string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// login runs the authorization code flow against the fake provider and
+// returns the session cookie.
+func login(t *testing.T, server *httptest.Server, next string) *http.Cookie {
+	t.Helper()
+	resp := getNoRedirect(t, server.URL+"/login?next="+url.QueryEscape(next))
+	flow := responseCookie(resp, flowCookie)
+	if resp.StatusCode != http.StatusFound || flow == nil {
+		t.Fatalf("Expected redirect to the provider, got %d", resp.StatusCode)
+	}
+
+	resp = getNoRedirect(t, resp.Header.Get("Location"))
+	callback := resp.Header.Get("Location")
+	if !strings.HasPrefix(callback, server.URL+"/auth/callback?") {
+		t.Fatalf("Expected redirect to the callback, got %q", callback)
+	}
+
+	resp = getNoRedirect(t, callback, flow)
+	session := responseCookie(resp, sessionCookie)
+	if resp.StatusCode != http.StatusFound || session == nil {
+		t.Fatalf("Expected a session after the callback, got %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != next {
+		t.Errorf("Expected redirect to %q after login, got %q", next, loc)
+	}
+	return session
+}
+
+func TestAuth_CreationRequiresLogin(t *testing.T) {
+	store := storage.NewStore()
+	_, server, fake := newAuthServer(t, store)
+	fake.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com"})
+
+	resp := getNoRedirect(t, server.URL+"/")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login?next=%2F" {
+		t.Fatalf("Expected redirect to login, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	token := "test-csrf-token"
+	resp, _ = postWithCSRF(t, server.URL+"/secret", token, url.Values{"secret": {"x"}})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for anonymous creation, got %d", resp.StatusCode)
+	}
+
+	session := login(t, server, "/")
+	req, _ := http.NewRequest("GET", server.URL+"/", nil)
+	req.AddCookie(session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "alice@example.com") {
+		t.Errorf("Expected the form for a logged-in user, got %d", resp.StatusCode)
+	}
+
+	form := url.Values{"secret": {"from alice"}, "csrf_token": {token}}
+	req, _ = http.NewRequest("POST", server.URL+"/secret", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+	req.AddCookie(session)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected creation to succeed, got %d", resp.StatusCode)
+	}
+
+	match := regexp.MustCompile(`id="secret-link" value="[^"]*/([^"/]+)"`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("Secret link not found")
+	}
+	id := match[1]
+	sec, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sec.Creator != "alice-sub" {
+		t.Errorf("Expected creator subject to be stored, got %q", sec.Creator)
+	}
+
+	resp, err = http.Get(server.URL + "/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected recipient link to stay public, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuth_APICreationRequiresLoginOrKey(t *testing.T) {
+	store := storage.NewStore()
+	_, server, fake := newAuthServer(t, store)
+	fake.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com"})
+
+	for _, path := range []string{"/api/v1/secrets", "/api/v1/shares"} {
+		resp := apiRequest(t, "POST", server.URL+path, `{"secret": "x", "shares": 3, "threshold": 2}`)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 for anonymous creation, got %d", path, resp.StatusCode)
+		}
+	}
+
+	session := login(t, server, "/")
+	req, _ := http.NewRequest("POST", server.URL+"/api/v1/secrets", strings.NewReader(`{"secret": "from alice"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created apiCreateResponse
+	decodeJSON(t, resp, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected creation with a session to succeed, got %d", resp.StatusCode)
+	}
+	sec, err := store.Get(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sec.Creator != "alice-sub" {
+		t.Errorf("Expected creator subject to be stored, got %q", sec.Creator)
+	}
+}
+
+func TestAuth_RejectsForgedAndMismatchedFlows(t *testing.T) {
+	store := storage.NewStore()
+	_, server, _ := newAuthServer(t, store)
+
+	forged := &http.Cookie{Name: sessionCookie, Value: "eyJzdWIiOiJtYWxsb3J5IiwiZXhwIjo5OTk5OTk5OTk5fQ.AAAA"}
+	if resp := getNoRedirect(t, server.URL+"/", forged); resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected forged session to be ignored, got %d", resp.StatusCode)
+	}
+
+	resp := getNoRedirect(t, server.URL+"/login?next=/")
+	flow := responseCookie(resp, flowCookie)
+	resp = getNoRedirect(t, resp.Header.Get("Location"))
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	q := callback.Query()
+	q.Set("state", "attacker-state")
+	callback.RawQuery = q.Encode()
+	if resp := getNoRedirect(t, callback.String(), flow); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected state mismatch to fail, got %d", resp.StatusCode)
+	}
+
+	// A flow cookie is signed for its own name and cannot act as a session.
+	if resp := getNoRedirect(t, server.URL+"/", &http.Cookie{Name: sessionCookie, Value: flow.Value}); resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected flow cookie to be rejected as a session, got %d", resp.StatusCode)
+	}
+
+	if got := safeNext("//evil.example"); got != "/" {
+		t.Errorf("Expected protocol-relative next to be rejected, got %q", got)
+	}
+}
+
+func TestAuth_RestrictedRecipients(t *testing.T) {
+	store := storage.NewStore()
+	h, server, fake := newAuthServer(t, store)
+
+	res, err := store.SaveWithOptions("for bob", storage.SaveOptions{
+		TTLMinutes: 5,
+		Recipients: storage.Recipients{Emails: []string{"bob@example.com"}, Groups: []string{"oncall"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := server.URL + "/" + res.ID
+
+	resp := getNoRedirect(t, link)
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), "/login?next=") {
+		t.Errorf("Expected anonymous recipient to be sent to login, got %d", resp.StatusCode)
+	}
+
+	fake.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", Groups: []string{"dev"}})
+	alice := login(t, server, "/"+res.ID)
+	if resp := getNoRedirect(t, link, alice); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a different user, got %d", resp.StatusCode)
+	}
+	token := "test-csrf-token"
+	form := url.Values{"csrf_token": {token}}
+	req, _ := http.NewRequest("POST", link, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+	req.AddCookie(alice)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 reveal for a different user, got %d", resp.StatusCode)
+	}
+	if _, err := store.Get(res.ID); err != nil {
+		t.Fatalf("Expected the secret to survive a mismatched recipient: %v", err)
+	}
+
+	apiResp := apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+res.ID+"/reveal", "")
+	var apiErr apiError
+	decodeJSON(t, apiResp, &apiErr)
+	if apiResp.StatusCode != http.StatusForbidden || apiErr.Code != "recipient_restricted" {
+		t.Errorf("Expected API reveal to be refused, got %d %q", apiResp.StatusCode, apiErr.Code)
+	}
+	for who, cookies := range map[string][]*http.Cookie{"anonymous": nil, "alice": {alice}} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/v1/secrets/"+res.ID, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		apiErr = apiError{}
+		decodeJSON(t, resp, &apiErr)
+		if resp.StatusCode != http.StatusForbidden || apiErr.Code != "recipient_restricted" {
+			t.Errorf("Expected API metadata to be refused for %s, got %d %q", who, resp.StatusCode, apiErr.Code)
+		}
+	}
+
+	fake.SetUser(oidctest.User{Subject: "carol", Email: "carol@example.com", Groups: []string{"oncall"}})
+	carol := login(t, server, "/"+res.ID)
+	req, _ = http.NewRequest("POST", link, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+	req.AddCookie(carol)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "for bob") {
+		t.Errorf("Expected a group member to reveal the secret, got %d", resp.StatusCode)
+	}
+
+	h.auth = nil
+	if _, err := h.parseRecipients([]string{"bob@example.com"}, nil); err == nil {
+		t.Error("Expected recipient restrictions to need login")
+	}
+}
@@ -6,6 +6,10 @@ import (
 )
 
 func (h *Handler) confirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.confirmPage(w, r)
+		return
+	}
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -20,6 +24,11 @@ func (h *Handler) confirmHandler(w http.ResponseWriter, r *http.Request) {
 	code := strings.TrimSpace(r.FormValue("code"))
 	ip := h.clientIP(r)
 
+	if sec, err := h.store.Get(id); err == nil && h.checkNetwork(r, sec) != nil {
+		h.renderNetworkError(w)
+		return
+	}
+
 	err := h.store.Confirm(id, code, ip)
 	if err != nil {
 		h.renderError(w, http.StatusForbidden, "Invalid Request", "Invalid confirmation code or expired link.")
@@ -28,3 +37,29 @@ func (h *Handler) confirmHandler(w http.ResponseWriter, r *http.Request) {
 
 	h.renderSuccess(w, "Secret unlocked", "Recipient can now view the secret.")
 }
+
+// confirmPage shows the passcode form on its own, for senders who did not
+// keep the created page open.
+func (h *Handler) confirmPage(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/confirm/")
+	sec, err := h.store.Get(id)
+	if err != nil || sec.Code == "" || sec.Unlocked {
+		h.renderError(w, http.StatusNotFound, "Not Found", "Secret not found, expired or already unlocked.")
+		return
+	}
+	if h.checkNetwork(r, sec) != nil {
+		h.renderNetworkError(w)
+		return
+	}
+
+	token, err := h.issueCSRFToken(w)
+	if err != nil {
+		http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	h.templates.ExecuteTemplate(w, "confirm.html", struct {
+		ID        string
+		CSRFToken string
+	}{ID: id, CSRFToken: token})
+}
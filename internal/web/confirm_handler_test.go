@@ -114,3 +114,41 @@ func TestConfirmHandler_ValidCode(t *testing.T) {
 		t.Errorf("Expected 200, got %d", postResp.StatusCode)
 	}
 }
+
+func TestConfirmHandler_PasscodePage(t *testing.T) {
+	store := storage.NewStore()
+	id, _, err := store.Save("locked", 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _, _ := store.Save("plain", 5, false)
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/confirm/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `action="/confirm/`+id+`"`) || strings.Contains(body, "locked</") {
+		t.Errorf("Expected passcode form, got %d", resp.StatusCode)
+	}
+	if _, err := store.Get(id); err != nil {
+		t.Errorf("Expected the confirm page to leave the secret in place: %v", err)
+	}
+
+	for _, target := range []string{plain, "missing"} {
+		resp, err := http.Get(server.URL + "/confirm/" + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", target, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"whisperbin/internal/secmem"
+	"whisperbin/internal/storage"
+)
+
+const (
+	maxFileNameLength = 255
+	// multipartOverhead leaves room for the other form fields next to the
+	// uploaded file.
+	multipartOverhead = 64 << 10
+	// maxFormBytes caps URL-encoded forms like http.Request.ParseForm does.
+	maxFormBytes = 10 << 20
+)
+
+var errFileTooLarge = errors.New("file too large")
+
+type upload struct {
+	name        string
+	contentType string
+	content     []byte
+}
+
+// createForm holds the plaintext fields of the create form: the secret
+// and an uploaded file. They are read into buffers of their own rather
+// than strings, so wipe can clear them once the secret is stored.
+type createForm struct {
+	secret []byte
+	file   *upload
+	bufs   [][]byte
+}
+
+func (f *createForm) wipe() {
+	secmem.Wipe(f.bufs...)
+}
+
+// parseCreateForm reads a multipart or URL-encoded create form. All other
+// fields end up in r.Form as usual, so FormValue works afterwards.
+func (h *Handler) parseCreateForm(w http.ResponseWriter, r *http.Request) (*createForm, error) {
+	form := &createForm{}
+	values := make(url.Values)
+
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxFileBytes+multipartOverhead)
+		err = h.readMultipartForm(r, form, values)
+	case "application/x-www-form-urlencoded":
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+		err = readURLEncodedForm(r, form, values)
+	}
+	if err != nil {
+		form.wipe()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errFileTooLarge
+		}
+		return nil, err
+	}
+
+	r.PostForm = values
+	r.Form = make(url.Values)
+	for k, v := range values {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	for k, v := range r.URL.Query() {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	r.MultipartForm = &multipart.Form{Value: values}
+	return form, nil
+}
+
+func (h *Handler) readMultipartForm(r *http.Request, form *createForm, values url.Values) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch name := part.FormName(); {
+		case name == "file" && part.FileName() != "":
+			content, err := readSecret(part, h.maxFileBytes)
+			if err != nil {
+				return err
+			}
+			form.bufs = append(form.bufs, content)
+			if len(content) > 0 {
+				form.file = &upload{
+					name:        sanitizeFileName(part.FileName()),
+					contentType: uploadContentType(part.Header),
+					content:     content,
+				}
+			}
+		case name == "secret":
+			if form.secret, err = readSecret(part, h.maxFileBytes+multipartOverhead); err != nil {
+				return err
+			}
+			form.bufs = append(form.bufs, form.secret)
+		case name != "":
+			v, err := io.ReadAll(io.LimitReader(part, multipartOverhead+1))
+			if err != nil {
+				return err
+			}
+			if len(v) > multipartOverhead {
+				return errors.New("form field too large")
+			}
+			values.Add(name, string(v))
+		}
+		part.Close()
+	}
+}
+
+func readURLEncodedForm(r *http.Request, form *createForm, values url.Values) error {
+	body, err := readSecret(r.Body, maxFormBytes)
+	if err != nil {
+		return err
+	}
+	defer secmem.Free(body)
+
+	for rest := body; len(rest) > 0; {
+		var pair []byte
+		pair, rest, _ = bytes.Cut(rest, []byte("&"))
+		rawKey, rawValue, _ := bytes.Cut(pair, []byte("="))
+		key, err := url.QueryUnescape(string(rawKey))
+		if err != nil {
+			return err
+		}
+		if key == "secret" {
+			if form.secret, err = unescapeSecret(rawValue); err != nil {
+				return err
+			}
+			form.bufs = append(form.bufs, form.secret)
+			continue
+		}
+		value, err := url.QueryUnescape(string(rawValue))
+		if err != nil {
+			return err
+		}
+		values.Add(key, value)
+	}
+	return nil
+}
+
+// readSecret reads all of src into a buffer from secmem.Alloc, wiping
+// each smaller buffer it outgrows. It fails with errFileTooLarge once
+// more than limit bytes arrive.
+func readSecret(src io.Reader, limit int64) ([]byte, error) {
+	buf := secmem.Alloc(4096)
+	n := 0
+	for {
+		if n == len(buf) {
+			bigger := secmem.Alloc(2 * len(buf))
+			copy(bigger, buf)
+			secmem.Free(buf)
+			buf = bigger
+		}
+		m, err := src.Read(buf[n:])
+		n += m
+		if int64(n) > limit {
+			secmem.Free(buf)
+			return nil, errFileTooLarge
+		}
+		if err == io.EOF {
+			return buf[:n], nil
+		}
+		if err != nil {
+			secmem.Free(buf)
+			return nil, err
+		}
+	}
+}
+
+// unescapeSecret decodes a URL-encoded form value into a buffer from
+// secmem.Alloc, like url.QueryUnescape without going through a string.
+func unescapeSecret(v []byte) ([]byte, error) {
+	out := secmem.Alloc(len(v))
+	n := 0
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '+':
+			out[n] = ' '
+		case '%':
+			hi, ok1 := unhex(v, i+1)
+			lo, ok2 := unhex(v, i+2)
+			if !ok1 || !ok2 {
+				secmem.Free(out)
+				return nil, errors.New("invalid URL escape in secret")
+			}
+			out[n] = hi<<4 | lo
+			i += 2
+		default:
+			out[n] = c
+		}
+		n++
+	}
+	return out[:n], nil
+}
+
+func unhex(v []byte, i int) (byte, bool) {
+	if i >= len(v) {
+		return 0, false
+	}
+	switch c := v[i]; {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// serveFile sends a revealed attachment as a download. The secret has
+// already been taken from the store, so an aborted transfer cannot be
+// retried to read it a second time.
+func serveFile(w http.ResponseWriter, sec *storage.Secret, content []byte) {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": sec.FileName})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", sec.ContentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(content)
+}
+
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > maxFileNameLength {
+		// Cut before the rune that would cross the limit, so the name
+		// stays valid UTF-8.
+		end := maxFileNameLength
+		for end > 0 && !utf8.RuneStart(name[end]) {
+			end--
+		}
+		name = name[:end]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "secret"
+	}
+	return name
+}
+
+func uploadContentType(header textproto.MIMEHeader) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
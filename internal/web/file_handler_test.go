@@ -0,0 +1,267 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"whisperbin/internal/apikey"
+	"whisperbin/internal/storage"
+)
+
+func TestCreateHandler_FileUploadAndDownload(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	content := []byte{0x30, 0x82, 0x00, 0xff, '\n', 0x00}
+	resp := uploadFile(t, server.URL, "../certs/client.p12", content)
+	body := readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", resp.StatusCode, body)
+	}
+
+	match := regexp.MustCompile(`value="http://localhost:8080/([^"]+)"`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("Link not found in created page")
+	}
+	id := match[1]
+
+	page, err := http.Get(server.URL + "/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageBody := readBody(t, page)
+	page.Body.Close()
+	if !strings.Contains(pageBody, "client.p12") || !strings.Contains(pageBody, "Download File") {
+		t.Error("Expected download page naming the file")
+	}
+
+	download, downloaded := revealPost(t, server.URL, id, getRevealToken(t, server.URL, id))
+	download.Body.Close()
+	if got := download.Header.Get("Content-Disposition"); got != `attachment; filename=client.p12` {
+		t.Errorf("Unexpected Content-Disposition %q", got)
+	}
+	if got := download.Header.Get("Content-Type"); got != "application/x-pkcs12" {
+		t.Errorf("Unexpected Content-Type %q", got)
+	}
+	if !bytes.Equal([]byte(downloaded), content) {
+		t.Errorf("Downloaded %v, want %v", []byte(downloaded), content)
+	}
+
+	if _, err := store.Get(id); err == nil {
+		t.Error("Expected file to be deleted after download")
+	}
+}
+
+func TestCreateHandler_FileTooLarge(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	h.maxFileBytes = 16
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp := uploadFile(t, server.URL, "big.bin", bytes.Repeat([]byte("x"), 17))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", resp.StatusCode)
+	}
+}
+
+func TestCreateHandler_FileObeysKeyLimit(t *testing.T) {
+	token, hash, _ := apikey.Generate()
+	ring, err := apikey.Parse([]byte(`[{"name": "small", "hash": "` + hash + `", "max_secret_bytes": 16}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewStore()
+	h := NewHandlerWithTemplates(store, projectRootPath("ui/templates/*.html"))
+	h.keyring = ring
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("csrf_token", "test-csrf-token")
+	mw.WriteField("api_key", token)
+	part, _ := mw.CreateFormFile("file", "big.bin")
+	part.Write(bytes.Repeat([]byte("x"), 17))
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/secret", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: "test-csrf-token"})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the key's size limit to apply to files, got %d", resp.StatusCode)
+	}
+}
+
+func TestSSE_FileUnlockDoesNotConsume(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("file body", storage.SaveOptions{
+		TTLMinutes:   5,
+		WithApproval: true,
+		FileName:     "kubeconfig",
+		ContentType:  "text/plain",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, code := res.ID, res.Code
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		store.Confirm(id, code, "127.0.0.1")
+	}()
+
+	resp, err := http.Get(server.URL + "/sse?id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() && len(lines) < 2 {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 || lines[0] != "event: file" || lines[1] != "data: kubeconfig" {
+		t.Fatalf("Unexpected SSE event %q", lines)
+	}
+
+	sec, err := store.Get(id)
+	if err != nil || !sec.Unlocked {
+		t.Fatalf("Expected unlocked file to remain for download, got %v", err)
+	}
+}
+
+func TestParseCreateForm_WipesBuffers(t *testing.T) {
+	h := NewHandlerWithTemplates(storage.NewStore(), projectRootPath("ui/templates/*.html"))
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("ttl", "30")
+	mw.WriteField("secret", "hunter2")
+	part, _ := mw.CreateFormFile("file", "id_rsa")
+	part.Write([]byte("-----BEGIN KEY-----"))
+	mw.Close()
+	multipartReq := httptest.NewRequest("POST", "/secret", &buf)
+	multipartReq.Header.Set("Content-Type", mw.FormDataContentType())
+
+	form, err := h.parseCreateForm(httptest.NewRecorder(), multipartReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(form.secret) != "hunter2" || form.file == nil || string(form.file.content) != "-----BEGIN KEY-----" {
+		t.Fatalf("Unexpected multipart form: secret %q, file %+v", form.secret, form.file)
+	}
+	if v := multipartReq.FormValue("ttl"); v != "30" {
+		t.Errorf("Expected other fields in r.Form, got ttl %q", v)
+	}
+	secret, content := form.secret, form.file.content
+	form.wipe()
+	if !bytes.Equal(secret, make([]byte, len(secret))) || !bytes.Equal(content, make([]byte, len(content))) {
+		t.Errorf("Expected wipe to zero the buffers, got %q and %q", secret, content)
+	}
+
+	encodedReq := httptest.NewRequest("POST", "/secret?lang=en", strings.NewReader("ttl=30&secret=p%40ss+w%C3%B6rd&views=2"))
+	encodedReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if form, err = h.parseCreateForm(httptest.NewRecorder(), encodedReq); err != nil {
+		t.Fatal(err)
+	}
+	if string(form.secret) != "p@ss wörd" {
+		t.Fatalf("Expected the secret to be unescaped, got %q", form.secret)
+	}
+	if encodedReq.FormValue("views") != "2" || encodedReq.FormValue("lang") != "en" || encodedReq.FormValue("secret") != "" {
+		t.Errorf("Unexpected r.Form: %v", encodedReq.Form)
+	}
+	secret = form.secret
+	form.wipe()
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Errorf("Expected wipe to zero the secret, got %q", secret)
+	}
+
+	badReq := httptest.NewRequest("POST", "/secret", strings.NewReader("secret=%zz"))
+	badReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := h.parseCreateForm(httptest.NewRecorder(), badReq); err == nil {
+		t.Error("Expected an invalid escape to be rejected")
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"id_rsa":             "id_rsa",
+		"../../etc/passwd":   "passwd",
+		`C:\Users\me\a.p12`:  "a.p12",
+		"evil\"\r\nname.txt": "evilname.txt",
+		"":                   "secret",
+		"..":                 "..",
+	}
+	for in, want := range cases {
+		if got := sanitizeFileName(in); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	// 254 bytes, then a two-byte rune that would end past the limit.
+	long := strings.Repeat("a", maxFileNameLength-1) + "é.txt"
+	if got := sanitizeFileName(long); got != strings.Repeat("a", maxFileNameLength-1) || !utf8.ValidString(got) {
+		t.Errorf("Expected the name cut before the split rune, got %d bytes, valid %t", len(got), utf8.ValidString(got))
+	}
+}
+
+func uploadFile(t *testing.T, baseURL, name string, content []byte) *http.Response {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var csrfToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "csrf_token" {
+			csrfToken = cookie.Value
+		}
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("csrf_token", csrfToken)
+	mw.WriteField("secret", "")
+	part, _ := mw.CreatePart(map[string][]string{
+		"Content-Disposition": {`form-data; name="file"; filename="` + name + `"`},
+		"Content-Type":        {"application/x-pkcs12"},
+	})
+	part.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", baseURL+"/secret", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: csrfToken})
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
@@ -1,13 +1,17 @@
 package web
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/mail"
 	"strconv"
 	"strings"
-	"time"
 
 	"whisperbin/internal"
+	"whisperbin/internal/storage"
 )
 
 func (h *Handler) formHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,24 +19,22 @@ func (h *Handler) formHandler(w http.ResponseWriter, r *http.Request) {
 		h.recipientHandler(w, r)
 		return
 	}
+	h.requireLogin(h.indexHandler)(w, r)
+}
 
-	token, err := h.generateCSRFToken()
+func (h *Handler) indexHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := h.issueCSRFToken(w)
 	if err != nil {
 		http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "csrf_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(10 * time.Minute),
-	})
-
-	h.templates.ExecuteTemplate(w, "index.html", struct{ CSRFToken string }{CSRFToken: token})
+	h.templates.ExecuteTemplate(w, "index.html", struct {
+		CSRFToken     string
+		RequireAPIKey bool
+		NotifyEmail   bool
+		User          *identity
+	}{CSRFToken: token, RequireAPIKey: h.requireAPIKey, NotifyEmail: h.notifyEmail, User: identityFrom(r.Context())})
 }
 
 func (h *Handler) createHandler(w http.ResponseWriter, r *http.Request) {
@@ -41,13 +43,56 @@ func (h *Handler) createHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	form, err := h.parseCreateForm(w, r)
+	if errors.Is(err, errFileTooLarge) {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+	defer form.wipe()
+
 	if !h.validateCSRF(w, r) {
 		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
 		return
 	}
 
-	text := strings.TrimSpace(r.FormValue("secret"))
-	if len(text) > 10240 {
+	limits, err := h.authorizeCreation(strings.TrimSpace(r.FormValue("api_key")))
+	if err != nil {
+		http.Error(w, err.Error(), creationErrorStatus(err))
+		return
+	}
+
+	opaque := r.FormValue("client_side") == "on"
+	text := bytes.TrimSpace(form.secret)
+	if opaque && !validOpaqueBlob(text) {
+		http.Error(w, "Invalid encrypted secret", http.StatusBadRequest)
+		return
+	}
+
+	opts := storage.SaveOptions{Opaque: opaque}
+	if user := identityFrom(r.Context()); user != nil {
+		opts.Creator = user.Subject
+	}
+	if file := form.file; file != nil {
+		if opaque {
+			http.Error(w, "Zero-knowledge mode does not support files", http.StatusBadRequest)
+			return
+		}
+		if len(text) > 0 {
+			http.Error(w, "Provide either a secret or a file, not both", http.StatusBadRequest)
+			return
+		}
+		if int64(len(file.content)) > limits.maxFileBytes {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		text = file.content
+		opts.FileName = file.name
+		opts.ContentType = file.contentType
+	} else if len(text) > maxSecretLength(limits.maxSecretBytes, opaque) {
 		http.Error(w, "Secret too large", http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -58,44 +103,159 @@ func (h *Handler) createHandler(w http.ResponseWriter, r *http.Request) {
 			ttl = parsed
 		}
 	}
-	if ttl < internal.MinTTLMinutes {
-		ttl = internal.MinTTLMinutes
-	} else if ttl > internal.MaxTTLMinutes {
-		ttl = internal.MaxTTLMinutes
-	}
+	ttl = clampTTL(ttl, limits.maxTTLMinutes)
 	secure := r.FormValue("secure") == "on"
+	opts.TTLMinutes = ttl
+	opts.WithApproval = secure
+	if v := strings.TrimSpace(r.FormValue("views")); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			opts.Views = clampViews(parsed)
+		}
+	}
+	if passphrase := r.FormValue("passphrase"); passphrase != "" {
+		if secure {
+			http.Error(w, "Secure mode and passphrase protection cannot be combined", http.StatusBadRequest)
+			return
+		}
+		if len(passphrase) > internal.MaxPassphraseBytes {
+			http.Error(w, "Passphrase too long", http.StatusBadRequest)
+			return
+		}
+		opts.Passphrase = passphrase
+		if parsed, err := strconv.Atoi(strings.TrimSpace(r.FormValue("burn_after"))); err == nil && parsed > 0 {
+			opts.BurnAfter = parsed
+		}
+	}
+	if opts.NotifyEmail, err = h.parseNotifyEmail(r.FormValue("notify_email")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Recipients, err = h.parseRecipients(splitList(r.FormValue("recipients")), splitList(r.FormValue("recipient_groups")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.AllowedCIDRs, err = parseCIDRs(splitList(r.FormValue("allowed_networks"))); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	id, _, err := h.store.Save(text, ttl, secure)
+	if shares := atoiOrZero(r.FormValue("shares")); shares > 0 {
+		threshold := atoiOrZero(r.FormValue("threshold"))
+		if err := validateShareCounts(shares, threshold); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if form.file != nil || opaque || secure || opts.Passphrase != "" || opts.Views > 1 || opts.NotifyEmail != "" || !opts.Recipients.IsZero() || len(opts.AllowedCIDRs) > 0 {
+			http.Error(w, "Shares cannot be combined with files, zero-knowledge, secure mode, passphrases, multiple views, notifications, recipients or network limits", http.StatusBadRequest)
+			return
+		}
+		if len(text) == 0 {
+			http.Error(w, "Secret is required", http.StatusBadRequest)
+			return
+		}
+		if err := h.chargeQuota(limits); err != nil {
+			http.Error(w, err.Error(), creationErrorStatus(err))
+			return
+		}
+		h.createShares(w, text, storage.ShareOptions{TTLMinutes: ttl, Shares: shares, Threshold: threshold, Creator: opts.Creator})
+		return
+	}
+
+	if err := h.chargeQuota(limits); err != nil {
+		http.Error(w, err.Error(), creationErrorStatus(err))
+		return
+	}
+	res, err := h.store.SaveSecret(text, opts)
+	if errors.Is(err, storage.ErrBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server busy, please try again", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Could not save secret", http.StatusInternalServerError)
 		return
 	}
 
-	token, err := h.generateCSRFToken()
+	token, err := h.issueCSRFToken(w)
 	if err != nil {
 		http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "csrf_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(10 * time.Minute),
-	})
-
-	link := fmt.Sprintf("%s/%s", h.allowedOrigin, id)
+	link := fmt.Sprintf("%s/%s", h.allowedOrigin, res.ID)
+	manageLink := h.manageLink(res.ID, res.ManageToken)
 
 	if secure {
 		h.templates.ExecuteTemplate(w, "created_secure.html", struct {
-			Link      string
-			ID        string
-			CSRFToken string
-		}{Link: link, ID: id, CSRFToken: token})
+			Link          string
+			ManageLink    string
+			ID            string
+			CSRFToken     string
+			ZeroKnowledge bool
+		}{Link: link, ManageLink: manageLink, ID: res.ID, CSRFToken: token, ZeroKnowledge: opaque})
 	} else {
-		h.templates.ExecuteTemplate(w, "created.html", struct{ Link string }{Link: link})
+		h.templates.ExecuteTemplate(w, "created.html", struct {
+			Link          string
+			ManageLink    string
+			ZeroKnowledge bool
+		}{Link: link, ManageLink: manageLink, ZeroKnowledge: opaque})
+	}
+}
+
+// parseNotifyEmail validates the optional address that is told when the
+// secret is read. It is only accepted if the server can send email.
+func (h *Handler) parseNotifyEmail(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+	if !h.notifyEmail {
+		return "", errors.New("email notifications are not enabled on this server")
 	}
+	addr, err := mail.ParseAddress(v)
+	if err != nil || addr.Address != v || len(v) > internal.MaxEmailBytes {
+		return "", errors.New("invalid notification email address")
+	}
+	return addr.Address, nil
+}
+
+// splitList splits a form field of comma- or whitespace-separated values.
+func splitList(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' })
+}
+
+func clampViews(views int) int {
+	if views < 1 {
+		return 1
+	} else if views > internal.MaxViews {
+		return internal.MaxViews
+	}
+	return views
+}
+
+func clampTTL(ttl, maxTTL int) int {
+	if ttl < internal.MinTTLMinutes {
+		return internal.MinTTLMinutes
+	} else if ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// Zero-knowledge secrets arrive as base64url(nonce || AES-GCM ciphertext)
+// encrypted in the browser, so the limit accounts for that overhead.
+const opaqueOverhead = 12 + 16
+
+func maxSecretLength(maxBytes int, opaque bool) int {
+	if opaque {
+		return base64.RawURLEncoding.EncodedLen(maxBytes + opaqueOverhead)
+	}
+	return maxBytes
+}
+
+func validOpaqueBlob(blob []byte) bool {
+	raw := make([]byte, base64.RawURLEncoding.DecodedLen(len(blob)))
+	n, err := base64.RawURLEncoding.Decode(raw, blob)
+	return err == nil && n > opaqueOverhead
 }
@@ -106,3 +106,46 @@ func TestCreateHandler_Secure(t *testing.T) {
 		t.Fatalf("Expected 200 OK, got %d", postResp.StatusCode)
 	}
 }
+
+func TestCreateHandler_ZeroKnowledgeRejectsPlaintext(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	client := &http.Client{}
+	getResp, err := client.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer getResp.Body.Close()
+
+	var csrfToken string
+	for _, cookie := range getResp.Cookies() {
+		if cookie.Name == "csrf_token" {
+			csrfToken = cookie.Value
+			break
+		}
+	}
+
+	form := url.Values{}
+	form.Add("secret", "not encrypted!")
+	form.Add("client_side", "on")
+	form.Add("csrf_token", csrfToken)
+
+	postReq, _ := http.NewRequest("POST", server.URL+"/secret", strings.NewReader(form.Encode()))
+	postReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	postReq.AddCookie(&http.Cookie{Name: "csrf_token", Value: csrfToken})
+
+	postResp, err := client.Do(postReq)
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	defer postResp.Body.Close()
+
+	if postResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 Bad Request, got %d", postResp.StatusCode)
+	}
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
@@ -8,8 +9,12 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"whisperbin/internal"
+	"whisperbin/internal/apikey"
+	"whisperbin/internal/oidc"
 	"whisperbin/internal/storage"
 )
 
@@ -19,6 +24,12 @@ type Handler struct {
 	allowedOrigin string
 	ipLimiter     *ipLimiter
 	trustProxy    bool
+	keyring       *apikey.Keyring
+	requireAPIKey bool
+	maxFileBytes  int64
+	notifyEmail   bool
+	auth          *oidc.Provider
+	sessionKey    []byte
 }
 
 func NewHandler(store *storage.Store) *Handler {
@@ -40,6 +51,7 @@ func NewHandlerWithTemplates(store *storage.Store, pattern string) *Handler {
 			}
 			return dict
 		},
+		"inc": func(i int) int { return i + 1 },
 	})
 	tmpl = template.Must(tmpl.ParseGlob(pattern))
 
@@ -50,13 +62,44 @@ func NewHandlerWithTemplates(store *storage.Store, pattern string) *Handler {
 
 	ipLimiter := newIPLimiter(internal.RateLimiterRate, internal.RateLimiterBurst)
 
-	return &Handler{
+	keyring, err := loadKeyring()
+	if err != nil {
+		panic("invalid API keys: " + err.Error())
+	}
+	requireAPIKey := os.Getenv("REQUIRE_API_KEY") == "true"
+	if requireAPIKey && keyring == nil {
+		panic("REQUIRE_API_KEY is set but no API_KEYS_FILE or API_KEYS configured")
+	}
+
+	maxFileBytes := int64(internal.DefaultMaxFileBytes)
+	if v := os.Getenv("MAX_FILE_BYTES"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			panic("invalid MAX_FILE_BYTES: must be a positive number of bytes")
+		}
+		maxFileBytes = parsed
+	}
+
+	provider, sessionKey, err := loadOIDC(allowedOrigin)
+	if err != nil {
+		panic("invalid OIDC configuration: " + err.Error())
+	}
+
+	h := &Handler{
 		store:         store,
 		templates:     tmpl,
 		allowedOrigin: allowedOrigin,
 		ipLimiter:     ipLimiter,
 		trustProxy:    os.Getenv("TRUST_PROXY") == "true",
+		keyring:       keyring,
+		requireAPIKey: requireAPIKey,
+		maxFileBytes:  maxFileBytes,
+		notifyEmail:   os.Getenv("SMTP_ADDR") != "",
 	}
+	if provider != nil {
+		h.setAuth(provider, sessionKey)
+	}
+	return h
 }
 
 func (h *Handler) generateCSRFToken() (string, error) {
@@ -67,6 +110,25 @@ func (h *Handler) generateCSRFToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
+// issueCSRFToken generates a token and sets it as the csrf_token cookie.
+func (h *Handler) issueCSRFToken(w http.ResponseWriter) (string, error) {
+	token, err := h.generateCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Now().Add(10 * time.Minute),
+	})
+	return token, nil
+}
+
 func (h *Handler) validateCSRF(w http.ResponseWriter, r *http.Request) bool {
 	cookie, err := r.Cookie("csrf_token")
 	if err != nil {
@@ -90,6 +152,28 @@ func (h *Handler) renderError(w http.ResponseWriter, status int, title string, m
 	})
 }
 
+// secretMarker stands in for the plaintext when show.html is executed, so
+// the template never sees the secret as a string.
+const secretMarker = "whisperbin-secret-placeholder"
+
+// renderSecret shows a revealed secret. The page is rendered around a
+// marker and the secret is escaped straight from its buffer into w.
+func (h *Handler) renderSecret(w http.ResponseWriter, secret []byte, opaque bool) {
+	var page bytes.Buffer
+	err := h.templates.ExecuteTemplate(&page, "show.html", struct {
+		Text   string
+		Opaque bool
+	}{Text: secretMarker, Opaque: opaque})
+	before, after, found := bytes.Cut(page.Bytes(), []byte(secretMarker))
+	if err != nil || !found {
+		h.renderError(w, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred.")
+		return
+	}
+	w.Write(before)
+	template.HTMLEscape(w, secret)
+	w.Write(after)
+}
+
 func (h *Handler) renderSuccess(w http.ResponseWriter, title string, message string) {
 	h.templates.ExecuteTemplate(w, "success.html", struct {
 		Title   string
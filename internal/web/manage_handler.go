@@ -0,0 +1,96 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+func (h *Handler) manageLink(id, token string) string {
+	return fmt.Sprintf("%s/manage/%s/%s", h.allowedOrigin, id, token)
+}
+
+// manageHandler serves the sender's private page at /manage/{id}/{token}:
+// GET shows the receipt, POST revokes the secret or, with action=approve,
+// unlocks a secure-mode secret with the recipient's passcode.
+func (h *Handler) manageHandler(w http.ResponseWriter, r *http.Request) {
+	id, token, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/manage/"), "/")
+
+	// The token is part of the URL, so keep it out of caches and referrers.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	receipt, err := h.store.Receipt(id, token)
+	if err != nil {
+		h.renderError(w, http.StatusNotFound, "Not Found", "Receipt not found or expired.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		csrfToken, err := h.issueCSRFToken(w)
+		if err != nil {
+			http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
+			return
+		}
+		sec, err := h.store.Get(id)
+		approve := err == nil && sec.Code != "" && !sec.Unlocked
+		h.templates.ExecuteTemplate(w, "manage.html", struct {
+			Ref       string
+			Approve   bool
+			Status    string
+			CreatedAt time.Time
+			ExpiresAt time.Time
+			ViewedAt  []time.Time
+			ViewsLeft int
+			RevokedAt time.Time
+			CanRevoke bool
+			CSRFToken string
+		}{
+			Ref:       storage.Ref(id),
+			Approve:   approve,
+			Status:    receipt.Status(),
+			CreatedAt: receipt.CreatedAt,
+			ExpiresAt: receipt.ExpiresAt,
+			ViewedAt:  receipt.ViewedAt,
+			ViewsLeft: receipt.ViewsLeft(),
+			RevokedAt: receipt.RevokedAt,
+			CanRevoke: receipt.Status() == storage.ReceiptPending,
+			CSRFToken: csrfToken,
+		})
+	case http.MethodPost:
+		if !h.validateCSRF(w, r) {
+			h.renderError(w, http.StatusForbidden, "Forbidden", "Invalid CSRF token. Please reload the page and try again.")
+			return
+		}
+		if r.FormValue("action") == "approve" {
+			h.manageApprove(w, r, id)
+			return
+		}
+		if err := h.store.Revoke(id, token); err != nil {
+			h.renderError(w, http.StatusConflict, "Not Revoked", "The secret was already read, revoked or has expired.")
+			return
+		}
+		h.renderSuccess(w, "Secret revoked", "The link no longer works.")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Method not allowed.")
+	}
+}
+
+// manageApprove unlocks a secure-mode secret from its management page,
+// which is where chat notifications send the sender.
+func (h *Handler) manageApprove(w http.ResponseWriter, r *http.Request, id string) {
+	if sec, err := h.store.Get(id); err == nil && h.checkNetwork(r, sec) != nil {
+		h.renderNetworkError(w)
+		return
+	}
+	if err := h.store.Confirm(id, strings.TrimSpace(r.FormValue("code")), h.clientIP(r)); err != nil {
+		h.renderError(w, http.StatusForbidden, "Invalid Request", "Invalid confirmation code or expired link.")
+		return
+	}
+	h.renderSuccess(w, "Secret unlocked", "Recipient can now view the secret.")
+}
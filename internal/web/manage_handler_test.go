@@ -0,0 +1,140 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+func TestManageHandler_StatusAndRevoke(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("wrong channel", storage.SaveOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	managePath := "/manage/" + res.ID + "/" + res.ManageToken
+	resp, err := http.Get(server.URL + managePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Not read yet") {
+		t.Fatalf("Expected pending receipt page, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Referrer-Policy") != "no-referrer" {
+		t.Error("Expected management page to disable referrers")
+	}
+	var token string
+	for _, c := range resp.Cookies() {
+		if c.Name == "csrf_token" {
+			token = c.Value
+		}
+	}
+
+	form := url.Values{"csrf_token": {token}}
+	req, _ := http.NewRequest("POST", server.URL+managePath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected revoke to succeed, got %d", resp.StatusCode)
+	}
+
+	if _, err := store.Get(res.ID); err == nil {
+		t.Error("Expected secret to be deleted after revoke")
+	}
+	resp, _ = http.Get(server.URL + managePath)
+	body = readBody(t, resp)
+	resp.Body.Close()
+	if !strings.Contains(body, "revoked") {
+		t.Error("Expected receipt to show the revocation")
+	}
+}
+
+func TestManageHandler_WrongTokenIsNotFound(t *testing.T) {
+	store := storage.NewStore()
+	res, _ := store.SaveWithOptions("x", storage.SaveOptions{TTLMinutes: 5})
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	for _, path := range []string{"/manage/" + res.ID + "/guess", "/manage/" + res.ID, "/manage/"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestManageHandler_ApprovesSecureSecret(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("locked", storage.SaveOptions{TTLMinutes: 5, WithApproval: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	managePath := server.URL + "/manage/" + res.ID + "/" + res.ManageToken
+	resp, err := http.Get(managePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	resp.Body.Close()
+	if !strings.Contains(body, `value="approve"`) || !strings.Contains(body, storage.Ref(res.ID)) {
+		t.Fatal("Expected the passcode form and the secret's ref on the management page")
+	}
+
+	waiting := make(chan struct{}, 1)
+	store.Events().Subscribe(func(e storage.Event) {
+		if e.Type == storage.EventWaiting {
+			waiting <- struct{}{}
+		}
+	})
+	go store.WaitForUnlock(context.Background(), res.ID)
+	select {
+	case <-waiting:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the recipient")
+	}
+
+	resp, _ = postWithCSRF(t, managePath, "test-csrf-token", url.Values{"action": {"approve"}, "code": {"wrong"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a wrong passcode to be rejected, got %d", resp.StatusCode)
+	}
+	resp, _ = postWithCSRF(t, managePath, "test-csrf-token", url.Values{"action": {"approve"}, "code": {res.Code}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the passcode to unlock the secret, got %d", resp.StatusCode)
+	}
+	sec, err := store.Get(res.ID)
+	if err != nil || !sec.Unlocked {
+		t.Errorf("Expected the secret to be unlocked and still stored, got %v", err)
+	}
+}
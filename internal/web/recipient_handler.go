@@ -1,10 +1,13 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
+
+	"whisperbin/internal/secmem"
+	"whisperbin/internal/storage"
 )
 
 func (h *Handler) recipientHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,64 +18,144 @@ func (h *Handler) recipientHandler(w http.ResponseWriter, r *http.Request) {
 		h.renderError(w, http.StatusNotFound, "Not Found", "Secret not found or expired.")
 		return
 	}
+	if err := h.checkNetwork(r, secret); err != nil {
+		h.renderNetworkError(w)
+		return
+	}
+	if err := h.checkRecipient(r, secret); err != nil {
+		h.renderRecipientError(w, r, err)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
+		if secret.IsShare() {
+			h.renderShare(w, secret)
+			return
+		}
+		if secret.Awaiting {
+			h.templates.ExecuteTemplate(w, "requested.html", struct {
+				ID       string
+				Link     string
+				DropLink string
+			}{ID: id, Link: fmt.Sprintf("%s/%s", h.allowedOrigin, id)})
+			return
+		}
 		if !secret.Unlocked {
+			token, err := h.issueCSRFToken(w)
+			if err != nil {
+				http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
+				return
+			}
 			data := struct {
-				ID   string
-				Code string
+				ID        string
+				Code      string
+				Opaque    bool
+				FileName  string
+				CSRFToken string
 			}{
-				ID:   id,
-				Code: secret.Code,
+				ID:        id,
+				Code:      secret.Code,
+				Opaque:    secret.Opaque,
+				FileName:  secret.FileName,
+				CSRFToken: token,
 			}
 			h.templates.ExecuteTemplate(w, "waiting.html", data)
 			return
 		}
-		h.renderReveal(w, id)
+		h.renderReveal(w, id, secret, "")
 	case http.MethodPost:
 		if !secret.Unlocked {
 			h.renderError(w, http.StatusNotFound, "Not Found", "Secret not found or expired.")
 			return
 		}
+		if secret.IsShare() {
+			h.renderError(w, http.StatusConflict, "Share Link", "Shares can only be used on the combine page.")
+			return
+		}
 		if !h.validateCSRF(w, r) {
 			h.renderError(w, http.StatusForbidden, "Forbidden", "Invalid CSRF token. Please reload the page and try again.")
 			return
 		}
-		text, err := h.store.DecryptSecretText(secret)
-		if err != nil {
-			h.renderError(w, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred.")
+		var text []byte
+		if secret.HasPassphrase() {
+			taken, plain, err := h.store.UnsealSecret(id, r.FormValue("passphrase"), h.clientIP(r))
+			if err != nil {
+				h.renderUnsealError(w, id, secret, err)
+				return
+			}
+			secret, text = taken, plain
+		} else {
+			secret, err = h.store.Take(id)
+			if err != nil {
+				h.renderError(w, http.StatusNotFound, "Not Found", "Secret not found or expired.")
+				return
+			}
+			text, err = h.store.DecryptSecret(secret)
+			if err != nil {
+				h.renderError(w, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred.")
+				return
+			}
+		}
+		defer secmem.Free(text)
+		if secret.IsFile() {
+			serveFile(w, secret, text)
 			return
 		}
-		h.store.Delete(id)
-		h.templates.ExecuteTemplate(w, "show.html", text)
+		h.renderSecret(w, text, secret.Opaque)
 	default:
 		w.Header().Set("Allow", "GET, POST")
 		h.renderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Method not allowed.")
 	}
 }
 
-func (h *Handler) renderReveal(w http.ResponseWriter, id string) {
-	token, err := h.generateCSRFToken()
+// renderReveal shows the reveal form. A non-empty message re-renders it
+// after a wrong passphrase.
+func (h *Handler) renderReveal(w http.ResponseWriter, id string, secret *storage.Secret, message string) {
+	token, err := h.issueCSRFToken(w)
 	if err != nil {
 		http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "csrf_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(10 * time.Minute),
+	if message != "" {
+		w.WriteHeader(http.StatusForbidden)
+	}
+	h.templates.ExecuteTemplate(w, "reveal.html", struct {
+		ID         string
+		CSRFToken  string
+		Opaque     bool
+		FileName   string
+		Views      int
+		Passphrase bool
+		Error      string
+	}{
+		ID:         id,
+		CSRFToken:  token,
+		Opaque:     secret.Opaque,
+		FileName:   secret.FileName,
+		Views:      secret.ViewsLeft(),
+		Passphrase: secret.HasPassphrase(),
+		Error:      message,
 	})
+}
 
-	h.templates.ExecuteTemplate(w, "reveal.html", struct {
-		ID        string
-		CSRFToken string
-	}{ID: id, CSRFToken: token})
+func (h *Handler) renderUnsealError(w http.ResponseWriter, id string, secret *storage.Secret, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidPassphrase):
+		h.renderReveal(w, id, secret, "Wrong passphrase. Please try again.")
+	case errors.Is(err, storage.ErrBlocked):
+		h.renderError(w, http.StatusTooManyRequests, "Too Many Attempts", "Too many wrong passphrases. Please try again later.")
+	case errors.Is(err, storage.ErrBurned):
+		h.renderError(w, http.StatusGone, "Secret Destroyed", "Too many wrong passphrases. The secret has been destroyed.")
+	case errors.Is(err, storage.ErrBusy):
+		w.Header().Set("Retry-After", "1")
+		h.renderError(w, http.StatusServiceUnavailable, "Busy", "Too many passphrases are being checked right now. Please try again in a moment.")
+	case errors.Is(err, storage.ErrNotFound):
+		h.renderError(w, http.StatusNotFound, "Not Found", "Secret not found or expired.")
+	default:
+		h.renderError(w, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred.")
+	}
 }
 
 func (h *Handler) statusHandler(w http.ResponseWriter, r *http.Request) {
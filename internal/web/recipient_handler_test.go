@@ -75,6 +75,84 @@ func TestPostHandler_RevealsSecretAndDeletes(t *testing.T) {
 	}
 }
 
+func TestPostHandler_MultiViewDeletesAfterLastView(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("shared", storage.SaveOptions{TTLMinutes: 5, Views: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	page, err := http.Get(server.URL + "/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageBody := readBody(t, page)
+	page.Body.Close()
+	if !strings.Contains(pageBody, "2 views remaining") {
+		t.Error("Expected remaining views on reveal page")
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, body := revealPost(t, server.URL, id, getRevealToken(t, server.URL, id))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, "shared") {
+			t.Fatalf("Expected reveal to succeed, got %d", resp.StatusCode)
+		}
+	}
+
+	resp, _ := http.Get(server.URL + "/" + id)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Expected 404 after the last view")
+	}
+}
+
+func TestPostHandler_Passphrase(t *testing.T) {
+	store := storage.NewStore()
+	res, err := store.SaveWithOptions("behind a passphrase", storage.SaveOptions{TTLMinutes: 5, Passphrase: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	page, err := http.Get(server.URL + "/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, page); !strings.Contains(body, `name="passphrase"`) {
+		t.Error("Expected passphrase field on reveal page")
+	}
+	page.Body.Close()
+
+	resp, body := revealPostForm(t, server.URL, id, getRevealToken(t, server.URL, id), url.Values{"passphrase": {"hunter3"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "Wrong passphrase") {
+		t.Fatalf("Expected 403 with retry form, got %d", resp.StatusCode)
+	}
+	if strings.Contains(body, "behind a passphrase") {
+		t.Fatal("Secret shown for wrong passphrase")
+	}
+
+	resp, body = revealPostForm(t, server.URL, id, getRevealToken(t, server.URL, id), url.Values{"passphrase": {"hunter2"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "behind a passphrase") {
+		t.Errorf("Expected secret after correct passphrase, got %d", resp.StatusCode)
+	}
+	if _, err := store.Get(id); err == nil {
+		t.Error("Expected secret to be consumed")
+	}
+}
+
 func TestPostHandler_InvalidCSRFDoesNotConsume(t *testing.T) {
 	store := storage.NewStore()
 	id, _, err := store.Save("still here", 5, false)
@@ -106,6 +184,34 @@ func TestPostHandler_InvalidCSRFDoesNotConsume(t *testing.T) {
 	}
 }
 
+func TestPostHandler_ZeroKnowledgeReturnsOpaqueBlob(t *testing.T) {
+	store := storage.NewStore()
+	blob := "q83vEjRWeJCrze8SNFZ4kKvN7xI0VniQ"
+	res, err := store.SaveWithOptions(blob, storage.SaveOptions{TTLMinutes: 5, Opaque: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp, body := revealPost(t, server.URL, id, getRevealToken(t, server.URL, id))
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(body, blob) {
+		t.Error("Expected opaque blob to be passed through unchanged")
+	}
+	if !strings.Contains(body, `decryptField("secret")`) {
+		t.Error("Expected client-side decryption for opaque secret")
+	}
+}
+
 func TestGetHandler_SecureModeWait(t *testing.T) {
 	store := storage.NewStore()
 	id, code, err := store.Save("locked secret", 5, true)
@@ -153,8 +259,12 @@ func getRevealToken(t *testing.T, baseURL, id string) string {
 
 func revealPost(t *testing.T, baseURL, id, token string) (*http.Response, string) {
 	t.Helper()
-	form := url.Values{}
-	form.Add("csrf_token", token)
+	return revealPostForm(t, baseURL, id, token, url.Values{})
+}
+
+func revealPostForm(t *testing.T, baseURL, id, token string, form url.Values) (*http.Response, string) {
+	t.Helper()
+	form.Set("csrf_token", token)
 
 	req, _ := http.NewRequest("POST", baseURL+"/"+id, strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"whisperbin/internal"
+	"whisperbin/internal/storage"
+)
+
+// requestHandler lets a recipient ask for a secret: GET shows the form,
+// POST creates the request and a drop link for whoever holds the secret.
+func (h *Handler) requestHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token, err := h.issueCSRFToken(w)
+		if err != nil {
+			http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
+			return
+		}
+		h.templates.ExecuteTemplate(w, "request.html", struct {
+			CSRFToken     string
+			RequireAPIKey bool
+		}{CSRFToken: token, RequireAPIKey: h.requireAPIKey})
+	case http.MethodPost:
+		h.createRequest(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Method not allowed.")
+	}
+}
+
+func (h *Handler) createRequest(w http.ResponseWriter, r *http.Request) {
+	if !h.validateCSRF(w, r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	limits, err := h.authorizeCreation(strings.TrimSpace(r.FormValue("api_key")))
+	if err != nil {
+		http.Error(w, err.Error(), creationErrorStatus(err))
+		return
+	}
+
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len(note) > internal.MaxRequestNoteBytes {
+		http.Error(w, "Note too long", http.StatusBadRequest)
+		return
+	}
+	ttl := internal.DefaultTTLMinutes
+	if v := strings.TrimSpace(r.FormValue("ttl")); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			ttl = parsed
+		}
+	}
+
+	opts := storage.RequestOptions{TTLMinutes: clampTTL(ttl, limits.maxTTLMinutes), Note: note}
+	if user := identityFrom(r.Context()); user != nil {
+		opts.Creator = user.Subject
+	}
+	if err := h.chargeQuota(limits); err != nil {
+		http.Error(w, err.Error(), creationErrorStatus(err))
+		return
+	}
+	res, err := h.store.Request(opts)
+	if err != nil {
+		http.Error(w, "Could not create request", http.StatusInternalServerError)
+		return
+	}
+
+	h.templates.ExecuteTemplate(w, "requested.html", struct {
+		ID       string
+		Link     string
+		DropLink string
+	}{
+		ID:       res.ID,
+		Link:     fmt.Sprintf("%s/%s", h.allowedOrigin, res.ID),
+		DropLink: fmt.Sprintf("%s/drop/%s", h.allowedOrigin, res.DropID),
+	})
+}
+
+// dropHandler serves the holder's side of a request at /drop/{id}: GET
+// shows the requester's note and a form, POST hands the secret over and
+// invalidates the link.
+func (h *Handler) dropHandler(w http.ResponseWriter, r *http.Request) {
+	dropID := strings.TrimPrefix(r.URL.Path, "/drop/")
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	note, err := h.store.Drop(dropID)
+	if err != nil {
+		h.renderError(w, http.StatusNotFound, "Not Found", "This request was already answered or has expired.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		token, err := h.issueCSRFToken(w)
+		if err != nil {
+			http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
+			return
+		}
+		h.templates.ExecuteTemplate(w, "drop.html", struct {
+			Note      string
+			CSRFToken string
+		}{Note: note, CSRFToken: token})
+	case http.MethodPost:
+		form, err := h.parseCreateForm(w, r)
+		if err != nil {
+			h.renderError(w, http.StatusBadRequest, "Bad Request", "The form could not be read.")
+			return
+		}
+		defer form.wipe()
+		if !h.validateCSRF(w, r) {
+			h.renderError(w, http.StatusForbidden, "Forbidden", "Invalid CSRF token. Please reload the page and try again.")
+			return
+		}
+		text := bytes.TrimSpace(form.secret)
+		if len(text) == 0 {
+			h.renderError(w, http.StatusBadRequest, "Bad Request", "The secret must not be empty.")
+			return
+		}
+		if len(text) > internal.MaxSecretBytes {
+			h.renderError(w, http.StatusRequestEntityTooLarge, "Too Large", "The secret is too large.")
+			return
+		}
+		if err := h.store.Fulfill(dropID, text); err != nil {
+			h.renderError(w, http.StatusNotFound, "Not Found", "This request was already answered or has expired.")
+			return
+		}
+		h.renderSuccess(w, "Secret sent", "The requester can now reveal it once. This link no longer works.")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Method not allowed.")
+	}
+}
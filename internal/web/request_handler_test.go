@@ -0,0 +1,171 @@
+package web
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"whisperbin/internal/storage"
+)
+
+var dropLinkPattern = regexp.MustCompile(`/drop/([A-Za-z0-9_-]+)`)
+
+func TestRequestHandler_DropDeliversToWaitingRequester(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	token := "test-csrf-token"
+	resp, body := postWithCSRF(t, server.URL+"/request", token, url.Values{"note": {"staging DB password"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected request to be created, got %d", resp.StatusCode)
+	}
+	match := dropLinkPattern.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("Expected a drop link on the request page")
+	}
+	dropID := match[1]
+	id := strings.TrimPrefix(regexp.MustCompile(`/sse\?id=([A-Za-z0-9_-]+)`).FindString(body), "/sse?id=")
+	if id == "" || id == dropID {
+		t.Fatalf("Expected a separate requester ID, got %q", id)
+	}
+
+	resp, err := http.Get(server.URL + "/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = readBody(t, resp)
+	resp.Body.Close()
+	if !strings.Contains(body, "Waiting for the secret") || strings.Contains(body, "/drop/") {
+		t.Error("Expected the requester's link to show the waiting page without the drop link")
+	}
+
+	resp, err = http.Get(server.URL + "/drop/" + dropID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "staging DB password") {
+		t.Fatalf("Expected drop page with the requester's note, got %d", resp.StatusCode)
+	}
+
+	received := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(server.URL + "/sse?id=" + id)
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				received <- data
+				return
+			}
+		}
+		received <- ""
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resp, _ = postWithCSRF(t, server.URL+"/drop/"+dropID, token, url.Values{"secret": {"hunter2"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected submission to succeed, got %d", resp.StatusCode)
+	}
+
+	select {
+	case got := <-received:
+		if got != "hunter2" {
+			t.Errorf("Expected requester to receive %q, got %q", "hunter2", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the submitted secret")
+	}
+
+	if _, err := store.Get(id); err == nil {
+		t.Error("Expected secret to be deleted after delivery")
+	}
+	resp, _ = postWithCSRF(t, server.URL+"/drop/"+dropID, token, url.Values{"secret": {"again"}})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected drop link to work only once, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequestHandler_DropLinkCannotReveal(t *testing.T) {
+	store := storage.NewStore()
+	req, err := store.Request(storage.RequestOptions{TTLMinutes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Fulfill(req.DropID, []byte("for the requester only")); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	for _, path := range []string{"/" + req.DropID, "/drop/" + req.ID, "/drop/" + req.DropID} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, body := revealPostForm(t, server.URL, req.ID, "tok", url.Values{})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "for the requester only") {
+		t.Errorf("Expected requester to reveal the submitted secret, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequestHandler_RejectsEmptyAndLongInput(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	token := "test-csrf-token"
+	resp, _ := postWithCSRF(t, server.URL+"/request", token, url.Values{"note": {strings.Repeat("x", 501)}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a long note, got %d", resp.StatusCode)
+	}
+
+	req, _ := store.Request(storage.RequestOptions{TTLMinutes: 5})
+	resp, _ = postWithCSRF(t, server.URL+"/drop/"+req.DropID, token, url.Values{"secret": {"  "}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty secret, got %d", resp.StatusCode)
+	}
+	if _, err := store.Drop(req.DropID); err != nil {
+		t.Errorf("Expected a rejected submission to keep the drop link, got %v", err)
+	}
+}
+
+func postWithCSRF(t *testing.T, target, token string, form url.Values) (*http.Response, string) {
+	t.Helper()
+	form.Set("csrf_token", token)
+
+	req, _ := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp, readBody(t, resp)
+}
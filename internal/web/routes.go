@@ -74,10 +74,21 @@ func (h *Handler) Routes() http.Handler {
 	fs := http.FileServer(http.Dir("ui/static"))
 	mux.HandleFunc("/privacy", h.privacyHandler)
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-	mux.HandleFunc("/secret", h.rateLimit(h.createHandler))
+	mux.HandleFunc("/secret", h.rateLimit(h.requireLogin(h.createHandler)))
 	mux.HandleFunc("/confirm/", h.rateLimit(h.confirmHandler))
 	mux.HandleFunc("/status/", h.rateLimit(h.statusHandler))
 	mux.HandleFunc("/sse", h.rateLimit(h.SSEHandler))
+	mux.HandleFunc("/manage/", h.rateLimit(h.manageHandler))
+	mux.HandleFunc("/request", h.rateLimit(h.requireLogin(h.requestHandler)))
+	mux.HandleFunc("/login", h.rateLimit(h.loginHandler))
+	mux.HandleFunc("/auth/callback", h.rateLimit(h.callbackHandler))
+	mux.HandleFunc("/logout", h.logoutHandler)
+	mux.HandleFunc("/drop/", h.rateLimit(h.dropHandler))
+	mux.HandleFunc("/combine", h.rateLimit(h.combineHandler))
+	mux.HandleFunc(sharesPrefix, h.apiRateLimit(h.apiSharesHandler))
+	mux.HandleFunc(sharesPrefix+"/combine", h.apiRateLimit(h.apiCombineHandler))
+	mux.HandleFunc(apiPrefix, h.apiRateLimit(h.apiHandler))
+	mux.HandleFunc(apiPrefix+"/", h.apiRateLimit(h.apiSecretHandler))
 	mux.HandleFunc("/", h.formHandler)
 
 	return mux
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"whisperbin/internal"
+	"whisperbin/internal/secmem"
+	"whisperbin/internal/storage"
+)
+
+type shareLink struct {
+	Link       string
+	ManageLink string
+}
+
+// validateShareCounts checks the requested M-of-N split.
+func validateShareCounts(shares, threshold int) error {
+	if shares < 2 || shares > internal.MaxShares {
+		return fmt.Errorf("shares must be between 2 and %d", internal.MaxShares)
+	}
+	if threshold < 2 || threshold > shares {
+		return errors.New("threshold must be between 2 and the number of shares")
+	}
+	return nil
+}
+
+func (h *Handler) createShares(w http.ResponseWriter, text []byte, opts storage.ShareOptions) {
+	results, err := h.store.SaveShares(text, opts)
+	if err != nil {
+		http.Error(w, "Could not save secret", http.StatusInternalServerError)
+		return
+	}
+
+	links := make([]shareLink, len(results))
+	for i, res := range results {
+		links[i] = shareLink{
+			Link:       fmt.Sprintf("%s/%s", h.allowedOrigin, res.ID),
+			ManageLink: h.manageLink(res.ID, res.ManageToken),
+		}
+	}
+	h.templates.ExecuteTemplate(w, "created_shares.html", struct {
+		Shares      []shareLink
+		Threshold   int
+		CombineLink string
+	}{Shares: links, Threshold: opts.Threshold, CombineLink: h.allowedOrigin + "/combine"})
+}
+
+// combineHandler serves /combine: GET shows a form for share links, POST
+// consumes them and shows the recovered secret.
+func (h *Handler) combineHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token, err := h.issueCSRFToken(w)
+		if err != nil {
+			http.Error(w, "Could not generate CSRF token", http.StatusInternalServerError)
+			return
+		}
+		h.templates.ExecuteTemplate(w, "combine.html", struct {
+			CSRFToken string
+		}{CSRFToken: token})
+	case http.MethodPost:
+		if !h.validateCSRF(w, r) {
+			h.renderError(w, http.StatusForbidden, "Forbidden", "Invalid CSRF token. Please reload the page and try again.")
+			return
+		}
+		text, err := h.store.Combine(shareIDs(strings.Fields(r.FormValue("links"))))
+		if err != nil {
+			h.renderCombineError(w, err)
+			return
+		}
+		defer secmem.Free(text)
+		h.renderSecret(w, text, false)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.renderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Method not allowed.")
+	}
+}
+
+func (h *Handler) renderCombineError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotEnoughShares):
+		h.renderError(w, http.StatusBadRequest, "Not Enough Shares", "More share links are needed to recover the secret. None of them were used.")
+	case errors.Is(err, storage.ErrInvalidShares):
+		h.renderError(w, http.StatusBadRequest, "Invalid Shares", "The links do not belong to the same secret.")
+	case errors.Is(err, storage.ErrNotFound):
+		h.renderError(w, http.StatusNotFound, "Not Found", "A share link was not found or has expired.")
+	default:
+		h.renderError(w, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred.")
+	}
+}
+
+// renderShare tells the holder of a share link how to use it; shares are
+// only consumed through the combine page.
+func (h *Handler) renderShare(w http.ResponseWriter, secret *storage.Secret) {
+	h.templates.ExecuteTemplate(w, "share.html", struct {
+		Threshold int
+	}{Threshold: secret.Threshold})
+}
+
+// shareIDs accepts share links or bare IDs.
+func shareIDs(links []string) []string {
+	ids := make([]string, 0, len(links))
+	for _, link := range links {
+		link, _, _ = strings.Cut(link, "#")
+		link = strings.TrimSuffix(link, "/")
+		if i := strings.LastIndex(link, "/"); i >= 0 {
+			link = link[i+1:]
+		}
+		if link != "" {
+			ids = append(ids, link)
+		}
+	}
+	return ids
+}
+
+func atoiOrZero(v string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(v))
+	return n
+}
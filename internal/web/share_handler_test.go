@@ -0,0 +1,130 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"whisperbin/internal/storage"
+)
+
+func TestShares_FormSplitAndCombine(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	token := "test-csrf-token"
+	resp, body := postWithCSRF(t, server.URL+"/secret", token, url.Values{
+		"secret": {"root password"}, "shares": {"3"}, "threshold": {"2"},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected shares to be created, got %d", resp.StatusCode)
+	}
+	links := regexp.MustCompile(`id="share-link-\d" value="([^"]+)"`).FindAllStringSubmatch(body, -1)
+	if len(links) != 3 {
+		t.Fatalf("Expected 3 share links, got %d", len(links))
+	}
+	ids := shareIDs([]string{links[0][1], links[1][1], links[2][1]})
+
+	resp, err := http.Get(server.URL + "/" + ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = readBody(t, resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "one share") {
+		t.Errorf("Expected share page, got %d", resp.StatusCode)
+	}
+	resp, _ = revealPostForm(t, server.URL, ids[0], token, url.Values{})
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected share reveal to be refused, got %d", resp.StatusCode)
+	}
+
+	resp, _ = postWithCSRF(t, server.URL+"/combine", token, url.Values{"links": {links[0][1]}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 with one of two shares, got %d", resp.StatusCode)
+	}
+	if _, err := store.Get(ids[0]); err != nil {
+		t.Errorf("Expected share to survive a failed combine: %v", err)
+	}
+
+	resp, body = postWithCSRF(t, server.URL+"/combine", token, url.Values{"links": {links[0][1] + "\n" + links[2][1]}})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "root password") {
+		t.Errorf("Expected recovered secret, got %d", resp.StatusCode)
+	}
+}
+
+func TestShares_FormRejectsInvalidSplit(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	token := "test-csrf-token"
+	for name, form := range map[string]url.Values{
+		"threshold above shares": {"secret": {"x"}, "shares": {"2"}, "threshold": {"3"}},
+		"too many shares":        {"secret": {"x"}, "shares": {"17"}, "threshold": {"2"}},
+		"with secure mode":       {"secret": {"x"}, "shares": {"3"}, "threshold": {"2"}, "secure": {"on"}},
+	} {
+		resp, _ := postWithCSRF(t, server.URL+"/secret", token, form)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPI_SharesSplitAndCombine(t *testing.T) {
+	store := storage.NewStore()
+	tmpl := projectRootPath("ui/templates/*.html")
+	h := NewHandlerWithTemplates(store, tmpl)
+	server := httptest.NewServer(h.Routes())
+	defer server.Close()
+
+	resp := apiRequest(t, "POST", server.URL+"/api/v1/shares", `{"secret":"break glass","shares":5,"threshold":3}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", resp.StatusCode)
+	}
+	var created apiSharesResponse
+	decodeJSON(t, resp, &created)
+	if created.Threshold != 3 || len(created.Shares) != 5 {
+		t.Fatalf("Unexpected response: %+v", created)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/secrets/"+created.Shares[0].ID+"/reveal", "")
+	var apiErr apiError
+	decodeJSON(t, resp, &apiErr)
+	if resp.StatusCode != http.StatusConflict || apiErr.Code != "combine_required" {
+		t.Errorf("Expected combine_required, got %d %q", resp.StatusCode, apiErr.Code)
+	}
+
+	combine := func(links ...string) *http.Response {
+		body, _ := json.Marshal(apiCombineRequest{Links: links})
+		return apiRequest(t, "POST", server.URL+"/api/v1/shares/combine", string(body))
+	}
+
+	resp = combine(created.Shares[0].Link, created.Shares[1].ID)
+	decodeJSON(t, resp, &apiErr)
+	if resp.StatusCode != http.StatusBadRequest || apiErr.Code != "not_enough_shares" {
+		t.Errorf("Expected not_enough_shares, got %d %q", resp.StatusCode, apiErr.Code)
+	}
+
+	resp = combine(created.Shares[0].Link, created.Shares[1].ID, created.Shares[4].Link)
+	var revealed apiRevealResponse
+	decodeJSON(t, resp, &revealed)
+	if resp.StatusCode != http.StatusOK || revealed.Secret != "break glass" {
+		t.Errorf("Expected recovered secret, got %d %q", resp.StatusCode, revealed.Secret)
+	}
+
+	resp = apiRequest(t, "POST", server.URL+"/api/v1/shares", `{"secret":"x","shares":3,"threshold":1}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for threshold 1, got %d", resp.StatusCode)
+	}
+}
@@ -1,10 +1,14 @@
 package web
 
 import (
+	"bytes"
 	"fmt"
 	"html"
+	"html/template"
+	"io"
 	"net/http"
-	"strings"
+
+	"whisperbin/internal/secmem"
 )
 
 func (h *Handler) SSEHandler(w http.ResponseWriter, r *http.Request) {
@@ -30,6 +34,18 @@ func (h *Handler) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no")
 
+	if sec, err := h.store.Get(id); err == nil {
+		err := h.checkNetwork(r, sec)
+		if err == nil {
+			err = h.checkRecipient(r, sec)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "data: error: %s\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+	}
+
 	sec, err := h.store.WaitForUnlock(r.Context(), id)
 	if err != nil {
 		fmt.Fprintf(w, "data: error: %s\n\n", err.Error())
@@ -37,18 +53,37 @@ func (h *Handler) SSEHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	text, err := h.store.DecryptSecretText(sec)
+	// Attachments cannot travel over the event stream; the page downloads
+	// them with a regular reveal POST now that the secret is unlocked.
+	if sec.IsFile() {
+		fmt.Fprintf(w, "event: file\ndata: %s\n\n", html.EscapeString(sec.FileName))
+		flusher.Flush()
+		return
+	}
+
+	sec, err = h.store.Take(id)
 	if err != nil {
-		fmt.Fprintf(w, "data: error: decryption failed\n\n")
+		fmt.Fprintf(w, "data: error: %s\n\n", err.Error())
 		flusher.Flush()
 		return
 	}
 
-	text = strings.ReplaceAll(text, "\n", "\\n")
-	text = html.EscapeString(text)
+	text, err := h.store.DecryptSecret(sec)
+	if err != nil {
+		fmt.Fprintf(w, "data: error: decryption failed\n\n")
+		flusher.Flush()
+		return
+	}
+	defer secmem.Free(text)
 
-	fmt.Fprintf(w, "data: %s\n\n", text)
+	// Newlines would end the event, so they are sent as a literal \n.
+	io.WriteString(w, "data: ")
+	for i, line := range bytes.Split(text, []byte("\n")) {
+		if i > 0 {
+			io.WriteString(w, "\\n")
+		}
+		template.HTMLEscape(w, line)
+	}
+	io.WriteString(w, "\n\n")
 	flusher.Flush()
-
-	h.store.Delete(id)
 }
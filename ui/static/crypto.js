@@ -0,0 +1,76 @@
+// Client-side encryption for zero-knowledge mode. The key travels only in
+// the URL fragment, which browsers never send to the server.
+
+const keyStorageName = "whisperbin-key"
+
+function toBase64Url(bytes) {
+    let binary = ""
+    bytes.forEach(b => binary += String.fromCharCode(b))
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "")
+}
+
+function fromBase64Url(text) {
+    const base64 = text.replace(/-/g, "+").replace(/_/g, "/")
+    const binary = atob(base64 + "===".slice((base64.length + 3) % 4))
+    return Uint8Array.from(binary, c => c.charCodeAt(0))
+}
+
+async function encryptSecret(plaintext) {
+    const key = await crypto.subtle.generateKey({ name: "AES-GCM", length: 256 }, true, ["encrypt"])
+    const iv = crypto.getRandomValues(new Uint8Array(12))
+    const cipher = await crypto.subtle.encrypt({ name: "AES-GCM", iv }, key, new TextEncoder().encode(plaintext))
+    const blob = new Uint8Array(iv.length + cipher.byteLength)
+    blob.set(iv)
+    blob.set(new Uint8Array(cipher), iv.length)
+    const rawKey = new Uint8Array(await crypto.subtle.exportKey("raw", key))
+    return { blob: toBase64Url(blob), key: toBase64Url(rawKey) }
+}
+
+async function decryptSecret(blob, encodedKey) {
+    const data = fromBase64Url(blob)
+    const key = await crypto.subtle.importKey("raw", fromBase64Url(encodedKey), "AES-GCM", false, ["decrypt"])
+    const plain = await crypto.subtle.decrypt({ name: "AES-GCM", iv: data.slice(0, 12) }, key, data.slice(12))
+    return new TextDecoder().decode(plain)
+}
+
+function fragmentKey() {
+    return location.hash.slice(1)
+}
+
+// encryptFormSecret replaces the plaintext field with ciphertext before the
+// form is submitted and keeps the key for the "created" page.
+function encryptFormSecret(form, fieldId, toggleId) {
+    form.addEventListener("submit", async event => {
+        if (!document.getElementById(toggleId).checked || form.dataset.encrypted) {
+            return
+        }
+        event.preventDefault()
+        const field = document.getElementById(fieldId)
+        const { blob, key } = await encryptSecret(field.value.trim())
+        sessionStorage.setItem(keyStorageName, key)
+        field.value = blob
+        form.dataset.encrypted = "true"
+        form.submit()
+    })
+}
+
+function attachKeyToLink(inputId) {
+    const key = sessionStorage.getItem(keyStorageName)
+    sessionStorage.removeItem(keyStorageName)
+    const input = document.getElementById(inputId)
+    if (!key) {
+        input.value = "Encryption key missing — please create the secret again."
+        return
+    }
+    input.value += "#" + key
+}
+
+async function decryptField(inputId) {
+    const input = document.getElementById(inputId)
+    try {
+        input.value = await decryptSecret(input.value, fragmentKey())
+    } catch (e) {
+        input.value = ""
+        input.placeholder = "Could not decrypt: the link is missing its key or was altered."
+    }
+}